@@ -17,10 +17,23 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
 	dockcmdCommon "github.com/boxboat/dockcmd/cmd/common"
+	dhs "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
 	controllerv2 "github.com/boxboat/dockhand-secrets-operator/pkg/controller/v2"
 	dockhandv2 "github.com/boxboat/dockhand-secrets-operator/pkg/generated/controllers/dhs.dockhand.dev"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/health"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/metrics"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers/file"
+	k8sprovider "github.com/boxboat/dockhand-secrets-operator/pkg/providers/kubernetes"
 	"github.com/rancher/wrangler/v3/pkg/generated/controllers/apps"
 	"github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
 	"github.com/rancher/wrangler/v3/pkg/kubeconfig"
@@ -28,7 +41,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 type OperatorArgs struct {
@@ -36,8 +52,19 @@ type OperatorArgs struct {
 	KubeconfigFile                        string
 	Namespace                             string
 	CrossNamespaceProfileAccessAuthorized bool
+	MetricsAddress                        string
+	HealthAddress                         string
+	FileProviderBaseDir                   string
+	KubernetesProviderNamespaces          []string
 }
 
+const (
+	storageMigrationAttempts     = 10
+	storageMigrationRetrySeconds = 30
+	// controllerLeaseName is the Lease held by the controller replica that runs the controllers
+	controllerLeaseName = "dockhand-secrets-operator-controller"
+)
+
 var (
 	operatorArgs OperatorArgs
 )
@@ -59,6 +86,26 @@ var startOperatorCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 
 		dockcmdCommon.UseAlternateDelims = true
+		file.SetBaseDir(operatorArgs.FileProviderBaseDir)
+		k8sprovider.SetNamespaceAccess(operatorArgs.CrossNamespaceProfileAccessAuthorized, operatorArgs.KubernetesProviderNamespaces)
+
+		// only the leader runs the controllers, a replica is ready once it observed the leader of the Lease and the
+		// leader once the caches of its controllers have synced
+		var leaderKnown, leading, cachesSynced atomic.Bool
+		checker := health.NewChecker()
+		checker.AddReadinessCheck("leader", func(ctx context.Context) error {
+			if !leaderKnown.Load() {
+				return fmt.Errorf("leader of the Lease is not known")
+			}
+			return nil
+		})
+		checker.AddReadinessCheck("caches", func(ctx context.Context) error {
+			if leading.Load() && !cachesSynced.Load() {
+				return fmt.Errorf("caches have not synced")
+			}
+			return nil
+		})
+		go checker.Serve(cmd.Context(), operatorArgs.HealthAddress)
 
 		// load the kubeconfig file
 		cfg, err := kubeconfig.GetNonInteractiveClientConfig(
@@ -73,26 +120,126 @@ var startOperatorCmd = &cobra.Command{
 		dhv2 := dockhandv2.NewFactoryFromConfigOrDie(cfg)
 		kubeClient := kubernetes.NewForConfigOrDie(cfg)
 
-		controllerv2.Register(
-			cmd.Context(),
-			operatorArgs.Namespace,
-			kubeClient.CoreV1().Events(""),
-			apps.Apps().V1().DaemonSet(),
-			apps.Apps().V1().Deployment(),
-			apps.Apps().V1().StatefulSet(),
-			core.Core().V1().Secret(),
-			dhv2.Dhs().V1alpha2().Secret(),
-			dhv2.Dhs().V1alpha2().Profile(),
-			operatorArgs.CrossNamespaceProfileAccessAuthorized)
-
-		// Start all the controllers
-		if err := start.All(cmd.Context(), 2, apps, core, dhv2); err != nil {
-			logrus.Fatalf("Error starting: %s", err.Error())
+		go serveMetrics(cmd.Context(), operatorArgs.MetricsAddress)
+
+		id, err := os.Hostname()
+		if err != nil {
+			logrus.Fatalf("Error getting hostname: %s", err.Error())
 		}
-		<-cmd.Context().Done()
+		lock := &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      controllerLeaseName,
+				Namespace: operatorArgs.Namespace,
+			},
+			Client: kubeClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: id,
+			},
+		}
+
+		leaderelection.RunOrDie(cmd.Context(), leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			LeaseDuration: 15 * time.Second,
+			RenewDeadline: 10 * time.Second,
+			RetryPeriod:   2 * time.Second,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					common.Log.Infof("%s elected leader", id)
+					leading.Store(true)
+					runControllers(ctx, apps, core, dhv2, kubeClient)
+					cachesSynced.Store(true)
+				},
+				OnStoppedLeading: func() {
+					// the controllers cannot be stopped, a replica that lost the Lease exits and restarts as a follower
+					if cmd.Context().Err() == nil {
+						logrus.Fatalf("%s lost the leader Lease", id)
+					}
+					common.Log.Infof("%s no longer leading", id)
+				},
+				OnNewLeader: func(newLeaderID string) {
+					leaderKnown.Store(true)
+					if newLeaderID != id {
+						common.Log.Infof("%s elected new leader", newLeaderID)
+					}
+				},
+			},
+			ReleaseOnCancel: true,
+			Name:            id,
+		})
 	},
 }
 
+// runControllers registers and starts the controllers, then migrates the storage versions of the CRDs. It runs on
+// the leader only so that a single replica reconciles and rewrites the stored resources.
+func runControllers(ctx context.Context, apps *apps.Factory, core *core.Factory, dhv2 *dockhandv2.Factory, kubeClient kubernetes.Interface) {
+	controllerv2.Register(
+		ctx,
+		operatorArgs.Namespace,
+		kubeClient.CoreV1().Events(""),
+		apps.Apps().V1().DaemonSet(),
+		apps.Apps().V1().Deployment(),
+		apps.Apps().V1().StatefulSet(),
+		core.Core().V1().Secret(),
+		core.Core().V1().ConfigMap(),
+		dhv2.Dhs().V1beta1().Secret(),
+		dhv2.Dhs().V1beta1().Profile(),
+		dhv2.Dhs().V1beta1().PushSecret(),
+		operatorArgs.CrossNamespaceProfileAccessAuthorized)
+
+	// Start all the controllers
+	if err := start.All(ctx, 2, apps, core, dhv2); err != nil {
+		logrus.Fatalf("Error starting: %s", err.Error())
+	}
+
+	go migrateStorageVersions(ctx)
+}
+
+// migrateStorageVersions moves existing Dockhand resources to the current CRD storage version. The conversion webhook
+// may not be available yet when the controller starts so failed migrations are retried.
+func migrateStorageVersions(ctx context.Context) {
+	crds := []string{"secrets." + dhs.GroupName, "profiles." + dhs.GroupName}
+	for attempt := 1; len(crds) > 0; attempt++ {
+		var pending []string
+		for _, crd := range crds {
+			if err := k8s.MigrateStorageVersion(ctx, crd); err != nil {
+				common.Log.Warnf("storage version migration of %s failed attempt %d: %v", crd, attempt, err)
+				pending = append(pending, crd)
+			}
+		}
+		crds = pending
+		if len(crds) == 0 || attempt >= storageMigrationAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(storageMigrationRetrySeconds * time.Second):
+		}
+	}
+	if len(crds) > 0 {
+		common.Log.Errorf("unable to migrate %v to the current storage version", crds)
+	}
+}
+
+// serveMetrics serves the prometheus metrics of the controller on address until ctx is done, an empty address
+// disables the metrics server.
+func serveMetrics(ctx context.Context, address string) {
+	if address == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		common.LogIfError(server.Shutdown(context.Background()))
+	}()
+	common.Log.Infof("serving metrics on %s", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		common.Log.Errorf("metrics server failed: %v", err)
+	}
+}
+
 // setup command
 func init() {
 	rootCmd.AddCommand(startOperatorCmd)
@@ -121,5 +268,29 @@ func init() {
 		false,
 		"Allow Secrets to specify Profiles in external namespaces. i.e. Secret Alpha in namespace alpha could reference a profile in namespace Bravo")
 
+	startOperatorCmd.PersistentFlags().StringVar(
+		&operatorArgs.MetricsAddress,
+		"metrics-address",
+		":8080",
+		"Address of the prometheus metrics endpoint, an empty address disables metrics")
+
+	startOperatorCmd.PersistentFlags().StringVar(
+		&operatorArgs.HealthAddress,
+		"health-address",
+		":8081",
+		"Address of the /healthz and /readyz endpoints, an empty address disables them")
+
+	startOperatorCmd.PersistentFlags().StringVar(
+		&operatorArgs.FileProviderBaseDir,
+		"file-provider-base-dir",
+		"",
+		"Directory that the path of file Profiles is resolved in and must not leave, an empty directory disables paths")
+
+	startOperatorCmd.PersistentFlags().StringSliceVar(
+		&operatorArgs.KubernetesProviderNamespaces,
+		"kubernetes-provider-namespaces",
+		nil,
+		"Namespaces whose Secrets kubernetes Profiles in other namespaces can read, any namespace can be read with --allow-cross-namespace")
+
 	_ = viper.BindPFlags(startOperatorCmd.PersistentFlags())
 }
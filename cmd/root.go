@@ -17,12 +17,15 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
-	log "github.com/sirupsen/logrus"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -30,38 +33,57 @@ import (
 )
 
 var (
-	CfgFile string
-	debug   bool
+	CfgFile   string
+	debug     bool
+	logFormat string
+	logLevel  string
+
+	tracingEndpoint string
+	tracingInsecure bool
+	// shutdownTracing flushes the pending spans when the command exits
+	shutdownTracing = func(context.Context) error { return nil }
 )
 
 // rootCmdPersistentPreRunE configures logging
 func rootCmdPersistentPreRunE(cmd *cobra.Command, args []string) error {
-	common.Log.SetOutput(os.Stdout)
-	common.Log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
+	levels := logLevel
 	if debug {
-		common.Log.SetLevel(log.DebugLevel)
-	} else {
-		common.Log.SetLevel(log.InfoLevel)
+		levels += ",debug"
+	}
+	if err := common.ConfigureLogging(os.Stdout, logFormat, levels); err != nil {
+		return err
 	}
+	shutdown, err := tracing.Setup(context.Background(), "dockhand-secrets-operator-"+cmd.Name(), tracingEndpoint, tracingInsecure)
+	if err != nil {
+		return err
+	}
+	shutdownTracing = shutdown
 	common.Log.Debugln("rootCmdPersistentPreRunE")
 	return nil
 }
 
+// rootCmdPersistentPostRun flushes the pending spans
+func rootCmdPersistentPostRun(cmd *cobra.Command, args []string) {
+	common.LogIfError(shutdownTracing(context.Background()))
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:               "dockhand-secrets-operator",
 	Short:             "Dockhand Secrets Operator",
 	Long:              `Dockhand Secrets Operator to facilitate secrets platform integration through CRDs`,
 	PersistentPreRunE: rootCmdPersistentPreRunE,
+	PersistentPostRun: rootCmdPersistentPostRun,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute(version string) {
 	rootCmd.Version = version
-	if err := rootCmd.Execute(); err != nil {
+	// commands return on SIGINT and SIGTERM so that the pending spans are flushed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -70,7 +92,31 @@ func Execute(version string) {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "", false, "debug output")
+	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "", false, "debug output, sets the default log level to debug")
+
+	rootCmd.PersistentFlags().StringVar(
+		&logFormat,
+		"log-format",
+		common.LogFormatText,
+		"Log format, text or json")
+
+	rootCmd.PersistentFlags().StringVar(
+		&logLevel,
+		"log-level",
+		"info",
+		"Default log level and component=level pairs e.g. info,controller=debug,provider.vault=trace. Components are controller, webhook, certmanager and provider.<name>, provider sets every provider")
+
+	rootCmd.PersistentFlags().StringVar(
+		&tracingEndpoint,
+		"tracing-endpoint",
+		"",
+		"host:port of the OTLP gRPC collector spans are exported to, an empty endpoint disables tracing")
+
+	rootCmd.PersistentFlags().BoolVar(
+		&tracingInsecure,
+		"tracing-insecure",
+		false,
+		"Export spans to the OTLP collector without TLS")
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -26,8 +26,9 @@ import (
 	"syscall"
 	"time"
 
-	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dhs "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/health"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/webhook"
 	"github.com/spf13/cobra"
@@ -35,6 +36,19 @@ import (
 	"k8s.io/client-go/tools/leaderelection"
 )
 
+// certificateWaitAttempts limits the time the webhook waits for its first certificate
+const certificateWaitAttempts = 10
+
+// Sources of the certificate served by the webhook
+const (
+	// certSourceSelfSigned the webhook issues a self-signed certificate and configures the CA bundles
+	certSourceSelfSigned = "self-signed"
+	// certSourceFile the webhook serves the --cert and --key files
+	certSourceFile = "file"
+	// certSourceCertManager the webhook serves a Secret issued by cert-manager, which injects the CA bundles
+	certSourceCertManager = "cert-manager"
+)
+
 type ServerArgs struct {
 	serverPort       int
 	serverCert       string
@@ -43,10 +57,21 @@ type ServerArgs struct {
 	serviceId        string
 	serviceNamespace string
 	selfSignCerts    bool
+	certSource       string
+	certSecret       string
+	healthAddress    string
 }
 
 var (
 	serverArgs ServerArgs
+	// conversionCRDs are the CRDs served by the conversion webhook
+	conversionCRDs = []string{
+		"secrets." + dhs.GroupName,
+		"profiles." + dhs.GroupName,
+	}
+	certManagerLog = common.ComponentLogger("certmanager")
+	// certificates holds the certificate served by the webhook, nil until one is found
+	certificates = &webhook.CertificateReloader{}
 )
 
 func runCertManager(ctx context.Context) {
@@ -71,7 +96,7 @@ func runCertManager(ctx context.Context) {
 }
 
 func onStartedLeading(ctx context.Context) {
-	common.Log.Infof("elected leader")
+	certManagerLog.Infof("elected leader")
 	ensureTLSCertificateSecretInCluster(ctx)
 	for {
 		select {
@@ -85,86 +110,85 @@ func onStartedLeading(ctx context.Context) {
 }
 
 func onStoppedLeading() {
-	common.Log.Infof("no longer leading")
+	certManagerLog.Infof("no longer leading")
 }
 
 func onNewLeader(id string) func(string) {
 	return func(newLeaderID string) {
 		if newLeaderID != id {
-			common.Log.Infof("%s elected new leader", newLeaderID)
+			certManagerLog.Infof("%s elected new leader", newLeaderID)
 		}
 	}
 }
 
+// ensureTLSCertificateSecretInCluster advances the rotation of the self-signed certificate of the webhook and
+// configures the CA bundle of its TLS Secret. The rotation only advances once the current CA bundle is configured.
 func ensureTLSCertificateSecretInCluster(ctx context.Context) {
 
-	common.Log.Infof("checking certificate %s/%s", serverArgs.serviceNamespace, serverArgs.serviceName)
-	cert, caPem, err := k8s.GetServiceCertificate(ctx, serverArgs.serviceName, serverArgs.serviceNamespace)
-	if err != nil && !errors.IsNotFound(err) {
+	certManagerLog.Infof("checking certificate %s/%s", serverArgs.serviceNamespace, serverArgs.serviceName)
+	tlsSecret, err := k8s.GetTlsCertificateSecret(ctx, serverArgs.serviceName, serverArgs.serviceNamespace)
+	if errors.IsNotFound(err) {
+		tlsSecret = nil
+	} else if err != nil {
 		common.ExitIfError(err)
+	} else if err := updateCABundles(ctx, tlsSecret.Data[webhook.CABundleKey]); err != nil {
+		certManagerLog.Warnf("unable to update CA bundle, certificate rotation is retried: %v", err)
+		return
 	}
-	if cert == nil || common.ValidDaysRemaining(cert.Certificate[0]) < 30 {
-		common.Log.Infof("Renewing self signed certificate")
-		caPem, caKey, err := common.GenerateSelfSignedCA(serverArgs.serviceName + "-ca")
-		common.ExitIfError(err)
-		err = k8s.UpdateCABundleForWebhook(ctx, serverArgs.serviceName+".dhs.dockhand.dev", caPem)
-		common.ExitIfError(err)
-		dnsNames := []string{
-			serverArgs.serviceName + "." + serverArgs.serviceNamespace,
-			serverArgs.serviceName + "." + serverArgs.serviceNamespace + ".svc"}
 
-		cert, key, err := common.GenerateSignedCert(serverArgs.serviceName, dnsNames, caPem, caKey)
-		common.ExitIfError(err)
-		err = k8s.UpdateTlsCertificateSecret(ctx, serverArgs.serviceName, serverArgs.serviceNamespace, cert, key, caPem)
-		common.ExitIfError(err)
-
-		deploy, err := k8s.GetDeployment(ctx, serverArgs.serviceName, serverArgs.serviceNamespace)
-		common.ExitIfError(err)
-		checksum, err := k8s.GetSecretsChecksum(ctx, []string{serverArgs.serviceName}, serverArgs.serviceNamespace)
-		common.ExitIfError(err)
-		if deploy.Spec.Template.Annotations == nil {
-			deploy.Spec.Template.Annotations = make(map[string]string)
-		}
-		deploy.Spec.Template.Annotations[dockhand.SecretChecksumAnnotationKey] = checksum
-		_, err = k8s.UpdateDeployment(ctx, deploy, serverArgs.serviceNamespace)
-		if err != nil {
-			common.Log.Warnf("Could not update deployment %v", err)
-		}
-	} else {
-		err = k8s.UpdateCABundleForWebhook(ctx, serverArgs.serviceName+".dhs.dockhand.dev", caPem)
-		common.LogIfError(err)
+	updated, err := webhook.RotateCertificate(tlsSecret, serverArgs.serviceName, serverArgs.serviceNamespace, time.Now())
+	common.ExitIfError(err)
+	if updated == nil {
+		return
 	}
+	// the webhook replicas watch the Secret and serve the new certificate without restarting
+	if err := k8s.UpdateTlsCertificateSecret(ctx, updated); err != nil {
+		certManagerLog.Warnf("unable to update certificate secret, certificate rotation is retried: %v", err)
+		return
+	}
+	certManagerLog.LogIfError(updateCABundles(ctx, updated.Data[webhook.CABundleKey]))
+}
 
+// updateCABundles configures caBundle in the mutating webhook and the conversion webhooks
+func updateCABundles(ctx context.Context, caBundle []byte) error {
+	if err := k8s.UpdateCABundleForWebhook(ctx, serverArgs.serviceName+".dhs.dockhand.dev", caBundle); err != nil {
+		return err
+	}
+	return k8s.UpdateCABundleForCRDConversion(ctx, conversionCRDs, caBundle)
 }
 
-func runServer(ctx context.Context) {
-	var err error
-	tlsPair := tls.Certificate{}
-	if serverArgs.selfSignCerts {
+func runServer(ctx context.Context, certSource string) {
+	// the webhook is ready once it serves a valid certificate and can reach the kubernetes API server
+	checker := health.NewChecker()
+	checker.AddReadinessCheck("certificate", func(ctx context.Context) error {
+		return common.CheckCertificateValid(certificates.Certificate())
+	})
+	checker.AddReadinessCheck("kubernetes", k8s.CheckAPIServer)
+	healthCtx, stopHealth := context.WithCancel(ctx)
+	defer stopHealth()
+	go checker.Serve(healthCtx, serverArgs.healthAddress)
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	switch certSource {
+	case certSourceSelfSigned:
 		leaderCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 		go runCertManager(leaderCtx)
-	} else {
-		tlsPair, err = tls.LoadX509KeyPair(serverArgs.serverCert, serverArgs.serverKey)
-		common.ExitIfError(err)
+		common.ExitIfError(certificates.WatchSecret(watchCtx, serverArgs.serviceName, serverArgs.serviceNamespace))
+	case certSourceCertManager:
+		// cert-manager renews the Secret and injects its CA into the webhook configurations
+		common.ExitIfError(certificates.WatchSecret(watchCtx, getCertSecret(), serverArgs.serviceNamespace))
+	default:
+		common.ExitIfError(certificates.WatchFiles(watchCtx, serverArgs.serverCert, serverArgs.serverKey))
 	}
 
-	attempt := 0
-	for {
-		if attempt < 10 {
-			cert, _, err := k8s.GetServiceCertificate(ctx, serverArgs.serviceName, serverArgs.serviceNamespace)
-			if errors.IsNotFound(err) {
-				time.Sleep(5 * time.Second)
-			} else if err != nil {
-				common.Log.Warnf("error retrieving certificate, %v", err)
-			} else {
-				tlsPair = *cert
-				break
-			}
-			attempt += 1
-		} else {
-			common.ExitIfError(fmt.Errorf("unable to retrieve a certificate after 10 attempts - exiting"))
+	// the certificate manager or cert-manager creates the Secret when the webhook is first installed
+	for attempt := 0; certificates.Certificate() == nil; attempt++ {
+		if attempt == certificateWaitAttempts {
+			common.ExitIfError(fmt.Errorf("unable to retrieve a certificate after %d attempts - exiting", certificateWaitAttempts))
 		}
+		time.Sleep(5 * time.Second)
 	}
 
 	common.Log.Infof("Starting server")
@@ -172,7 +196,7 @@ func runServer(ctx context.Context) {
 	server := &webhook.Server{
 		Server: &http.Server{
 			Addr:      fmt.Sprintf(":%v", serverArgs.serverPort),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{tlsPair}},
+			TLSConfig: &tls.Config{GetCertificate: certificates.GetCertificate},
 		},
 	}
 
@@ -180,6 +204,7 @@ func runServer(ctx context.Context) {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", server.Serve)
+	mux.HandleFunc("/convert", server.Convert)
 	server.Server.Handler = mux
 
 	go func() {
@@ -199,12 +224,43 @@ func runServer(ctx context.Context) {
 	}
 }
 
+// getCertSource returns the source of the webhook certificate, --self-sign-certs=false selects the files when
+// --cert-source is not set
+func getCertSource(cmd *cobra.Command) (string, error) {
+	if !cmd.Flags().Changed("cert-source") && !serverArgs.selfSignCerts {
+		return certSourceFile, nil
+	}
+	switch serverArgs.certSource {
+	case certSourceSelfSigned, certSourceFile, certSourceCertManager:
+		return serverArgs.certSource, nil
+	}
+	return "", fmt.Errorf(
+		"unsupported cert source %s, expected %s, %s or %s",
+		serverArgs.certSource,
+		certSourceSelfSigned,
+		certSourceFile,
+		certSourceCertManager)
+}
+
+// getCertSecret returns the name of the Secret issued by cert-manager, which defaults to the service name
+func getCertSecret() string {
+	if serverArgs.certSecret != "" {
+		return serverArgs.certSecret
+	}
+	return serverArgs.serviceName
+}
+
 var startServerCmd = &cobra.Command{
 	Use:   "server",
 	Short: "webhook server",
 	Long:  `start the server with the provided settings`,
-	Run: func(cmd *cobra.Command, args []string) {
-		runServer(cmd.Context())
+	RunE: func(cmd *cobra.Command, args []string) error {
+		certSource, err := getCertSource(cmd)
+		if err != nil {
+			return err
+		}
+		runServer(cmd.Context(), certSource)
+		return nil
 	},
 }
 
@@ -253,5 +309,24 @@ func init() {
 		"self-sign-certs",
 		true,
 		"use k8s api to obtain self signed certificates")
+	_ = startServerCmd.Flags().MarkDeprecated("self-sign-certs", "use --cert-source=file instead of --self-sign-certs=false")
+
+	startServerCmd.Flags().StringVar(
+		&serverArgs.certSource,
+		"cert-source",
+		certSourceSelfSigned,
+		"Source of the webhook certificate: self-signed, file to serve --cert and --key, or cert-manager to serve the Secret --cert-secret issued by cert-manager")
+
+	startServerCmd.Flags().StringVar(
+		&serverArgs.certSecret,
+		"cert-secret",
+		"",
+		"Secret issued by cert-manager with --cert-source=cert-manager, defaults to the service name")
+
+	startServerCmd.Flags().StringVar(
+		&serverArgs.healthAddress,
+		"health-address",
+		":8081",
+		"Address of the /healthz and /readyz endpoints, an empty address disables them")
 
 }
@@ -0,0 +1,233 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConversionSpecAnnotationKey holds the v1beta1 spec of a Secret or Profile when it contains fields that cannot be
+// represented in v1alpha2, so that a round trip through v1alpha2 does not lose them.
+const ConversionSpecAnnotationKey = "conversion.dhs.dockhand.dev/v1beta1-spec"
+
+// ConvertTo converts this Profile to the v1beta1 storage version.
+func (src *Profile) ConvertTo(dst *v1beta1.Profile) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.APIVersion, dst.Kind = v1beta1.SchemeGroupVersion.WithKind("Profile").ToAPIVersionAndKind()
+	dst.Spec = v1beta1.ProfileSpec{}
+	if err := restoreConversionSpec(&dst.ObjectMeta, &dst.Spec); err != nil {
+		return err
+	}
+
+	// backends present in v1alpha2 always take precedence over the stored spec since they may have been edited
+	var backends v1beta1.ProfileSpec
+	if err := convertJSON(src.backends(), &backends); err != nil {
+		return err
+	}
+	dst.Spec.AwsSecretsManager = backends.AwsSecretsManager
+	dst.Spec.AzureKeyVault = backends.AzureKeyVault
+	dst.Spec.GcpSecretsManager = backends.GcpSecretsManager
+	dst.Spec.Vault = backends.Vault
+
+	dst.Status = v1beta1.ProfileResourceStatus{}
+	return convertJSON(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts the v1beta1 storage version to this Profile.
+func (dst *Profile) ConvertFrom(src *v1beta1.Profile) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.APIVersion, dst.Kind = SchemeGroupVersion.WithKind("Profile").ToAPIVersionAndKind()
+	var backends profileBackends
+	if err := convertJSON(&src.Spec, &backends); err != nil {
+		return err
+	}
+	dst.AwsSecretsManager = backends.AwsSecretsManager
+	dst.AzureKeyVault = backends.AzureKeyVault
+	dst.GcpSecretsManager = backends.GcpSecretsManager
+	dst.Vault = backends.Vault
+	dst.Status = ProfileStatus{}
+	if err := convertJSON(&src.Status, &dst.Status); err != nil {
+		return err
+	}
+
+	// preserve v1beta1 only backends e.g. file in an annotation
+	roundTrip := &v1beta1.Profile{}
+	if err := dst.ConvertTo(roundTrip); err != nil {
+		return err
+	}
+	if !equality.Semantic.DeepEqual(roundTrip.Spec, src.Spec) {
+		return storeConversionSpec(&dst.ObjectMeta, &src.Spec)
+	}
+	return nil
+}
+
+// ConvertTo converts this Secret to the v1beta1 storage version.
+func (src *Secret) ConvertTo(dst *v1beta1.Secret) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.APIVersion, dst.Kind = v1beta1.SchemeGroupVersion.WithKind("Secret").ToAPIVersionAndKind()
+
+	dst.Spec = v1beta1.SecretSpec{}
+	if err := restoreConversionSpec(&dst.ObjectMeta, &dst.Spec); err != nil {
+		return err
+	}
+
+	// fields present in v1alpha2 always take precedence over the stored spec since they may have been edited
+	syncInterval, err := parseSyncInterval(src.SyncInterval)
+	if err != nil {
+		return err
+	}
+	dst.Spec.SyncInterval = metav1.Duration{Duration: syncInterval}
+	dst.Spec.Profile = v1beta1.ProfileRef{
+		Name:      src.Profile.Name,
+		Namespace: src.Profile.Namespace,
+	}
+	dst.Spec.Data = copyStringMap(src.Data)
+	dst.Spec.ManagedSecret = v1beta1.ManagedSecretSpec{
+		Name:        src.SecretSpec.Name,
+		Type:        src.SecretSpec.Type,
+		Labels:      copyStringMap(src.SecretSpec.Labels),
+		Annotations: copyStringMap(src.SecretSpec.Annotations),
+	}
+
+	dst.Status = v1beta1.SecretStatus{
+		State:                         v1beta1.SecretState(src.Status.State),
+		ObservedAnnotationChecksum:    src.Status.ObservedAnnotationChecksum,
+		ObservedGeneration:            src.Status.ObservedGeneration,
+		ObservedSecretResourceVersion: src.Status.ObservedSecretResourceVersion,
+	}
+	if syncTime, err := time.Parse(time.RFC3339, src.Status.SyncTimestamp); err == nil {
+		dst.Status.SyncTimestamp = &metav1.Time{Time: syncTime}
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 storage version to this Secret.
+func (dst *Secret) ConvertFrom(src *v1beta1.Secret) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.APIVersion, dst.Kind = SchemeGroupVersion.WithKind("Secret").ToAPIVersionAndKind()
+
+	dst.SyncInterval = src.Spec.SyncInterval.Duration.String()
+	dst.Profile = ProfileRef{
+		Name:      src.Spec.Profile.Name,
+		Namespace: src.Spec.Profile.Namespace,
+	}
+	dst.Data = copyStringMap(src.Spec.Data)
+	dst.SecretSpec = SecretSpec{
+		Name:        src.Spec.ManagedSecret.Name,
+		Type:        src.Spec.ManagedSecret.Type,
+		Labels:      copyStringMap(src.Spec.ManagedSecret.Labels),
+		Annotations: copyStringMap(src.Spec.ManagedSecret.Annotations),
+	}
+
+	dst.Status = SecretStatus{
+		State:                         SecretState(src.Status.State),
+		ObservedAnnotationChecksum:    src.Status.ObservedAnnotationChecksum,
+		ObservedGeneration:            src.Status.ObservedGeneration,
+		ObservedSecretResourceVersion: src.Status.ObservedSecretResourceVersion,
+	}
+	if src.Status.SyncTimestamp != nil {
+		dst.Status.SyncTimestamp = src.Status.SyncTimestamp.Format(time.RFC3339)
+	}
+
+	// preserve v1beta1 only fields e.g. dataFrom in an annotation
+	roundTrip := &v1beta1.Secret{}
+	if err := dst.ConvertTo(roundTrip); err != nil {
+		return err
+	}
+	if !equality.Semantic.DeepEqual(roundTrip.Spec, src.Spec) {
+		return storeConversionSpec(&dst.ObjectMeta, &src.Spec)
+	}
+	return nil
+}
+
+// restoreConversionSpec unmarshals the v1beta1 spec stored by storeConversionSpec into spec and removes the annotation
+func restoreConversionSpec(meta *metav1.ObjectMeta, spec interface{}) error {
+	stored, ok := meta.Annotations[ConversionSpecAnnotationKey]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(stored), spec); err != nil {
+		return err
+	}
+	delete(meta.Annotations, ConversionSpecAnnotationKey)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+	return nil
+}
+
+// storeConversionSpec stores the v1beta1 spec in the ConversionSpecAnnotationKey annotation
+func storeConversionSpec(meta *metav1.ObjectMeta, spec interface{}) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[ConversionSpecAnnotationKey] = string(data)
+	return nil
+}
+
+// profileBackends mirrors the backend fields of the v1alpha2 Profile, which are identical to v1beta1.ProfileSpec.
+type profileBackends struct {
+	AwsSecretsManager *AwsSecretsManager `json:"awsSecretsManager,omitempty"`
+	AzureKeyVault     *AzureKeyVault     `json:"azureKeyVault,omitempty"`
+	GcpSecretsManager *GcpSecretsManager `json:"gcpSecretsManager,omitempty"`
+	Vault             *Vault             `json:"vault,omitempty"`
+}
+
+func (src *Profile) backends() *profileBackends {
+	return &profileBackends{
+		AwsSecretsManager: src.AwsSecretsManager,
+		AzureKeyVault:     src.AzureKeyVault,
+		GcpSecretsManager: src.GcpSecretsManager,
+		Vault:             src.Vault,
+	}
+}
+
+// convertJSON copies between structurally identical types of different versions.
+func convertJSON(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func parseSyncInterval(syncInterval string) (time.Duration, error) {
+	if syncInterval == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(syncInterval)
+}
+
+func copyStringMap(source map[string]string) map[string]string {
+	if source == nil {
+		return nil
+	}
+	mapCopy := make(map[string]string, len(source))
+	for k, v := range source {
+		mapCopy[k] = v
+	}
+	return mapCopy
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProfileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		spec v1beta1.ProfileSpec
+	}{
+		{
+			name: "v1alpha2 backends",
+			spec: v1beta1.ProfileSpec{
+				AwsSecretsManager: &v1beta1.AwsSecretsManager{CacheTTL: "60s", Region: "us-east-1"},
+				Vault:             &v1beta1.Vault{CacheTTL: "60s", Addr: "https://vault:8200"},
+			},
+		},
+		{
+			name: "v1beta1 only backends",
+			spec: v1beta1.ProfileSpec{
+				Vault:      &v1beta1.Vault{CacheTTL: "60s", Addr: "https://vault:8200"},
+				File:       &v1beta1.File{ConfigMapRef: &v1beta1.ConfigMapRef{Name: "secrets", Key: "secrets.yaml"}},
+				Kubernetes: &v1beta1.Kubernetes{Namespaces: []string{"shared"}},
+				Failover: &v1beta1.Failover{
+					Timeout:   metav1.Duration{Duration: 5 * time.Second},
+					Fallbacks: []v1beta1.FallbackProfileRef{{Name: "standby"}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := &v1beta1.Profile{
+				ObjectMeta: metav1.ObjectMeta{Name: "profile", Namespace: "default"},
+				Spec:       test.spec,
+			}
+			converted := &Profile{}
+			if err := converted.ConvertFrom(src); err != nil {
+				t.Fatalf("ConvertFrom: %v", err)
+			}
+			dst := &v1beta1.Profile{}
+			if err := converted.ConvertTo(dst); err != nil {
+				t.Fatalf("ConvertTo: %v", err)
+			}
+			if !equality.Semantic.DeepEqual(dst.Spec, src.Spec) {
+				t.Errorf("round trip spec = %+v, want %+v", dst.Spec, src.Spec)
+			}
+			if _, ok := dst.Annotations[ConversionSpecAnnotationKey]; ok {
+				t.Errorf("round trip kept the %s annotation", ConversionSpecAnnotationKey)
+			}
+		})
+	}
+}
+
+func TestProfileConvertToPrefersV1alpha2Backends(t *testing.T) {
+	src := &v1beta1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "profile", Namespace: "default"},
+		Spec: v1beta1.ProfileSpec{
+			Vault: &v1beta1.Vault{CacheTTL: "60s", Addr: "https://vault:8200"},
+			File:  &v1beta1.File{Path: "secrets.yaml"},
+		},
+	}
+	converted := &Profile{}
+	if err := converted.ConvertFrom(src); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+
+	// edit the v1alpha2 backends and remove vault
+	converted.Vault = nil
+	converted.AwsSecretsManager = &AwsSecretsManager{CacheTTL: "30s", Region: "us-west-2"}
+	dst := &v1beta1.Profile{}
+	if err := converted.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+
+	want := v1beta1.ProfileSpec{
+		AwsSecretsManager: &v1beta1.AwsSecretsManager{CacheTTL: "30s", Region: "us-west-2"},
+		File:              &v1beta1.File{Path: "secrets.yaml"},
+	}
+	if !equality.Semantic.DeepEqual(dst.Spec, want) {
+		t.Errorf("spec = %+v, want %+v", dst.Spec, want)
+	}
+}
+
+func TestProfileStatusRoundTrip(t *testing.T) {
+	status := v1beta1.ProfileResourceStatus{
+		Dependents: []v1beta1.ProfileDependent{
+			{Kind: "Secret", Namespace: "default", Name: "app"},
+			{Kind: "Secret", Count: 2},
+		},
+		Conditions: []metav1.Condition{
+			{
+				Type:               v1beta1.ConditionReady,
+				Status:             metav1.ConditionTrue,
+				Reason:             v1beta1.ReasonHealthy,
+				LastTransitionTime: metav1.NewTime(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)),
+			},
+			{
+				Type:               v1beta1.ConditionDeletionBlocked,
+				Status:             metav1.ConditionTrue,
+				Reason:             v1beta1.ReasonInUse,
+				Message:            "Profile is used by Secret default/app",
+				LastTransitionTime: metav1.NewTime(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)),
+			},
+		},
+	}
+	src := &v1beta1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "profile", Namespace: "default"},
+		Spec:       v1beta1.ProfileSpec{Vault: &v1beta1.Vault{CacheTTL: "60s", Addr: "https://vault:8200"}},
+		Status:     status,
+	}
+	converted := &Profile{}
+	if err := converted.ConvertFrom(src); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if len(converted.Status.Conditions) != 2 || len(converted.Status.Dependents) != 2 {
+		t.Fatalf("v1alpha2 status = %+v, want the v1beta1 status", converted.Status)
+	}
+
+	dst := &v1beta1.Profile{}
+	if err := converted.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(dst.Status, status) {
+		t.Errorf("round trip status = %+v, want %+v", dst.Status, status)
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	src := &v1beta1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+		Spec: v1beta1.SecretSpec{
+			Profile:      v1beta1.ProfileRef{Name: "profile"},
+			SyncInterval: metav1.Duration{Duration: time.Minute},
+			Data:         map[string]string{"password": `<< vault "secret/app" "password" >>`},
+			DataFrom:     []v1beta1.DataFromSource{{Vault: &v1beta1.VaultSecretSource{Path: "secret/app"}}},
+			ManagedSecret: v1beta1.ManagedSecretSpec{
+				Name:   "app",
+				Type:   "Opaque",
+				Labels: map[string]string{v1beta1.AutoUpdateLabelKey: "true"},
+			},
+		},
+	}
+	converted := &Secret{}
+	if err := converted.ConvertFrom(src); err != nil {
+		t.Fatalf("ConvertFrom: %v", err)
+	}
+	if _, ok := converted.Annotations[ConversionSpecAnnotationKey]; !ok {
+		t.Fatalf("dataFrom was not stored in the %s annotation", ConversionSpecAnnotationKey)
+	}
+	dst := &v1beta1.Secret{}
+	if err := converted.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(dst.Spec, src.Spec) {
+		t.Errorf("round trip spec = %+v, want %+v", dst.Spec, src.Spec)
+	}
+}
@@ -83,6 +83,24 @@ type Profile struct {
 	AzureKeyVault     *AzureKeyVault     `json:"azureKeyVault,omitempty"`
 	GcpSecretsManager *GcpSecretsManager `json:"gcpSecretsManager,omitempty"`
 	Vault             *Vault             `json:"vault,omitempty"`
+
+	// Status mirrors the v1beta1 status, it is a subresource so that writes of v1alpha2 manifests keep it
+	Status ProfileStatus `json:"status,omitempty"`
+}
+
+// ProfileStatus reports the health of the backends of a Profile and the resources that depend on it while it is being
+// deleted
+type ProfileStatus struct {
+	Dependents []ProfileDependent `json:"dependents,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ProfileDependent is a Secret, PushSecret or Profile that references a Profile
+type ProfileDependent struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Count     int    `json:"count,omitempty"`
 }
 
 // +genclient
@@ -0,0 +1,440 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	AutoUpdateLabelKey                            = "dhs.dockhand.dev/autoUpdate"
+	DockhandSecretLabelKey                        = "dhs.dockhand.dev/ownedByDockhandSecret"
+	DockhandSecretNamesLabelPrefixKey             = "secret.dhs.dockhand.dev/"
+	SecretNamesAnnotationKey                      = "dhs.dockhand.dev/secretNames"
+	SecretChecksumAnnotationKey                   = "dhs.dockhand.dev/secretChecksum"
+	GeneratorRotationsAnnotationKey               = "dhs.dockhand.dev/generatorRotations"
+	ForceDeleteAnnotationKey                      = "dhs.dockhand.dev/force-delete"
+	CARotationPhaseAnnotationKey                  = "dhs.dockhand.dev/caRotationPhase"
+	CARotationTimestampAnnotationKey              = "dhs.dockhand.dev/caRotationTimestamp"
+	Ready                             SecretState = "Ready"
+	Pending                           SecretState = "Pending"
+	ErrApplied                        SecretState = "ErrApplied"
+	Degraded                          SecretState = "Degraded"
+)
+
+// Condition types and reasons reported in the status of a Secret, PushSecret or Profile
+const (
+	ConditionReady            = "Ready"
+	ConditionDataValid        = "DataValid"
+	ReasonValid               = "Valid"
+	ReasonMissingKey          = "MissingKey"
+	ReasonInvalidCertificate  = "InvalidCertificate"
+	ReasonCertificateExpired  = "CertificateExpired"
+	ReasonKeyMismatch         = "KeyMismatch"
+	ReasonInvalidDockerConfig = "InvalidDockerConfig"
+	ConditionDegraded         = "Degraded"
+	ReasonSynced              = "Synced"
+	ReasonBackendUnavailable  = "BackendUnavailable"
+	ReasonStalenessExceeded   = "StalenessExceeded"
+	ConditionDeletionBlocked  = "DeletionBlocked"
+	ReasonInUse               = "InUse"
+	ReasonForceDeleted        = "ForceDeleted"
+	ConditionSynced           = "Synced"
+	ReasonPushed              = "Pushed"
+	ReasonConflict            = "Conflict"
+	ReasonHealthy             = "Healthy"
+	ReasonInvalidConfig       = "InvalidConfig"
+)
+
+// Policies of a PushSecret
+const (
+	DeletionPolicyRetain   = "Retain"
+	DeletionPolicyDelete   = "Delete"
+	ConflictPolicyFail     = "Fail"
+	ConflictPolicyOverride = "Override"
+)
+
+type SecretState string
+
+// SecretRef specifies a reference to a Secret
+type SecretRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// AwsSecretsManager specifies the configuration for accessing AWS Secrets.
+type AwsSecretsManager struct {
+	CacheTTL           string     `json:"cacheTTL"`
+	Region             string     `json:"region"`
+	AccessKeyId        *string    `json:"accessKeyId,omitempty"`
+	SecretAccessKeyRef *SecretRef `json:"secretAccessKeyRef,omitempty"`
+}
+
+// AwsParameterStore specifies the configuration for accessing AWS Systems Manager Parameter Store parameters, with
+// the same credential options as AwsSecretsManager.
+type AwsParameterStore struct {
+	CacheTTL           string     `json:"cacheTTL"`
+	Region             string     `json:"region"`
+	AccessKeyId        *string    `json:"accessKeyId,omitempty"`
+	SecretAccessKeyRef *SecretRef `json:"secretAccessKeyRef,omitempty"`
+}
+
+// AzureKeyVault specifies the configuration for accessing Azure Key Vault secrets.
+type AzureKeyVault struct {
+	CacheTTL        string     `json:"cacheTTL"`
+	Tenant          string     `json:"tenant"`
+	ClientId        *string    `json:"clientId,omitempty"`
+	ClientSecretRef *SecretRef `json:"clientSecretRef,omitempty"`
+	KeyVault        string     `json:"keyVault"`
+}
+
+type GcpSecretsManager struct {
+	CacheTTL                 string     `json:"cacheTTL"`
+	Project                  string     `json:"project"`
+	CredentialsFileSecretRef *SecretRef `json:"credentialsFileSecretRef"`
+}
+
+// Vault specifies the configuration for accessing Vault secrets.
+type Vault struct {
+	CacheTTL    string     `json:"cacheTTL"`
+	Addr        string     `json:"addr"`
+	RoleId      *string    `json:"roleId,omitempty"`
+	SecretIdRef *SecretRef `json:"secretIdRef,omitempty"`
+	TokenRef    *SecretRef `json:"tokenRef,omitempty"`
+}
+
+// File specifies a YAML or JSON document of secrets held in a ConfigMap or a file mounted in the operator, which is
+// intended for development and offline testing. Path must be within the --file-provider-base-dir of the operator.
+type File struct {
+	CacheTTL     string        `json:"cacheTTL,omitempty"`
+	ConfigMapRef *ConfigMapRef `json:"configMapRef,omitempty"`
+	Path         string        `json:"path,omitempty"`
+}
+
+// Kubernetes specifies the namespaces whose Secrets can be read with the kubernetes provider. The namespace of the
+// Profile is used when no namespaces are listed, other namespaces must be allowed by the operator.
+type Kubernetes struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Sops specifies the private keys used to decrypt the SOPS encrypted data of Dockhand Secrets. At least one of
+// ageKeyRef or pgpKeyRef is required.
+type Sops struct {
+	AgeKeyRef *SecretRef `json:"ageKeyRef,omitempty"`
+	PgpKeyRef *SecretRef `json:"pgpKeyRef,omitempty"`
+}
+
+// Http specifies the configuration for retrieving secrets from an HTTPS JSON API. Secrets are requested by their path
+// relative to url and selector, a JSONPath expression, locates the secret in the response.
+type Http struct {
+	CacheTTL             string       `json:"cacheTTL"`
+	URL                  string       `json:"url"`
+	Selector             string       `json:"selector,omitempty"`
+	Headers              []HttpHeader `json:"headers,omitempty"`
+	BearerTokenRef       *SecretRef   `json:"bearerTokenRef,omitempty"`
+	ClientCertificateRef *SecretRef   `json:"clientCertificateRef,omitempty"`
+	ClientKeyRef         *SecretRef   `json:"clientKeyRef,omitempty"`
+	CABundle             string       `json:"caBundle,omitempty"`
+}
+
+// HttpHeader specifies a request header with either a value or a reference to a secret containing the value
+type HttpHeader struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value,omitempty"`
+	ValueRef *SecretRef `json:"valueRef,omitempty"`
+}
+
+// ConfigMapRef specifies a reference to a ConfigMap key
+type ConfigMapRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Profile is a specification for a DockhandProfile resource
+type Profile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProfileSpec           `json:"spec"`
+	Status ProfileResourceStatus `json:"status,omitempty"`
+}
+
+// ProfileResourceStatus reports the health of the backends of a Profile and the resources that depend on it while it
+// is being deleted
+type ProfileResourceStatus struct {
+	Dependents []ProfileDependent `json:"dependents,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ProfileDependent is a Secret, PushSecret or Profile that references a Profile. Dependents outside the namespace of
+// the Profile are only counted per kind, without a namespace and name.
+type ProfileDependent struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Count of the dependents of Kind outside the namespace of the Profile
+	Count int `json:"count,omitempty"`
+}
+
+// ProfileSpec defines the secrets backends available to Secrets referencing the Profile
+type ProfileSpec struct {
+	AwsSecretsManager *AwsSecretsManager `json:"awsSecretsManager,omitempty"`
+	AwsParameterStore *AwsParameterStore `json:"awsParameterStore,omitempty"`
+	AzureKeyVault     *AzureKeyVault     `json:"azureKeyVault,omitempty"`
+	GcpSecretsManager *GcpSecretsManager `json:"gcpSecretsManager,omitempty"`
+	Vault             *Vault             `json:"vault,omitempty"`
+	File              *File              `json:"file,omitempty"`
+	Kubernetes        *Kubernetes        `json:"kubernetes,omitempty"`
+	Sops              *Sops              `json:"sops,omitempty"`
+	Http              *Http              `json:"http,omitempty"`
+	Failover          *Failover          `json:"failover,omitempty"`
+}
+
+// Failover lists Profiles that are tried in order when a backend of the Profile fails or does not respond within
+// Timeout. Fallback Profiles configure the same backends e.g. a replica AWS region or a Vault performance standby.
+type Failover struct {
+	Timeout   metav1.Duration      `json:"timeout,omitempty"`
+	Fallbacks []FallbackProfileRef `json:"fallbacks"`
+}
+
+// FallbackProfileRef references a fallback Profile, Timeout defaults to the timeout of the Failover
+type FallbackProfileRef struct {
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace,omitempty"`
+	Timeout   metav1.Duration `json:"timeout,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Secret is a specification for a Secret resource.
+type Secret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SecretSpec   `json:"spec"`
+	Status SecretStatus `json:"status,omitempty"`
+}
+
+type ProfileRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AliasedProfileRef references a Profile whose template functions are suffixed with _<alias> e.g. aws_prod
+type AliasedProfileRef struct {
+	Alias     string `json:"alias"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SecretSpec defines the desired state of a Secret
+type SecretSpec struct {
+	Profile ProfileRef `json:"profile"`
+	// Profiles are additional Profiles referenced by alias
+	Profiles      []AliasedProfileRef `json:"profiles,omitempty"`
+	SyncInterval  metav1.Duration     `json:"syncInterval,omitempty"`
+	Data          map[string]string   `json:"data,omitempty"`
+	DataFrom      []DataFromSource    `json:"dataFrom,omitempty"`
+	ManagedSecret ManagedSecretSpec   `json:"managedSecret"`
+	// TemplateFunctions selects the template function library, sprig or v1, defaults to sprig
+	TemplateFunctions string `json:"templateFunctions,omitempty"`
+	// Sops is a SOPS encrypted YAML or JSON document read with the sops template function
+	Sops string `json:"sops,omitempty"`
+	// Generators generate values that are stored in the managed secret and kept until they are rotated
+	Generators []Generator `json:"generators,omitempty"`
+	// StaleOnError keeps the data of the managed secret when a secrets backend can not be read
+	StaleOnError *StaleOnError `json:"staleOnError,omitempty"`
+}
+
+// StaleOnError keeps serving the last synced data of the managed secret while secrets backends are unavailable, the
+// Secret fails once MaxStaleness has passed since the last successful sync.
+type StaleOnError struct {
+	MaxStaleness metav1.Duration `json:"maxStaleness"`
+}
+
+// Generator generates the keys of a value stored in the managed secret. Exactly one kind of value should be set. The
+// value is generated again when rotation changes.
+type Generator struct {
+	Name        string                `json:"name"`
+	Rotation    string                `json:"rotation,omitempty"`
+	Password    *PasswordGenerator    `json:"password,omitempty"`
+	UUID        *UUIDGenerator        `json:"uuid,omitempty"`
+	SSHKey      *SSHKeyGenerator      `json:"sshKey,omitempty"`
+	Certificate *CertificateGenerator `json:"certificate,omitempty"`
+}
+
+// PasswordGenerator generates a random password of length characters containing exactly digits digits and symbols
+// symbols, the remaining characters are letters.
+type PasswordGenerator struct {
+	Length           int    `json:"length,omitempty"`
+	Digits           int    `json:"digits,omitempty"`
+	Symbols          int    `json:"symbols,omitempty"`
+	SymbolCharacters string `json:"symbolCharacters,omitempty"`
+	NoUpper          bool   `json:"noUpper,omitempty"`
+}
+
+// UUIDGenerator generates a random version 4 UUID
+type UUIDGenerator struct{}
+
+// SSHKeyGenerator generates an OpenSSH private key stored in the key of the generator and its authorized key stored
+// in <name>.pub. Type is rsa, ecdsa or ed25519.
+type SSHKeyGenerator struct {
+	Type    string `json:"type,omitempty"`
+	Bits    int    `json:"bits,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// CertificateGenerator generates a self-signed certificate stored in <name>.crt and its ECDSA P-256 private key stored
+// in <name>.key.
+type CertificateGenerator struct {
+	CommonName string          `json:"commonName"`
+	DNSNames   []string        `json:"dnsNames,omitempty"`
+	Duration   metav1.Duration `json:"duration,omitempty"`
+}
+
+// ManagedSecretSpec defines the kubernetes secret data to use for the secret managed by a Secret
+type ManagedSecretSpec struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// DataFromSource copies every key of a json secret stored in a single backend into the managed secret. Exactly one
+// backend should be set.
+type DataFromSource struct {
+	// Profile is the alias of the Profile providing the backend, defaults to the profile of the Secret
+	Profile           string                      `json:"profile,omitempty"`
+	AwsSecretsManager *NamedSecretSource          `json:"awsSecretsManager,omitempty"`
+	AwsParameterStore *ParameterStoreSecretSource `json:"awsParameterStore,omitempty"`
+	AzureKeyVault     *NamedSecretSource          `json:"azureKeyVault,omitempty"`
+	GcpSecretsManager *NamedSecretSource          `json:"gcpSecretsManager,omitempty"`
+	Vault             *VaultSecretSource          `json:"vault,omitempty"`
+	File              *NamedSecretSource          `json:"file,omitempty"`
+	Kubernetes        *KubernetesSecretSource     `json:"kubernetes,omitempty"`
+	Http              *NamedSecretSource          `json:"http,omitempty"`
+}
+
+// NamedSecretSource references a backend secret by name, optionally suffixed with ?version=
+type NamedSecretSource struct {
+	Name string `json:"name"`
+}
+
+// ParameterStoreSecretSource references either a single json parameter by name, optionally suffixed with
+// ?version=, or every parameter below path
+type ParameterStoreSecretSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// KubernetesSecretSource references a kubernetes Secret
+type KubernetesSecretSource struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// VaultSecretSource references a Vault secret by path, optionally suffixed with ?version=
+type VaultSecretSource struct {
+	Path string `json:"path"`
+}
+
+type SecretStatus struct {
+	State                         SecretState        `json:"state,omitempty"`
+	ObservedAnnotationChecksum    string             `json:"observedAnnotationChecksum,omitempty"`
+	ObservedGeneration            int64              `json:"observedGeneration,omitempty"`
+	ObservedSecretResourceVersion string             `json:"observedSecretResourceVersion,omitempty"`
+	SyncTimestamp                 *metav1.Time       `json:"syncTimestamp,omitempty"`
+	RefreshTimestamp              *metav1.Time       `json:"refreshTimestamp,omitempty"`
+	Sources                       []string           `json:"sources,omitempty"`
+	ObservedSourcesChecksum       string             `json:"observedSourcesChecksum,omitempty"`
+	Profiles                      []ProfileStatus    `json:"profiles,omitempty"`
+	ObservedProfilesChecksum      string             `json:"observedProfilesChecksum,omitempty"`
+	Backends                      []BackendStatus    `json:"backends,omitempty"`
+	Conditions                    []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// BackendStatus reports the Profile that served the values of a backend of a Profile with failover, ServedBy differs
+// from Profile when a fallback served at least one value
+type BackendStatus struct {
+	Profile  string `json:"profile"`
+	Backend  string `json:"backend"`
+	ServedBy string `json:"servedBy"`
+}
+
+// ProfileStatus reports the resolution of a Profile referenced by a Secret, the alias of the profile of the Secret is
+// empty
+type ProfileStatus struct {
+	Alias     string `json:"alias,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Resolved  bool   `json:"resolved"`
+	Message   string `json:"message,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PushSecret writes keys of a kubernetes Secret to the secrets backends of a Profile
+type PushSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PushSecretSpec   `json:"spec"`
+	Status PushSecretStatus `json:"status,omitempty"`
+}
+
+// PushSecretSpec defines the kubernetes Secret to push and where each key is written
+type PushSecretSpec struct {
+	Profile ProfileRef `json:"profile"`
+	// SecretName is the kubernetes Secret in the namespace of the PushSecret
+	SecretName string           `json:"secretName"`
+	Data       []PushSecretData `json:"data"`
+	// DeletionPolicy is Retain or Delete, Delete removes the backend secrets written when the PushSecret is deleted
+	// or an entry is removed, defaults to Retain
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// ConflictPolicy is Fail or Override, Fail does not write backend secrets that were modified since they were
+	// last pushed or that were not created by the PushSecret, defaults to Fail
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+}
+
+// PushSecretData writes a key of the kubernetes Secret to a backend secret. All keys are written as a json object
+// when SecretKey is empty.
+type PushSecretData struct {
+	SecretKey string         `json:"secretKey,omitempty"`
+	RemoteRef DataFromSource `json:"remoteRef"`
+}
+
+type PushSecretStatus struct {
+	State                         SecretState        `json:"state,omitempty"`
+	ObservedGeneration            int64              `json:"observedGeneration,omitempty"`
+	ObservedSecretResourceVersion string             `json:"observedSecretResourceVersion,omitempty"`
+	SyncTimestamp                 *metav1.Time       `json:"syncTimestamp,omitempty"`
+	Pushed                        []PushedSecret     `json:"pushed,omitempty"`
+	Conditions                    []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PushedSecret records the version of a backend secret written by a PushSecret
+type PushedSecret struct {
+	Backend  string `json:"backend"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Checksum string `json:"checksum"`
+}
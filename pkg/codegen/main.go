@@ -18,6 +18,7 @@ package main
 
 import (
 	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dockhandv1beta1 "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
 	controllergen "github.com/rancher/wrangler/v3/pkg/controller-gen"
 	"github.com/rancher/wrangler/v3/pkg/controller-gen/args"
 
@@ -34,6 +35,9 @@ func main() {
 				Types: []interface{}{
 					dockhand.Secret{},
 					dockhand.Profile{},
+					dockhandv1beta1.Secret{},
+					dockhandv1beta1.Profile{},
+					dockhandv1beta1.PushSecret{},
 				},
 				GenerateTypes: true,
 			},
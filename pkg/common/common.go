@@ -18,13 +18,11 @@ package common
 
 import (
 	"os"
-
-	log "github.com/sirupsen/logrus"
 )
 
 var (
-	// Log for global use
-	Log = log.New()
+	// Log for global use, secret values are redacted
+	Log = NewLogger()
 )
 
 // ExitIfError will generically handle an error by logging its contents
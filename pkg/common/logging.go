@@ -0,0 +1,276 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Fields are the structured fields of log entries
+type Fields = log.Fields
+
+// Standard fields of log entries
+const (
+	FieldComponent     = "component"
+	FieldCorrelationID = "correlationId"
+	FieldKind          = "kind"
+	FieldNamespace     = "namespace"
+	FieldName          = "name"
+	FieldProfile       = "profile"
+)
+
+// Log formats accepted by ConfigureLogging
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Logger is a logrus Logger that redacts secret values and adds its fields to every entry. Raw []byte arguments are
+// replaced with Redacted and known secret values are redacted from every entry.
+type Logger struct {
+	*log.Logger
+	fields Fields
+}
+
+// loggers holds the logrus Logger of each component so that they can be configured together
+var loggers = struct {
+	components map[string]*log.Logger
+	levels     map[string]log.Level
+	level      log.Level
+	formatter  log.Formatter
+	out        io.Writer
+	mutex      sync.Mutex
+}{
+	components: make(map[string]*log.Logger),
+	levels:     make(map[string]log.Level),
+	level:      log.InfoLevel,
+	formatter:  &log.TextFormatter{FullTimestamp: true},
+	out:        os.Stderr,
+}
+
+// NewLogger returns a Logger that redacts secret values
+func NewLogger() *Logger {
+	logger := log.New()
+	logger.AddHook(redactHook{})
+	return &Logger{Logger: logger}
+}
+
+// ComponentLogger returns the Logger of component, whose level can be set independently with ConfigureLogging.
+// Components are named e.g. controller, webhook, certmanager or provider.vault.
+func ComponentLogger(component string) *Logger {
+	loggers.mutex.Lock()
+	defer loggers.mutex.Unlock()
+
+	logger, ok := loggers.components[component]
+	if !ok {
+		logger = log.New()
+		logger.AddHook(redactHook{})
+		logger.SetOutput(loggers.out)
+		logger.SetFormatter(loggers.formatter)
+		logger.SetLevel(componentLevel(component))
+		loggers.components[component] = logger
+	}
+	return &Logger{Logger: logger, fields: Fields{FieldComponent: component}}
+}
+
+// ConfigureLogging sets the output, format and levels of Log and every component Logger. levels is a comma separated
+// list of a default level and component=level pairs e.g. info,controller=debug,provider=warn,provider.vault=trace.
+// The level of provider applies to every provider.<name> component without its own level.
+func ConfigureLogging(out io.Writer, format string, levels string) error {
+	var formatter log.Formatter
+	switch format {
+	case "", LogFormatText:
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	case LogFormatJSON:
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("unsupported log format %s, expected %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	level := log.InfoLevel
+	componentLevels := make(map[string]log.Level)
+	for _, entry := range strings.Split(levels, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, levelName, isComponent := strings.Cut(entry, "=")
+		if !isComponent {
+			levelName = component
+		}
+		parsed, err := log.ParseLevel(strings.TrimSpace(levelName))
+		if err != nil {
+			return fmt.Errorf("invalid log level %s: %v", entry, err)
+		}
+		if isComponent {
+			componentLevels[strings.TrimSpace(component)] = parsed
+		} else {
+			level = parsed
+		}
+	}
+
+	loggers.mutex.Lock()
+	defer loggers.mutex.Unlock()
+	loggers.out = out
+	loggers.formatter = formatter
+	loggers.level = level
+	loggers.levels = componentLevels
+
+	Log.SetOutput(out)
+	Log.SetFormatter(formatter)
+	Log.SetLevel(level)
+	for component, logger := range loggers.components {
+		logger.SetOutput(out)
+		logger.SetFormatter(formatter)
+		logger.SetLevel(componentLevel(component))
+	}
+	return nil
+}
+
+// componentLevel returns the configured level of component, loggers.mutex must be held
+func componentLevel(component string) log.Level {
+	if level, ok := loggers.levels[component]; ok {
+		return level
+	}
+	if parent, _, ok := strings.Cut(component, "."); ok {
+		if level, ok := loggers.levels[parent]; ok {
+			return level
+		}
+	}
+	return loggers.level
+}
+
+// NewCorrelationID returns an identifier that correlates the log entries of a reconcile or an admission request
+func NewCorrelationID() string {
+	return uuid.NewString()
+}
+
+// With returns a Logger that adds fields to every entry
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{Logger: l.Logger, fields: merged}
+}
+
+// WithObject returns a Logger with a new correlation ID and the standard fields of the object kind namespace/name
+func (l *Logger) WithObject(kind string, namespace string, name string) *Logger {
+	return l.With(Fields{
+		FieldCorrelationID: NewCorrelationID(),
+		FieldKind:          kind,
+		FieldNamespace:     namespace,
+		FieldName:          name,
+	})
+}
+
+// Fields returns the fields of the Logger other than its component, which can be added to the Logger of another
+// component
+func (l *Logger) Fields() Fields {
+	fields := make(Fields, len(l.fields))
+	for k, v := range l.fields {
+		if k != FieldComponent {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// LogIfError logs err as a warning when it is not nil
+func (l *Logger) LogIfError(err error) {
+	if err != nil {
+		l.Warnf("%v", err)
+	}
+}
+
+func (l *Logger) entry() *log.Entry {
+	return l.Logger.WithFields(l.fields)
+}
+
+func (l *Logger) Tracef(format string, args ...interface{}) {
+	l.entry().Tracef(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.entry().Debugf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.entry().Infof(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.entry().Printf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.entry().Warnf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.entry().Warningf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.entry().Errorf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.entry().Fatalf(format, RedactArgs(args)...)
+}
+
+func (l *Logger) Debug(args ...interface{}) {
+	l.entry().Debug(RedactArgs(args)...)
+}
+
+func (l *Logger) Info(args ...interface{}) {
+	l.entry().Info(RedactArgs(args)...)
+}
+
+func (l *Logger) Warn(args ...interface{}) {
+	l.entry().Warn(RedactArgs(args)...)
+}
+
+func (l *Logger) Error(args ...interface{}) {
+	l.entry().Error(RedactArgs(args)...)
+}
+
+func (l *Logger) Debugln(args ...interface{}) {
+	l.entry().Debugln(RedactArgs(args)...)
+}
+
+func (l *Logger) Infoln(args ...interface{}) {
+	l.entry().Infoln(RedactArgs(args)...)
+}
+
+func (l *Logger) Warnln(args ...interface{}) {
+	l.entry().Warnln(RedactArgs(args)...)
+}
+
+func (l *Logger) Errorln(args ...interface{}) {
+	l.entry().Errorln(RedactArgs(args)...)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		levels     string
+		wantLevel  log.Level
+		wantLevels map[string]log.Level
+		wantJSON   bool
+		wantErr    string
+	}{
+		{
+			name:       "defaults",
+			wantLevel:  log.InfoLevel,
+			wantLevels: map[string]log.Level{"controller": log.InfoLevel, "provider.vault": log.InfoLevel},
+		},
+		{
+			name:       "default level",
+			format:     LogFormatJSON,
+			levels:     "debug",
+			wantLevel:  log.DebugLevel,
+			wantLevels: map[string]log.Level{"controller": log.DebugLevel, "provider.vault": log.DebugLevel},
+			wantJSON:   true,
+		},
+		{
+			name:      "component levels",
+			format:    LogFormatText,
+			levels:    " warn, controller = debug ,provider=error,provider.vault=trace,",
+			wantLevel: log.WarnLevel,
+			wantLevels: map[string]log.Level{
+				"controller":     log.DebugLevel,
+				"webhook":        log.WarnLevel,
+				"provider":       log.ErrorLevel,
+				"provider.vault": log.TraceLevel,
+				"provider.aws":   log.ErrorLevel,
+			},
+		},
+		{
+			name:       "component level without a default",
+			levels:     "provider.vault=debug",
+			wantLevel:  log.InfoLevel,
+			wantLevels: map[string]log.Level{"provider.vault": log.DebugLevel, "provider.aws": log.InfoLevel},
+		},
+		{
+			name:    "invalid level",
+			levels:  "info,controller=loud",
+			wantErr: `invalid log level controller=loud: not a valid logrus Level: "loud"`,
+		},
+		{
+			name:    "unsupported format",
+			format:  "xml",
+			wantErr: "unsupported log format xml, expected text or json",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				if err := ConfigureLogging(os.Stderr, "", ""); err != nil {
+					t.Fatal(err)
+				}
+			})
+			err := ConfigureLogging(io.Discard, test.format, test.levels)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := Log.GetLevel(); got != test.wantLevel {
+				t.Errorf("Log level = %s, want %s", got, test.wantLevel)
+			}
+			for component, want := range test.wantLevels {
+				if got := ComponentLogger(component).GetLevel(); got != want {
+					t.Errorf("%s level = %s, want %s", component, got, want)
+				}
+			}
+			if _, json := Log.Formatter.(*log.JSONFormatter); json != test.wantJSON {
+				t.Errorf("Log formatter = %T, want json %t", Log.Formatter, test.wantJSON)
+			}
+		})
+	}
+}
+
+func TestConfigureLoggingUpdatesComponentLoggers(t *testing.T) {
+	t.Cleanup(func() {
+		if err := ConfigureLogging(os.Stderr, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// loggers created before the configuration are reconfigured
+	logger := ComponentLogger("provider.test")
+	if err := ConfigureLogging(io.Discard, LogFormatJSON, "info,provider=debug"); err != nil {
+		t.Fatal(err)
+	}
+	if got := logger.GetLevel(); got != log.DebugLevel {
+		t.Errorf("level = %s, want %s", got, log.DebugLevel)
+	}
+	if _, ok := logger.Formatter.(*log.JSONFormatter); !ok {
+		t.Errorf("formatter = %T, want json", logger.Formatter)
+	}
+	if logger.Out != io.Discard {
+		t.Error("output was not configured")
+	}
+}
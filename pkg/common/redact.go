@@ -0,0 +1,188 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secret values in logs, events and errors
+const Redacted = "[REDACTED]"
+
+// minRedactedLength is the length below which values are not redacted, shorter values would mostly match unrelated
+// text
+const minRedactedLength = 4
+
+// secretValues holds the known secret values keyed by the resource that owns them
+var secretValues = &redactor{values: make(map[string][]string)}
+
+type redactor struct {
+	values   map[string][]string
+	replacer *strings.Replacer
+	mutex    sync.RWMutex
+}
+
+// SetSecretValues replaces the known secret values of owner, e.g. the data of the managed secret of a Dockhand Secret
+func SetSecretValues(owner string, data map[string][]byte) {
+	values := make([]string, 0, len(data))
+	for _, value := range data {
+		if len(value) >= minRedactedLength {
+			values = append(values, string(value))
+		}
+	}
+	secretValues.mutex.Lock()
+	defer secretValues.mutex.Unlock()
+	secretValues.values[owner] = values
+	secretValues.replacer = nil
+}
+
+// ForgetSecretValues removes the known secret values of owner
+func ForgetSecretValues(owner string) {
+	secretValues.mutex.Lock()
+	defer secretValues.mutex.Unlock()
+	delete(secretValues.values, owner)
+	secretValues.replacer = nil
+}
+
+// Redact replaces the known secret values in text
+func Redact(text string) string {
+	return secretValues.getReplacer().Replace(text)
+}
+
+// RedactError returns err with the known secret values and the values of data redacted from its message, the
+// original error is available with errors.Unwrap
+func RedactError(err error, data ...map[string][]byte) error {
+	if err == nil {
+		return nil
+	}
+	message := Redact(err.Error())
+	var values []string
+	for _, d := range data {
+		for _, value := range d {
+			if len(value) >= minRedactedLength {
+				values = append(values, string(value))
+			}
+		}
+	}
+	if len(values) > 0 {
+		message = newReplacer(values).Replace(message)
+	}
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+// RedactArgs replaces raw secret data in logging arguments, []byte values are never logged
+func RedactArgs(args []interface{}) []interface{} {
+	redacted := args
+	copied := false
+	for i, arg := range args {
+		var value interface{}
+		switch v := arg.(type) {
+		case []byte:
+			value = Redacted
+		case map[string][]byte:
+			keys := make(map[string]string, len(v))
+			for k := range v {
+				keys[k] = Redacted
+			}
+			value = keys
+		default:
+			continue
+		}
+		if !copied {
+			redacted = append([]interface{}(nil), args...)
+			copied = true
+		}
+		redacted[i] = value
+	}
+	return redacted
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+func (r *redactor) getReplacer() *strings.Replacer {
+	r.mutex.RLock()
+	replacer := r.replacer
+	r.mutex.RUnlock()
+	if replacer != nil {
+		return replacer
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.replacer == nil {
+		var values []string
+		for _, ownerValues := range r.values {
+			values = append(values, ownerValues...)
+		}
+		r.replacer = newReplacer(values)
+	}
+	return r.replacer
+}
+
+// newReplacer returns a replacer of values with Redacted, longer values are replaced first so that a value containing
+// another one is redacted entirely
+func newReplacer(values []string) *strings.Replacer {
+	sorted := append([]string(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, Redacted)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// redactHook redacts the known secret values from the message and fields of every log entry
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for k, v := range entry.Data {
+		switch value := v.(type) {
+		case string:
+			entry.Data[k] = Redact(value)
+		case []byte:
+			entry.Data[k] = Redacted
+		case error:
+			entry.Data[k] = Redact(value.Error())
+		}
+	}
+	return nil
+}
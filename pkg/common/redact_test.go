@@ -0,0 +1,161 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setSecretValues sets the known secret values of owner for the duration of the test
+func setSecretValues(t *testing.T, owner string, data map[string][]byte) {
+	t.Helper()
+	SetSecretValues(owner, data)
+	t.Cleanup(func() { ForgetSecretValues(owner) })
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string][]byte
+		text   string
+		want   string
+	}{
+		{
+			name:   "value",
+			values: map[string][]byte{"password": []byte("s3cr3t")},
+			text:   "login with s3cr3t failed",
+			want:   "login with [REDACTED] failed",
+		},
+		{
+			name:   "value containing another value",
+			values: map[string][]byte{"short": []byte("pass"), "long": []byte("password123")},
+			text:   "password123 and pass",
+			want:   "[REDACTED] and [REDACTED]",
+		},
+		{
+			name:   "value at the minimum length",
+			values: map[string][]byte{"pin": []byte("1234")},
+			text:   "pin 1234",
+			want:   "pin [REDACTED]",
+		},
+		{
+			name:   "value below the minimum length",
+			values: map[string][]byte{"flag": []byte("yes")},
+			text:   "enabled: yes",
+			want:   "enabled: yes",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setSecretValues(t, "default/"+test.name, test.values)
+			if got := Redact(test.text); got != test.want {
+				t.Errorf("Redact(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewReplacerReplacesLongestFirst(t *testing.T) {
+	// the shorter value is listed first, as map iteration may order the values of an owner
+	replacer := newReplacer([]string{"pass", "password123", "password"})
+	if got, want := replacer.Replace("password123 password pass"), "[REDACTED] [REDACTED] [REDACTED]"; got != want {
+		t.Errorf("Replace = %q, want %q", got, want)
+	}
+}
+
+func TestForgetSecretValues(t *testing.T) {
+	setSecretValues(t, "default/kept", map[string][]byte{"token": []byte("t0k3n")})
+	SetSecretValues("default/forgotten", map[string][]byte{"password": []byte("s3cr3t")})
+	if got, want := Redact("s3cr3t t0k3n"), "[REDACTED] [REDACTED]"; got != want {
+		t.Fatalf("Redact = %q, want %q", got, want)
+	}
+
+	ForgetSecretValues("default/forgotten")
+	if got, want := Redact("s3cr3t t0k3n"), "s3cr3t [REDACTED]"; got != want {
+		t.Errorf("Redact after ForgetSecretValues = %q, want %q", got, want)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	setSecretValues(t, "default/app", map[string][]byte{"password": []byte("s3cr3t")})
+
+	err := errors.New("reading s3cr3t and t0k3n")
+	redacted := RedactError(err, map[string][]byte{"token": []byte("t0k3n"), "short": []byte("ab")})
+	if got, want := redacted.Error(), "reading [REDACTED] and [REDACTED]"; got != want {
+		t.Errorf("error = %q, want %q", got, want)
+	}
+	if !errors.Is(redacted, err) {
+		t.Error("redacted error does not unwrap to the original error")
+	}
+
+	unchanged := errors.New("no secret values")
+	if got := RedactError(unchanged); got != unchanged {
+		t.Errorf("RedactError = %v, want the original error", got)
+	}
+	if RedactError(nil) != nil {
+		t.Error("RedactError(nil) is not nil")
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	data := map[string][]byte{"password": []byte("s3cr3t")}
+	args := []interface{}{"secret", []byte("s3cr3t"), data, 3}
+	want := []interface{}{"secret", Redacted, map[string]string{"password": Redacted}, 3}
+	if got := RedactArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactArgs = %#v, want %#v", got, want)
+	}
+	if !reflect.DeepEqual(args[1], []byte("s3cr3t")) || !reflect.DeepEqual(args[2], data) {
+		t.Error("RedactArgs modified its arguments")
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	setSecretValues(t, "default/app", map[string][]byte{"password": []byte("s3cr3t")})
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.AddHook(redactHook{})
+
+	logger.WithFields(log.Fields{
+		"value": "password s3cr3t",
+		"data":  []byte("raw"),
+		"error": errors.New("invalid s3cr3t"),
+		"count": 1,
+	}).Info("rendered s3cr3t")
+
+	if strings.Contains(out.String(), "s3cr3t") || strings.Contains(out.String(), "raw") {
+		t.Errorf("log entry %s contains secret values", out.String())
+	}
+	for _, want := range []string{
+		`"msg":"rendered [REDACTED]"`,
+		`"value":"password [REDACTED]"`,
+		`"data":"[REDACTED]"`,
+		`"error":"invalid [REDACTED]"`,
+		`"count":1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("log entry %s does not contain %s", out.String(), want)
+		}
+	}
+}
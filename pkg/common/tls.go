@@ -25,6 +25,25 @@ func ValidDaysRemaining(pem []byte) int {
 	return int(cert.NotAfter.Sub(time.Now()).Hours() / 24)
 }
 
+// CheckCertificateValid returns an error when the leaf certificate of cert is not valid at the current time
+func CheckCertificateValid(cert *tls.Certificate) error {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return fmt.Errorf("no certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("could not parse certificate, %v", err)
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", leaf.NotBefore)
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter)
+	}
+	return nil
+}
+
 func GenerateSelfSignedCA(commonName string) ([]byte, []byte, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 256)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -18,28 +18,39 @@ package v2
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
-	"github.com/boxboat/dockcmd/cmd/aws"
-	"github.com/boxboat/dockcmd/cmd/azure"
-	dockcmdCommon "github.com/boxboat/dockcmd/cmd/common"
-	"github.com/boxboat/dockcmd/cmd/gcp"
-	"github.com/boxboat/dockcmd/cmd/vault"
-	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
-	dockhandcontrollers "github.com/boxboat/dockhand-secrets-operator/pkg/generated/controllers/dhs.dockhand.dev/v1alpha2"
+	dockhandcontrollers "github.com/boxboat/dockhand-secrets-operator/pkg/generated/controllers/dhs.dockhand.dev/v1beta1"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/metrics"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/aws"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/azure"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/file"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/gcp"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/httpjson"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/kubernetes"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/sops"
+	_ "github.com/boxboat/dockhand-secrets-operator/pkg/providers/vault"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/templates"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
 	appscontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/apps/v1"
 	corecontrollers "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/v3/pkg/kv"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -56,22 +67,58 @@ type Handler struct {
 	deployments                appscontrollers.DeploymentClient
 	dhSecretsController        dockhandcontrollers.SecretController
 	dhSecretsProfileController dockhandcontrollers.ProfileController
+	pushSecrets                dockhandcontrollers.PushSecretController
 	statefulSets               appscontrollers.StatefulSetClient
 	secrets                    corecontrollers.SecretController
+	configMaps                 corecontrollers.ConfigMapClient
 	recorder                   record.EventRecorder
 	crossNamespaceAuthorized   bool
-	awsProfileMap              map[string]*aws.SecretsClient
-	azureProfileMap            map[string]*azure.SecretsClient
-	gcpProfileMap              map[string]*gcp.SecretsClient
-	vaultProfileMap            map[string]*vault.SecretsClient
+	providers                  *providers.Registry
+	profileClients             map[string]map[string]providers.Client
+	// profileGenerations holds the generation of the Profile each entry of profileClients was built from
+	profileGenerations map[string]int64
+	// observedProfiles holds the last generation of each Profile handled by onDockhandProfileChange
+	observedProfiles    map[string]int64
+	profileClientsMutex sync.Mutex
+	log                 *common.Logger
 }
 
 const (
 	recreateSeconds        = 30
 	syncChangedSeconds     = 5
 	minSyncIntervalSeconds = 5
+
+	// sourcesIndex indexes Dockhand Secrets by the kubernetes Secrets listed in status.sources
+	sourcesIndex = "dhs.dockhand.dev/sources"
+	// profilesIndex indexes Dockhand Secrets and PushSecrets by the namespace/name of the Profiles they reference
+	profilesIndex = "dhs.dockhand.dev/profiles"
+	// credentialsIndex indexes Profiles by the kubernetes Secrets referenced by their provider configuration
+	credentialsIndex = "dhs.dockhand.dev/credentials"
+	// fallbacksIndex indexes Profiles by the namespace/name of their fallback Profiles
+	fallbacksIndex = "dhs.dockhand.dev/fallbacks"
 )
 
+// kinds of the reconciled objects logged in the kind field
+const (
+	kindDockhandSecret = "Secret.dhs.dockhand.dev"
+	kindProfile        = "Profile.dhs.dockhand.dev"
+	kindPushSecret     = "PushSecret.dhs.dockhand.dev"
+	kindSecret         = "Secret"
+	kindDaemonSet      = "DaemonSet"
+	kindDeployment     = "Deployment"
+	kindStatefulSet    = "StatefulSet"
+)
+
+// Option configures the Handler registered by Register
+type Option func(h *Handler)
+
+// WithProviders builds the clients of Profiles with the providers of registry instead of providers.DefaultRegistry
+func WithProviders(registry *providers.Registry) Option {
+	return func(h *Handler) {
+		h.providers = registry
+	}
+}
+
 func Register(
 	ctx context.Context,
 	namespace string,
@@ -80,10 +127,14 @@ func Register(
 	deployments appscontrollers.DeploymentController,
 	statefulsets appscontrollers.StatefulSetController,
 	secrets corecontrollers.SecretController,
+	configMaps corecontrollers.ConfigMapClient,
 	dockhandSecrets dockhandcontrollers.SecretController,
 	dockhandProfile dockhandcontrollers.ProfileController,
-	crossNamespaceAuthorized bool) {
+	pushSecrets dockhandcontrollers.PushSecretController,
+	crossNamespaceAuthorized bool,
+	opts ...Option) {
 
+	log := common.ComponentLogger("controller")
 	h := &Handler{
 		ctx:                        ctx,
 		operatorNamespace:          namespace,
@@ -91,88 +142,182 @@ func Register(
 		deployments:                deployments,
 		dhSecretsController:        dockhandSecrets,
 		dhSecretsProfileController: dockhandProfile,
+		pushSecrets:                pushSecrets,
 		secrets:                    secrets,
+		configMaps:                 configMaps,
 		statefulSets:               statefulsets,
-		recorder:                   buildEventRecorder(events),
+		recorder:                   buildEventRecorder(log, events),
 		crossNamespaceAuthorized:   crossNamespaceAuthorized,
-		awsProfileMap:              make(map[string]*aws.SecretsClient),
-		azureProfileMap:            make(map[string]*azure.SecretsClient),
-		gcpProfileMap:              make(map[string]*gcp.SecretsClient),
-		vaultProfileMap:            make(map[string]*vault.SecretsClient),
-	}
+		providers:                  providers.DefaultRegistry,
+		profileClients:             make(map[string]map[string]providers.Client),
+		profileGenerations:         make(map[string]int64),
+		observedProfiles:           make(map[string]int64),
+		log:                        log,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	dockhandSecrets.Cache().AddIndexer(sourcesIndex, func(secret *dockhand.Secret) ([]string, error) {
+		return secret.Status.Sources, nil
+	})
+	dockhandSecrets.Cache().AddIndexer(profilesIndex, func(secret *dockhand.Secret) ([]string, error) {
+		return secretProfileKeys(secret), nil
+	})
+	dockhandProfile.Cache().AddIndexer(credentialsIndex, func(profile *dockhand.Profile) ([]string, error) {
+		return providers.ProfileSecretRefs(profile), nil
+	})
+	dockhandProfile.Cache().AddIndexer(fallbacksIndex, func(profile *dockhand.Profile) ([]string, error) {
+		return fallbackProfileKeys(profile), nil
+	})
+	pushSecrets.Cache().AddIndexer(pushSecretsIndex, func(pushSecret *dockhand.PushSecret) ([]string, error) {
+		return []string{pushSecret.Namespace + "/" + pushSecret.Spec.SecretName}, nil
+	})
+	pushSecrets.Cache().AddIndexer(profilesIndex, func(pushSecret *dockhand.PushSecret) ([]string, error) {
+		return []string{pushSecretProfileKey(pushSecret)}, nil
+	})
 
 	// Register handlers
 	dockhandSecrets.OnChange(ctx, "dockhandsecret-onchange", h.onDockhandSecretChange)
 	dockhandSecrets.OnRemove(ctx, "dockhandsecret-onremove", h.onDockhandSecretRemove)
 	dockhandProfile.OnChange(ctx, "dockhandprofile-onchange", h.onDockhandProfileChange)
+	dockhandProfile.OnRemove(ctx, "dockhandprofile-onremove", h.onDockhandProfileRemove)
+	pushSecrets.OnChange(ctx, "pushsecret-onchange", h.onPushSecretChange)
+	pushSecrets.OnRemove(ctx, "pushsecret-onremove", h.onPushSecretRemove)
 	secrets.OnChange(ctx, "secrets-onchange", h.onManagedSecretChange)
 	daemonsets.OnChange(ctx, "daemonsets-onchange", h.onDaemonSetChange)
 	deployments.OnChange(ctx, "deployment-onchange", h.onDeploymentChange)
 	statefulsets.OnChange(ctx, "statefulsets-onchange", h.onStatefulSetChange)
 }
 
-func buildEventRecorder(events typedcorev1.EventInterface) record.EventRecorder {
+func buildEventRecorder(log *common.Logger, events typedcorev1.EventInterface) record.EventRecorder {
 	// Create event broadcaster
 	// Add dockhand controller types to the default Kubernetes Scheme so Events can be
 	// logged for dockhand controller types.
 	utilruntime.Must(dockhand.AddToScheme(scheme.Scheme))
-	common.Log.Info("Creating event broadcaster")
+	log.Info("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(common.Log.Infof)
+	eventBroadcaster.StartLogging(log.Infof)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: events})
 	return eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "dockhand-secrets-operator"})
 }
 
-// onDockhandProfileChange clean the cache for all associated secrets backends
+// reconcileLog returns the Logger of a reconcile of the object kind with the namespace/name key, every entry of the
+// reconcile has the same correlation ID
+func (h *Handler) reconcileLog(kind string, key string) *common.Logger {
+	namespace, name := kv.Split(key, "/")
+	return h.log.WithObject(kind, namespace, name)
+}
+
+// onDockhandProfileChange clean the cache for all associated secrets backends and re-sync the Dockhand Secrets and
+// PushSecrets using the Profile when its spec changed, then checks the health of its backends
 func (h *Handler) onDockhandProfileChange(key string, profile *dockhand.Profile) (*dockhand.Profile, error) {
-	common.Log.Infof("dockhand profile changed %s", key)
-	delete(h.awsProfileMap, key)
-	delete(h.azureProfileMap, key)
-	delete(h.gcpProfileMap, key)
-	delete(h.vaultProfileMap, key)
-	return nil, nil
+	log := h.reconcileLog(kindProfile, key)
+	if profile == nil || profile.DeletionTimestamp != nil {
+		h.invalidateProfile(log, key)
+		h.profileClientsMutex.Lock()
+		delete(h.observedProfiles, key)
+		h.profileClientsMutex.Unlock()
+		return nil, nil
+	}
+	// status updates and periodic health checks do not change the generation and keep the clients
+	if h.observeProfile(key, profile.Generation) {
+		log.Infof("dockhand profile changed %s", key)
+		h.invalidateProfile(log, key)
+	}
+	return nil, h.checkProfileHealth(log, profile)
 }
 
-// onManagedSecretChange handler to re-sync Dockhand Secret to managed secret when it is externally deleted or modified.
+// onManagedSecretChange handler to re-sync Dockhand Secret to managed secret when it is externally deleted or modified,
+// to re-sync the Dockhand Secrets reading the secret with the kubernetes provider and to push the secret with
+// PushSecrets.
 func (h *Handler) onManagedSecretChange(key string, secret *corev1.Secret) (*corev1.Secret, error) {
+	log := h.reconcileLog(kindSecret, key)
+	h.enqueueSourceDependents(log, key)
+	h.enqueueCredentialDependents(log, key)
+	h.enqueuePushSecrets(log, key)
 	if secret == nil {
-		common.Log.Debugf("checking deleted secret %s", key)
+		log.Debugf("checking deleted secret %s", key)
 		namespace, name := kv.Split(key, "/")
 		dhsList, err := h.dhSecretsController.List(namespace, metav1.ListOptions{})
-		common.LogIfError(err)
+		log.LogIfError(err)
 		for _, dhs := range dhsList.Items {
-			if dhs.SecretSpec.Name == name && dhs.DeletionTimestamp == nil {
-				common.Log.Infof("managed secret %s deleted - enqueuing dockhand secret %s/%s after %d seconds", key, dhs.Namespace, dhs.Name, recreateSeconds)
+			if dhs.Spec.ManagedSecret.Name == name && dhs.DeletionTimestamp == nil {
+				log.Infof("managed secret %s deleted - enqueuing dockhand secret %s/%s after %d seconds", key, dhs.Namespace, dhs.Name, recreateSeconds)
 				h.dhSecretsController.EnqueueAfter(dhs.Namespace, dhs.Name, time.Second*recreateSeconds)
 			}
 		}
 		return nil, nil
 	}
 	if secret.Labels != nil {
-		common.Log.Debugf("%s secret change", key)
+		log.Debugf("%s secret change", key)
 		if val, ok := secret.Labels[dockhand.DockhandSecretLabelKey]; ok {
 			if dhSecret, err := h.dhSecretsController.Get(secret.Namespace, val, metav1.GetOptions{}); err == nil {
-				common.Log.Infof("managed secret %s changed - enqueuing dockhand secret %s/%s after %d seconds", key, dhSecret.Namespace, dhSecret.Name, syncChangedSeconds)
+				log.Infof("managed secret %s changed - enqueuing dockhand secret %s/%s after %d seconds", key, dhSecret.Namespace, dhSecret.Name, syncChangedSeconds)
 				h.dhSecretsController.EnqueueAfter(dhSecret.Namespace, dhSecret.Name, time.Second*syncChangedSeconds)
 			} else {
-				common.LogIfError(err)
+				log.LogIfError(err)
 			}
 		}
 	}
 	return nil, nil
 }
 
+// enqueueSourceDependents enqueues the Dockhand Secrets that read the kubernetes Secret key when they were last synced
+func (h *Handler) enqueueSourceDependents(log *common.Logger, key string) {
+	dependents, err := h.dhSecretsController.Cache().GetByIndex(sourcesIndex, key)
+	if err != nil {
+		log.LogIfError(err)
+		return
+	}
+	for _, dhs := range dependents {
+		log.Infof("source secret %s changed - enqueuing dockhand secret %s/%s after %d seconds", key, dhs.Namespace, dhs.Name, syncChangedSeconds)
+		h.dhSecretsController.EnqueueAfter(dhs.Namespace, dhs.Name, time.Second*syncChangedSeconds)
+	}
+}
+
+// getSourcesChecksum returns the checksum of the current resourceVersion of the kubernetes Secrets sources
+func (h *Handler) getSourcesChecksum(sources []string) string {
+	versions := make(map[string]string, len(sources))
+	for _, source := range sources {
+		namespace, name := kv.Split(source, "/")
+		versions[source] = ""
+		if secret, err := h.secrets.Cache().Get(namespace, name); err == nil {
+			versions[source] = secret.ResourceVersion
+		}
+	}
+	return sourcesChecksum(versions)
+}
+
+// sourcesChecksum returns the checksum of the resourceVersion of kubernetes Secrets keyed by namespace/name
+func sourcesChecksum(versions map[string]string) string {
+	keys := make([]string, 0, len(versions))
+	for k := range versions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hash := sha1.New()
+	for _, k := range keys {
+		hash.Write([]byte(k + "=" + versions[k] + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 // onDockhandSecretRemove delete managed Secret when Dockhand Secret is removed.
-func (h *Handler) onDockhandSecretRemove(_ string, secret *dockhand.Secret) (*dockhand.Secret, error) {
+func (h *Handler) onDockhandSecretRemove(key string, secret *dockhand.Secret) (*dockhand.Secret, error) {
 	if secret == nil {
 		return nil, nil
 	}
-	common.Log.Infof("dockhand secret removed %s/%s", secret.Namespace, secret.Name)
-	common.Log.Infof("removing managed secret %s/%s", secret.Namespace, secret.SecretSpec.Name)
-	if err := h.secrets.Delete(secret.Namespace, secret.SecretSpec.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
-		common.Log.Warnf(
+	log := h.reconcileLog(kindDockhandSecret, key)
+	log.Infof("dockhand secret removed %s/%s", secret.Namespace, secret.Name)
+	metrics.ClearStale(secret.Namespace, secret.Name)
+	common.ForgetSecretValues(secretValuesOwner(secret))
+	h.enqueueDeletedProfiles(secretProfileKeys(secret)...)
+	log.Infof("removing managed secret %s/%s", secret.Namespace, secret.Spec.ManagedSecret.Name)
+	if err := h.secrets.Delete(secret.Namespace, secret.Spec.ManagedSecret.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		log.Warnf(
 			"could not delete secret=%s from namespace=%s",
-			secret.SecretSpec.Name,
+			secret.Spec.ManagedSecret.Name,
 			secret.Namespace)
 		return nil, err
 	}
@@ -181,11 +326,18 @@ func (h *Handler) onDockhandSecretRemove(_ string, secret *dockhand.Secret) (*do
 }
 
 // onDockhandSecretChange handler responsible for creating/updating managed Secrets.
-func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*dockhand.Secret, error) {
+func (h *Handler) onDockhandSecretChange(key string, secret *dockhand.Secret) (_ *dockhand.Secret, err error) {
 	// secret has been deleted so just return
 	if secret == nil {
 		return nil, nil
 	}
+	ctx, span := tracing.Start(h.ctx, "onDockhandSecretChange", tracing.ObjectAttributes(kindDockhandSecret, secret.Namespace, secret.Name)...)
+	defer func() {
+		tracing.End(span, err)
+	}()
+	log := h.reconcileLog(kindDockhandSecret, key).With(common.Fields{
+		common.FieldProfile: strings.Join(secretProfileKeys(secret), ","),
+	})
 
 	// secret is being deleted just return
 	if secret.DeletionTimestamp != nil {
@@ -201,16 +353,36 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 
 		updateRequired := false
 
+		// short-lived registry tokens rendered into the secret must be refreshed before they expire
+		if refresh := secret.Status.RefreshTimestamp; refresh != nil {
+			if refresh.Time.Before(time.Now()) {
+				updateRequired = true
+			} else {
+				log.Debugf("enqueing %s/%s for registry token refresh at %s", secret.Namespace, secret.Name, refresh.String())
+				h.dhSecretsController.EnqueueAfter(secret.Namespace, secret.Name, time.Until(refresh.Time))
+			}
+		}
+
+		// Profiles or the kubernetes Secrets holding their credentials have changed
+		if h.getProfilesChecksum(secret) != secret.Status.ObservedProfilesChecksum {
+			updateRequired = true
+		}
+
+		// kubernetes Secrets read with the kubernetes provider have changed
+		if len(secret.Status.Sources) > 0 && h.getSourcesChecksum(secret.Status.Sources) != secret.Status.ObservedSourcesChecksum {
+			updateRequired = true
+		}
+
 		// check for syncInterval setting
-		if syncDuration, err := time.ParseDuration(secret.SyncInterval); err == nil && syncDuration.Seconds() > 0 {
+		if syncDuration := secret.Spec.SyncInterval.Duration; syncDuration.Seconds() > 0 {
 			if syncDuration.Seconds() < minSyncIntervalSeconds {
 				syncDuration = minSyncIntervalSeconds * time.Second
-				common.Log.Warnf("syncInterval for %s/%s < %ds, min %v will be used", secret.Namespace, secret.Name, minSyncIntervalSeconds, syncDuration)
+				log.Warnf("syncInterval for %s/%s < %ds, min %v will be used", secret.Namespace, secret.Name, minSyncIntervalSeconds, syncDuration)
 				h.recorder.Eventf(secret, corev1.EventTypeWarning, "Warn", "syncInterval < %ds, min %v will be used", minSyncIntervalSeconds, syncDuration)
 			}
-			if lastSyncTime, err := time.Parse(time.RFC3339, secret.Status.SyncTimestamp); err == nil {
+			if secret.Status.SyncTimestamp != nil {
 				nowTime := time.Now()
-				nextSync := lastSyncTime.Add(syncDuration)
+				nextSync := secret.Status.SyncTimestamp.Add(syncDuration)
 				// sync update is required
 				if nextSync.Before(nowTime) {
 					updateRequired = true
@@ -220,10 +392,10 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 				}
 			}
 
-			common.Log.Debugf("enqueing %s/%s for sync after %s", secret.Namespace, secret.Name, syncDuration.String())
+			log.Debugf("enqueing %s/%s for sync after %s", secret.Namespace, secret.Name, syncDuration.String())
 			h.dhSecretsController.EnqueueAfter(secret.Namespace, secret.Name, syncDuration)
 		} else {
-			if managedSecret, err := h.secrets.Get(secret.Namespace, secret.SecretSpec.Name, metav1.GetOptions{}); err == nil {
+			if managedSecret, err := h.secrets.Get(secret.Namespace, secret.Spec.ManagedSecret.Name, metav1.GetOptions{}); err == nil {
 				if managedSecret.ResourceVersion != secret.Status.ObservedSecretResourceVersion {
 					updateRequired = true
 				}
@@ -233,59 +405,34 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 		}
 
 		if !updateRequired {
-			common.Log.Debugf("skipping update %s", secret.Name)
-			common.Log.Debugf("%s metadata.generation[%d]==status.observedGeneration[%d]", secret.Name, secret.Generation, secret.Status.ObservedGeneration)
-			common.Log.Debugf("%s annotationChecksum[%s]==status.observedAnnotationChecksum[%s]", secret.Name, annotationChecksum, secret.Status.ObservedAnnotationChecksum)
+			log.Debugf("skipping update %s", secret.Name)
+			log.Debugf("%s metadata.generation[%d]==status.observedGeneration[%d]", secret.Name, secret.Generation, secret.Status.ObservedGeneration)
+			log.Debugf("%s annotationChecksum[%s]==status.observedAnnotationChecksum[%s]", secret.Name, annotationChecksum, secret.Status.ObservedAnnotationChecksum)
 			return nil, nil
 		}
 	}
 
-	common.Log.Debugf("Secret change: %v", secret)
-	profileNamespace := secret.Namespace
-	if secret.Profile.Namespace != "" {
-		profileNamespace = secret.Profile.Namespace
-	}
-	if secret.Namespace != profileNamespace && !h.crossNamespaceAuthorized {
-		err := fmt.Errorf(
-			"could not access Profile[%s] in external namespace %s, cross namespace profile access is disabled",
-			secret.Profile,
-			profileNamespace)
-		h.recorder.Eventf(
-			secret,
-			corev1.EventTypeWarning,
-			"ErrUnauthorized",
-			"Could not access Profile[%s] in external namespace %s",
-			secret.Profile,
-			profileNamespace)
-		statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-		common.LogIfError(statusErr)
-		return nil, err
-	}
-	profile, err := h.dhSecretsProfileController.Get(profileNamespace, secret.Profile.Name, metav1.GetOptions{})
-
+	log.Debugf("Secret change: %s/%s generation %d", secret.Namespace, secret.Name, secret.Generation)
+	profilesChecksum := h.getProfilesChecksum(secret)
+	profiles, profileStatuses, err := h.resolveProfiles(log, secret)
+	secret = secret.DeepCopy()
+	secret.Status.Profiles = profileStatuses
 	if err != nil {
-		common.Log.Warnf("could not get profile %s/%s", profileNamespace, secret.Profile.Name)
-		h.recorder.Eventf(
-			secret,
-			corev1.EventTypeWarning,
-			"ErrLoadingProfile",
-			"Could not get profile %s/%s",
-			profileNamespace,
-			secret.Profile)
-		statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-		common.LogIfError(statusErr)
+		statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied)
+		log.LogIfError(statusErr)
 		return nil, err
 	}
 
-	profileFunctionMap, err := h.getProfileFuncMap(profile)
+	render := &providers.Render{Secret: secret, Context: ctx, LogFields: log.Fields()}
+	profileFunctionMap, err := h.getProfileFuncMap(log, profiles, render)
 	if err != nil {
+		err = common.RedactError(err)
 		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrLoadingProfile", "Could not load Profile: %v", err)
-		statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-		common.LogIfError(statusErr)
+		h.failBackendSync(log, secret, err)
 		return nil, err
 	}
 
-	k8sCacheSecret, err := h.secrets.Get(secret.Namespace, secret.SecretSpec.Name, metav1.GetOptions{})
+	k8sCacheSecret, err := h.secrets.Get(secret.Namespace, secret.Spec.ManagedSecret.Name, metav1.GetOptions{})
 
 	var k8sSecret *corev1.Secret
 
@@ -293,9 +440,9 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 	if errors.IsNotFound(err) {
 		newSecret = true
 		k8sSecret = &corev1.Secret{
-			Type: corev1.SecretType(secret.SecretSpec.Type),
+			Type: corev1.SecretType(secret.Spec.ManagedSecret.Type),
 			ObjectMeta: metav1.ObjectMeta{
-				Name:        secret.SecretSpec.Name,
+				Name:        secret.Spec.ManagedSecret.Name,
 				Namespace:   secret.Namespace,
 				Labels:      make(map[string]string),
 				Annotations: make(map[string]string),
@@ -303,6 +450,8 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 			Data: make(map[string][]byte),
 		}
 	} else {
+		// values of the managed secret are redacted from logs, events and errors
+		common.SetSecretValues(secretValuesOwner(secret), k8sCacheSecret.Data)
 		k8sSecret = k8sCacheSecret.DeepCopy()
 		if k8sSecret.Labels == nil {
 			k8sSecret.Labels = make(map[string]string)
@@ -312,14 +461,14 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 		}
 	}
 
-	if secret.SecretSpec.Labels != nil {
-		for k, v := range secret.SecretSpec.Labels {
+	if secret.Spec.ManagedSecret.Labels != nil {
+		for k, v := range secret.Spec.ManagedSecret.Labels {
 			k8sSecret.Labels[k] = v
 		}
 	}
 
-	if secret.SecretSpec.Annotations != nil {
-		for k, v := range secret.SecretSpec.Annotations {
+	if secret.Spec.ManagedSecret.Annotations != nil {
+		for k, v := range secret.Spec.ManagedSecret.Annotations {
 			k8sSecret.Annotations[k] = v
 		}
 	}
@@ -329,64 +478,140 @@ func (h *Handler) onDockhandSecretChange(_ string, secret *dockhand.Secret) (*do
 
 	// clear data
 	k8sSecret.Data = make(map[string][]byte)
-	for k, v := range secret.Data {
 
-		secretData, err := dockcmdCommon.ParseSecretsTemplate([]byte(v), profileFunctionMap)
+	// dataFrom is applied first so that keys explicitly defined in data take precedence
+	for _, source := range secret.Spec.DataFrom {
+		sourceData, err := h.getDataFromSource(log, profiles, source, render)
+		if err != nil {
+			err = common.RedactError(err, k8sSecret.Data, render.Values())
+			h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrLoadingDataFrom", "Could not load dataFrom %v", err)
+			h.failBackendSync(log, secret, err)
+			return nil, err
+		}
+		for k, v := range sourceData {
+			k8sSecret.Data[k] = []byte(v)
+		}
+	}
+
+	// generated keys are reused from the current managed secret unless their generator is rotated
+	var currentSecret *corev1.Secret
+	if !newSecret {
+		currentSecret = k8sCacheSecret
+	}
+	generatedData, rotations, err := generateSecretData(secret.Spec.Generators, secret.Spec.Data, currentSecret)
+	if err == nil {
+		err = setGeneratorRotations(k8sSecret, rotations)
+	}
+	if err != nil {
+		err = common.RedactError(err, k8sSecret.Data, render.Values())
+		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrGeneratingSecret", "Could not generate secret data %v", err)
+		statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied)
+		log.LogIfError(statusErr)
+		return nil, err
+	}
+	for k, v := range generatedData {
+		k8sSecret.Data[k] = v
+	}
+	profileFunctionMap["generated"] = generatedFunc(generatedData)
+
+	for k, v := range secret.Spec.Data {
+		var previous []byte
+		if currentSecret != nil {
+			previous = currentSecret.Data[k]
+		}
+
+		secretData, err := templates.Render(v, secret.Spec.TemplateFunctions, profileFunctionMap, previous)
 
 		if err != nil {
+			// template errors may echo the values returned by secrets backends
+			err = common.RedactError(err, k8sSecret.Data, render.Values())
 			h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrParsingSecret", "Could not parse template %v", err)
-			statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-			common.LogIfError(statusErr)
+			h.failBackendSync(log, secret, err)
 			return nil, err
 		}
-		common.Log.Debugf("%s: %s", k, secretData)
+		log.Debugf("rendered key %s of %s/%s", k, secret.Namespace, secret.Name)
 		k8sSecret.Data[k] = secretData
 	}
 
+	secretType := corev1.SecretType(secret.Spec.ManagedSecret.Type)
+	if dataErr := renderSecretType(secretType, k8sSecret.Data); dataErr != nil {
+		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrInvalidSecretData", "Secret data is not valid for type %s: %v", secretType, dataErr)
+		statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied, dataValidCondition(dataErr))
+		log.LogIfError(statusErr)
+		return nil, dataErr
+	}
+
 	var managedSecretUpdate *corev1.Secret
 
 	if newSecret {
-		if managedSecretUpdate, err = h.secrets.Create(k8sSecret); err == nil {
-			h.recorder.Eventf(secret, corev1.EventTypeNormal, "Success", "Secret %s/%s created", secret.Namespace, secret.SecretSpec.Name)
+		_, createSpan := tracing.Start(ctx, "create Secret", tracing.ObjectAttributes(kindSecret, k8sSecret.Namespace, k8sSecret.Name)...)
+		managedSecretUpdate, err = h.secrets.Create(k8sSecret)
+		tracing.End(createSpan, err)
+		if err == nil {
+			h.recorder.Eventf(secret, corev1.EventTypeNormal, "Success", "Secret %s/%s created", secret.Namespace, secret.Spec.ManagedSecret.Name)
 		} else {
-			h.recorder.Eventf(secret, corev1.EventTypeWarning, "Error", "Secret %s/%s not created", secret.Namespace, secret.SecretSpec.Name)
-			statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-			common.LogIfError(statusErr)
+			h.recorder.Eventf(secret, corev1.EventTypeWarning, "Error", "Secret %s/%s not created", secret.Namespace, secret.Spec.ManagedSecret.Name)
+			statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied)
+			log.LogIfError(statusErr)
 			return nil, err
 		}
 	} else {
 		currVersion := k8sSecret.ResourceVersion
-		if managedSecretUpdate, err = h.secrets.Update(k8sSecret); err == nil {
+		_, updateSpan := tracing.Start(ctx, "update Secret", tracing.ObjectAttributes(kindSecret, k8sSecret.Namespace, k8sSecret.Name)...)
+		managedSecretUpdate, err = h.secrets.Update(k8sSecret)
+		tracing.End(updateSpan, err)
+		if err == nil {
 			if managedSecretUpdate.ResourceVersion != currVersion {
-				h.recorder.Eventf(secret, corev1.EventTypeNormal, "Success", "Secret %s/%s updated", secret.Namespace, secret.SecretSpec.Name)
+				h.recorder.Eventf(secret, corev1.EventTypeNormal, "Success", "Secret %s/%s updated", secret.Namespace, secret.Spec.ManagedSecret.Name)
 			}
 		} else {
-			h.recorder.Eventf(secret, corev1.EventTypeWarning, "Error", "Secret %s/%s not updated", secret.Namespace, secret.SecretSpec.Name)
-			statusErr := h.updateDockhandSecretStatus(secret, nil, dockhand.ErrApplied)
-			common.LogIfError(statusErr)
+			h.recorder.Eventf(secret, corev1.EventTypeWarning, "Error", "Secret %s/%s not updated", secret.Namespace, secret.Spec.ManagedSecret.Name)
+			statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied)
+			log.LogIfError(statusErr)
 			return nil, err
 		}
 	}
 
 	// if we have made it here the secret is provisioned and ready
-	if err := h.updateDockhandSecretStatus(secret, managedSecretUpdate, dockhand.Ready); err != nil {
+	common.SetSecretValues(secretValuesOwner(secret), k8sSecret.Data)
+	secret = secret.DeepCopy()
+	secret.Status.RefreshTimestamp = nil
+	if !render.Refresh.IsZero() {
+		secret.Status.RefreshTimestamp = &metav1.Time{Time: render.Refresh}
+		h.dhSecretsController.EnqueueAfter(secret.Namespace, secret.Name, time.Until(render.Refresh))
+	}
+	secret.Status.Sources = render.Sources()
+	secret.Status.Backends = render.ServedBy()
+	secret.Status.ObservedProfilesChecksum = profilesChecksum
+	secret.Status.ObservedSourcesChecksum = ""
+	if len(secret.Status.Sources) > 0 {
+		secret.Status.ObservedSourcesChecksum = sourcesChecksum(render.SourceVersions())
+	}
+	if err := h.updateDockhandSecretStatus(log, secret, managedSecretUpdate, dockhand.Ready, syncedConditions(secret)...); err != nil {
 		// log status update error but continue
-		common.LogIfError(err)
+		log.LogIfError(err)
 	}
+	metrics.ClearStale(secret.Namespace, secret.Name)
 
-	h.updateDeployments(secret.Name, secret.Namespace)
-	h.updateDaemonSets(secret.Name, secret.Namespace)
-	h.updateStatefulSets(secret.Name, secret.Namespace)
+	h.updateDeployments(ctx, log, secret.Name, secret.Namespace)
+	h.updateDaemonSets(ctx, log, secret.Name, secret.Namespace)
+	h.updateStatefulSets(ctx, log, secret.Name, secret.Namespace)
 
 	return nil, nil
 }
 
+// secretValuesOwner returns the owner of the values of the managed secret of secret in the redacted secret values
+func secretValuesOwner(secret *dockhand.Secret) string {
+	return "Secret/" + secret.Namespace + "/" + secret.Name
+}
+
 // processDaemonSet handler checks DaemonSets for the AutoUpdateLabel and if it is set to true will determine if any
 // of the referenced secrets have been modified.
-func (h *Handler) processDaemonSet(daemonset *v1.DaemonSet) (*v1.DaemonSet, error) {
+func (h *Handler) processDaemonSet(ctx context.Context, log *common.Logger, daemonset *v1.DaemonSet) (*v1.DaemonSet, error) {
 	if daemonset.Labels != nil && daemonset.Labels[dockhand.AutoUpdateLabelKey] == "true" {
 
 		labels, annotations := h.getUpdatedLabelsAndAnnotations(
+			log,
 			daemonset.GetNamespace(),
 			daemonset.GetLabels(),
 			daemonset.Spec.Template.GetAnnotations())
@@ -397,8 +622,11 @@ func (h *Handler) processDaemonSet(daemonset *v1.DaemonSet) (*v1.DaemonSet, erro
 			patch = append(patch, k8s.GenerateMetadataLabelsPatch(daemonset.GetLabels(), labels)...)
 			patchBytes, _ := json.Marshal(patch)
 
-			if _, err := h.daemonSets.Patch(daemonset.GetNamespace(), daemonset.GetName(), types.JSONPatchType, patchBytes); err != nil {
-				common.Log.Warnf("unable to update %s error:[%v]", daemonset.GetName(), err)
+			_, span := tracing.Start(ctx, "patch DaemonSet", tracing.ObjectAttributes(kindDaemonSet, daemonset.GetNamespace(), daemonset.GetName())...)
+			_, err := h.daemonSets.Patch(daemonset.GetNamespace(), daemonset.GetName(), types.JSONPatchType, patchBytes)
+			tracing.End(span, err)
+			if err != nil {
+				log.Warnf("unable to update %s error:[%v]", daemonset.GetName(), err)
 				return nil, err
 			}
 		}
@@ -408,9 +636,10 @@ func (h *Handler) processDaemonSet(daemonset *v1.DaemonSet) (*v1.DaemonSet, erro
 
 // processDeployment checks Deployments for the AutoUpdateLabel and if it is set to true will determine if any
 // of the referenced secrets have been modified.
-func (h *Handler) processDeployment(deployment *v1.Deployment) (*v1.Deployment, error) {
+func (h *Handler) processDeployment(ctx context.Context, log *common.Logger, deployment *v1.Deployment) (*v1.Deployment, error) {
 	if deployment.Labels != nil && deployment.Labels[dockhand.AutoUpdateLabelKey] == "true" {
 		labels, annotations := h.getUpdatedLabelsAndAnnotations(
+			log,
 			deployment.GetNamespace(),
 			deployment.GetLabels(),
 			deployment.Spec.Template.GetAnnotations())
@@ -421,8 +650,11 @@ func (h *Handler) processDeployment(deployment *v1.Deployment) (*v1.Deployment,
 			patch = append(patch, k8s.GenerateMetadataLabelsPatch(deployment.GetLabels(), labels)...)
 			patchBytes, _ := json.Marshal(patch)
 
-			if _, err := h.deployments.Patch(deployment.GetNamespace(), deployment.GetName(), types.JSONPatchType, patchBytes); err != nil {
-				common.Log.Warnf("unable to update %s error:[%v]", deployment.GetName(), err)
+			_, span := tracing.Start(ctx, "patch Deployment", tracing.ObjectAttributes(kindDeployment, deployment.GetNamespace(), deployment.GetName())...)
+			_, err := h.deployments.Patch(deployment.GetNamespace(), deployment.GetName(), types.JSONPatchType, patchBytes)
+			tracing.End(span, err)
+			if err != nil {
+				log.Warnf("unable to update %s error:[%v]", deployment.GetName(), err)
 				return nil, err
 			}
 		}
@@ -432,9 +664,10 @@ func (h *Handler) processDeployment(deployment *v1.Deployment) (*v1.Deployment,
 
 // processStatefulSet checks StatefulSets for the AutoUpdateLabel and if it is set to true will determine if any
 // of the referenced secrets have been modified.
-func (h *Handler) processStatefulSet(statefulset *v1.StatefulSet) (*v1.StatefulSet, error) {
+func (h *Handler) processStatefulSet(ctx context.Context, log *common.Logger, statefulset *v1.StatefulSet) (*v1.StatefulSet, error) {
 	if statefulset.Labels != nil && statefulset.Labels[dockhand.AutoUpdateLabelKey] == "true" {
 		labels, annotations := h.getUpdatedLabelsAndAnnotations(
+			log,
 			statefulset.GetNamespace(),
 			statefulset.GetLabels(),
 			statefulset.Spec.Template.GetAnnotations())
@@ -445,8 +678,11 @@ func (h *Handler) processStatefulSet(statefulset *v1.StatefulSet) (*v1.StatefulS
 			patch = append(patch, k8s.GenerateMetadataLabelsPatch(statefulset.GetLabels(), labels)...)
 			patchBytes, _ := json.Marshal(patch)
 
-			if _, err := h.statefulSets.Patch(statefulset.GetNamespace(), statefulset.GetName(), types.JSONPatchType, patchBytes); err != nil {
-				common.Log.Warnf("unable to update %s error:[%v]", statefulset.GetName(), err)
+			_, span := tracing.Start(ctx, "patch StatefulSet", tracing.ObjectAttributes(kindStatefulSet, statefulset.GetNamespace(), statefulset.GetName())...)
+			_, err := h.statefulSets.Patch(statefulset.GetNamespace(), statefulset.GetName(), types.JSONPatchType, patchBytes)
+			tracing.End(span, err)
+			if err != nil {
+				log.Warnf("unable to update %s error:[%v]", statefulset.GetName(), err)
 				return nil, err
 			}
 		}
@@ -455,251 +691,207 @@ func (h *Handler) processStatefulSet(statefulset *v1.StatefulSet) (*v1.StatefulS
 }
 
 // updateStatefulSets updates statefulsets in the provided namespace if they reference a dockhand secret
-func (h *Handler) updateStatefulSets(dockhandSecretName string, namespace string) {
+func (h *Handler) updateStatefulSets(ctx context.Context, log *common.Logger, dockhandSecretName string, namespace string) {
 	labelSelector := dockhand.DockhandSecretNamesLabelPrefixKey + dockhandSecretName
 
 	if statefulsets, err := h.statefulSets.List(namespace, metav1.ListOptions{LabelSelector: labelSelector}); err == nil {
 		for _, statefulset := range statefulsets.Items {
-			if _, err := h.processStatefulSet(&statefulset); err != nil {
-				common.Log.Warnf("error updating %s: %v", statefulset.Name, err)
+			if _, err := h.processStatefulSet(ctx, log, &statefulset); err != nil {
+				log.Warnf("error updating %s: %v", statefulset.Name, err)
 			}
 		}
 	} else {
-		common.Log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
+		log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
 	}
 }
 
 // updateDeployments updates deployments in the provided namespace if they reference a dockhand secret
-func (h *Handler) updateDeployments(dockhandSecretName, namespace string) {
+func (h *Handler) updateDeployments(ctx context.Context, log *common.Logger, dockhandSecretName, namespace string) {
 	labelSelector := dockhand.DockhandSecretNamesLabelPrefixKey + dockhandSecretName
 
 	if deployments, err := h.deployments.List(namespace, metav1.ListOptions{LabelSelector: labelSelector}); err == nil {
 		for _, deployment := range deployments.Items {
-			if _, err := h.processDeployment(&deployment); err != nil {
-				common.Log.Warnf("error updating %s: %v", deployment.Name, err)
+			if _, err := h.processDeployment(ctx, log, &deployment); err != nil {
+				log.Warnf("error updating %s: %v", deployment.Name, err)
 			}
 		}
 	} else {
-		common.Log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
+		log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
 	}
 }
 
 // updateDaemonSets updates daemonsets in the provided namespace if they reference a dockhand secret
-func (h *Handler) updateDaemonSets(dockhandSecretName, namespace string) {
+func (h *Handler) updateDaemonSets(ctx context.Context, log *common.Logger, dockhandSecretName, namespace string) {
 	labelSelector := dockhand.DockhandSecretNamesLabelPrefixKey + dockhandSecretName
 
 	if daemonsets, err := h.daemonSets.List(namespace, metav1.ListOptions{LabelSelector: labelSelector}); err == nil {
 		for _, daemonset := range daemonsets.Items {
-			if _, err := h.processDaemonSet(&daemonset); err != nil {
-				common.Log.Warnf("error updating %s: %v", daemonset.Name, err)
+			if _, err := h.processDaemonSet(ctx, log, &daemonset); err != nil {
+				log.Warnf("error updating %s: %v", daemonset.Name, err)
 			}
 		}
 	} else {
-		common.Log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
+		log.Warnf("error listing deployments associated with %s: %v", labelSelector, err)
 	}
 }
 
-func (h *Handler) onDaemonSetChange(_ string, daemonset *v1.DaemonSet) (*v1.DaemonSet, error) {
+func (h *Handler) onDaemonSetChange(key string, daemonset *v1.DaemonSet) (*v1.DaemonSet, error) {
 	if daemonset == nil {
 		return nil, nil
 	}
-	return h.processDaemonSet(daemonset)
+	return h.processDaemonSet(h.ctx, h.reconcileLog(kindDaemonSet, key), daemonset)
 }
 
-func (h *Handler) onDeploymentChange(_ string, deployment *v1.Deployment) (*v1.Deployment, error) {
+func (h *Handler) onDeploymentChange(key string, deployment *v1.Deployment) (*v1.Deployment, error) {
 	if deployment == nil {
 		return nil, nil
 	}
-	return h.processDeployment(deployment)
+	return h.processDeployment(h.ctx, h.reconcileLog(kindDeployment, key), deployment)
 }
 
-func (h *Handler) onStatefulSetChange(_ string, statefulset *v1.StatefulSet) (*v1.StatefulSet, error) {
+func (h *Handler) onStatefulSetChange(key string, statefulset *v1.StatefulSet) (*v1.StatefulSet, error) {
 	if statefulset == nil {
 		return nil, nil
 	}
-	return h.processStatefulSet(statefulset)
+	return h.processStatefulSet(h.ctx, h.reconcileLog(kindStatefulSet, key), statefulset)
 }
 
-func (h *Handler) getProfileFuncMap(profile *dockhand.Profile) (template.FuncMap, error) {
+// getProfileClients returns the clients of every provider configured by profile, building them on first use.
+func (h *Handler) getProfileClients(log *common.Logger, profile *dockhand.Profile) (map[string]providers.Client, error) {
 	profileName := profile.Namespace + "/" + profile.Name
 
-	funcMap := make(template.FuncMap)
-
-	if profile.AwsSecretsManager != nil {
-		client, ok := h.awsProfileMap[profileName]
-		if !ok {
-			common.Log.Debugf("creating new aws client for %s", profileName)
-			opts := []aws.SecretsClientOpt{aws.Region(profile.AwsSecretsManager.Region)}
+	h.profileClientsMutex.Lock()
+	defer h.profileClientsMutex.Unlock()
 
-			if cacheTTL, err := time.ParseDuration(profile.AwsSecretsManager.CacheTTL); err == nil {
-				opts = append(opts, aws.CacheTTL(cacheTTL))
-			} else {
-				return nil, err
-			}
-			accessKeyID := ""
-			secretAccessKey := ""
-			if profile.AwsSecretsManager.AccessKeyId != nil {
-				accessKeyID = *profile.AwsSecretsManager.AccessKeyId
-			}
-
-			if profile.AwsSecretsManager.SecretAccessKeyRef != nil {
-				if secretData, err := h.secrets.Get(profile.Namespace, profile.AwsSecretsManager.SecretAccessKeyRef.Name, metav1.GetOptions{}); err == nil {
-					if secretData != nil {
-						secretAccessKey = string(secretData.Data[profile.AwsSecretsManager.SecretAccessKeyRef.Key])
-					}
-				} else {
-					return nil, err
-				}
-			}
-			if accessKeyID != "" && secretAccessKey != "" {
-				opts = append(opts, aws.AccessKeyIDAndSecretAccessKey(accessKeyID, secretAccessKey))
-			} else {
-				opts = append(opts, aws.UseChainCredentials())
-			}
+	if clients, ok := h.profileClients[profileName]; ok && h.profileGenerations[profileName] == profile.Generation {
+		return clients, nil
+	}
 
-			var err error
-			client, err = aws.NewSecretsClient(opts...)
-			if err != nil {
-				return nil, err
-			}
-			h.awsProfileMap[profileName] = client
+	clients := make(map[string]providers.Client)
+	for _, provider := range h.providers.All() {
+		if !provider.Configured(profile) {
+			continue
+		}
+		if err := provider.ValidateConfig(profile); err != nil {
+			return nil, err
+		}
+		log.Debugf("creating new %s client for %s", provider.Name(), profileName)
+		client, err := provider.NewClient(h.ctx, profile, h)
+		if err != nil {
+			return nil, err
 		}
-		funcMap["aws"] = client.GetJSONSecret
-		funcMap["awsJson"] = client.GetJSONSecret
-		funcMap["awsText"] = client.GetTextSecret
+		clients[provider.Name()] = client
 	}
+	h.profileClients[profileName] = clients
+	h.profileGenerations[profileName] = profile.Generation
+	return clients, nil
+}
 
-	if profile.AzureKeyVault != nil {
-		client, ok := h.azureProfileMap[profileName]
-		if !ok {
-			common.Log.Debugf("creating new azure key vault client for %s", profileName)
-			opts := []azure.SecretsClientOpt{
-				azure.KeyVaultName(profile.AzureKeyVault.KeyVault),
-				azure.TenantID(profile.AzureKeyVault.Tenant)}
+// observeProfile records generation as the last generation of the Profile key and reports whether it changed
+func (h *Handler) observeProfile(key string, generation int64) bool {
+	h.profileClientsMutex.Lock()
+	defer h.profileClientsMutex.Unlock()
+	if observed, ok := h.observedProfiles[key]; ok && observed == generation {
+		return false
+	}
+	h.observedProfiles[key] = generation
+	return true
+}
 
-			if cacheTTL, err := time.ParseDuration(profile.AzureKeyVault.CacheTTL); err == nil {
-				opts = append(opts, azure.CacheTTL(cacheTTL))
-			} else {
-				return nil, err
-			}
+// GetSecret implements providers.Reader for the secrets referenced by Profiles, secrets are read from the cache
+func (h *Handler) GetSecret(namespace, name string) (*corev1.Secret, error) {
+	return h.secrets.Cache().Get(namespace, name)
+}
 
-			clientID := ""
-			clientSecret := ""
-			if profile.AzureKeyVault.ClientId != nil {
-				clientID = *profile.AzureKeyVault.ClientId
-			}
+// GetConfigMap implements providers.Reader for the configmaps referenced by Profiles
+func (h *Handler) GetConfigMap(namespace, name string) (*corev1.ConfigMap, error) {
+	return h.configMaps.Get(namespace, name, metav1.GetOptions{})
+}
 
-			if profile.AzureKeyVault.ClientSecretRef != nil {
-				if secretData, err := h.secrets.Get(profile.Namespace, profile.AzureKeyVault.ClientSecretRef.Name, metav1.GetOptions{}); err == nil {
-					if secretData != nil {
-						clientSecret = string(secretData.Data[profile.AzureKeyVault.ClientSecretRef.Key])
-					}
-				} else {
-					return nil, err
+// getProfileFuncMap returns the template functions for the providers of profiles keyed by alias, the functions of
+// aliased profiles are suffixed with _<alias>. render collects the earliest time at which a registry token rendered by
+// the functions must be refreshed and the kubernetes Secrets they read.
+func (h *Handler) getProfileFuncMap(log *common.Logger, profiles map[string]*dockhand.Profile, render *providers.Render) (template.FuncMap, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["dockerConfigJson"] = providers.DockerConfigJson
+	for alias, profile := range profiles {
+		clients, err := h.getRenderClients(log, profile)
+		if err != nil {
+			return nil, err
+		}
+		profileKey := profile.Namespace + "/" + profile.Name
+		for backend, client := range clients {
+			for name, function := range providers.InstrumentFuncMap(render, backend, profileKey, client.FuncMap(render)) {
+				if alias != "" {
+					name += "_" + alias
 				}
+				funcMap[name] = function
 			}
-			if clientID != "" && clientSecret != "" {
-				opts = append(opts, azure.ClientIDAndSecret(clientID, clientSecret))
-			} else {
-				opts = append(opts, azure.UseChainCredentials())
-			}
-
-			var err error
-			client, err = azure.NewSecretsClient(opts...)
-			if err != nil {
-				return nil, err
-			}
-			h.azureProfileMap[profileName] = client
-
 		}
-		funcMap["azureJson"] = client.GetJSONSecret
-		funcMap["azureText"] = client.GetTextSecret
+	}
+	return funcMap, nil
+}
 
+// getDataFromSource retrieves every key of the json secret referenced by source from the profile with the alias of
+// source.
+func (h *Handler) getDataFromSource(log *common.Logger, profiles map[string]*dockhand.Profile, source dockhand.DataFromSource, render *providers.Render) (map[string]string, error) {
+	profile, ok := profiles[source.Profile]
+	if !ok {
+		return nil, fmt.Errorf("dataFrom profile %q is not defined", source.Profile)
+	}
+	clients, err := h.getRenderClients(log, profile)
+	if err != nil {
+		return nil, err
 	}
 
-	if profile.GcpSecretsManager != nil {
-		client, ok := h.gcpProfileMap[profileName]
+	for _, provider := range h.providers.All() {
+		name, ok := provider.DataFromName(&source)
 		if !ok {
-			common.Log.Debugf("creating new gcp client for %s", profileName)
-			opts := []gcp.SecretsClientOpt{gcp.Project(profile.GcpSecretsManager.Project)}
-			if cacheTTL, err := time.ParseDuration(profile.GcpSecretsManager.CacheTTL); err == nil {
-				opts = append(opts, gcp.CacheTTL(cacheTTL))
-			} else {
-				return nil, err
-			}
-			if profile.GcpSecretsManager.CredentialsFileSecretRef != nil {
-				if secretData, err := h.secrets.Get(profile.Namespace, profile.GcpSecretsManager.CredentialsFileSecretRef.Name, metav1.GetOptions{}); err == nil {
-					if secretData != nil {
-						opts = append(opts, gcp.CredentialsJson(secretData.Data[profile.GcpSecretsManager.CredentialsFileSecretRef.Key]))
-					}
-				} else {
-					return nil, err
-				}
-			}
-			opts = append(opts, gcp.WithContext(h.ctx))
-			var err error
-			client, err = gcp.NewSecretsClient(opts...)
-			if err != nil {
-				return nil, err
-			}
-			h.gcpProfileMap[profileName] = client
+			continue
 		}
-		funcMap["gcpJson"] = client.GetJSONSecret
-		funcMap["gcpText"] = client.GetTextSecret
-	}
-
-	if profile.Vault != nil {
-
-		client, ok := h.vaultProfileMap[profileName]
+		client, ok := clients[provider.Name()]
 		if !ok {
-			common.Log.Debugf("creating new vault client for %s", profileName)
-			opts := []vault.SecretsClientOpt{vault.Address(profile.Vault.Addr)}
-			if cacheTTL, err := time.ParseDuration(profile.Vault.CacheTTL); err == nil {
-				opts = append(opts, vault.CacheTTL(cacheTTL))
-			} else {
-				return nil, err
-			}
-
-			roleID := ""
-			secretID := ""
-			if profile.Vault.RoleId != nil {
-				roleID = *profile.Vault.RoleId
-			}
-			if profile.Vault.SecretIdRef != nil {
-				if secretData, err := h.secrets.Get(profile.Namespace, profile.Vault.SecretIdRef.Name, metav1.GetOptions{}); err == nil {
-					if secretData != nil {
-						secretID = string(secretData.Data[profile.Vault.SecretIdRef.Key])
-					}
-				} else {
-					return nil, err
-				}
-			}
-			if roleID != "" && secretID != "" {
-				opts = append(opts, vault.RoleAndSecretID(roleID, secretID), vault.AuthType(vault.RoleAuth))
-			}
-			if profile.Vault.TokenRef != nil {
-				if secretData, err := h.secrets.Get(profile.Namespace, profile.Vault.TokenRef.Name, metav1.GetOptions{}); err == nil {
-					if secretData != nil {
-						opts = append(opts, vault.Token(string(secretData.Data[profile.Vault.TokenRef.Key])), vault.AuthType(vault.TokenAuth))
-					}
-				} else {
-					return nil, err
-				}
-			}
-			var err error
-			client, err = vault.NewSecretsClient(opts...)
-			if err != nil {
-				return nil, err
-			}
-			h.vaultProfileMap[profileName] = client
+			return nil, fmt.Errorf("profile %s/%s does not define %s", profile.Namespace, profile.Name, provider.Name())
+		}
+		name, version := providers.SplitVersion(name)
+		ctx, span := tracing.Start(render.RequestContext(), provider.Name()+".GetSecret",
+			tracing.AttributeBackend.String(provider.Name()),
+			tracing.AttributeProfile.String(profile.Namespace+"/"+profile.Name),
+			tracing.AttributeFunction.String("GetSecret"))
+		secretJson, err := client.GetSecret(providers.WithRender(ctx, render), name, version)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		render.AddValue(secretJson)
+		var secretData map[string]interface{}
+		if err := json.Unmarshal([]byte(secretJson), &secretData); err != nil {
+			return nil, fmt.Errorf("dataFrom secret is not a json object: %v", err)
 		}
-		funcMap["vault"] = client.GetJSONSecret
+		return stringifySecretData(secretData)
 	}
+	return nil, fmt.Errorf("dataFrom source does not specify a secrets backend")
+}
 
-	return funcMap, nil
+// stringifySecretData converts json secret values to strings, nested values are stored as json.
+func stringifySecretData(secretData map[string]interface{}) (map[string]string, error) {
+	data := make(map[string]string, len(secretData))
+	for k, v := range secretData {
+		if str, ok := v.(string); ok {
+			data[k] = str
+			continue
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = string(value)
+	}
+	return data, nil
 }
 
 func (h *Handler) getUpdatedLabelsAndAnnotations(
+	log *common.Logger,
 	namespace string,
 	labels map[string]string,
 	annotations map[string]string) (map[string]string, map[string]string) {
@@ -729,29 +921,58 @@ func (h *Handler) getUpdatedLabelsAndAnnotations(
 		updatedLabels[dockhand.DockhandSecretNamesLabelPrefixKey+dhSecret] = "true"
 	}
 
-	checksum, err := k8s.GetSecretsChecksum(h.ctx, secrets, namespace)
+	checksum, err := k8s.SecretsChecksum(secrets, namespace, func(name string) (*corev1.Secret, error) {
+		return h.secrets.Get(namespace, name, metav1.GetOptions{})
+	})
 	if err != nil {
-		common.Log.Warnf("unable to get checksum secrets=%s in namespace=%s with error[%v]", secrets, namespace, err)
+		log.Warnf("unable to get checksum secrets=%s in namespace=%s with error[%v]", secrets, namespace, err)
 	}
 	updatedAnnotations[dockhand.SecretChecksumAnnotationKey] = checksum
 
 	return updatedLabels, updatedAnnotations
 }
 
-func (h *Handler) updateDockhandSecretStatus(secret *dockhand.Secret, managedSecret *corev1.Secret, state dockhand.SecretState) error {
-	common.Log.Debugf("updating %s status", secret.Name)
+// updateDockhandSecretStatus sets the state and Ready condition of the Dockhand Secret along with any additional
+// conditions provided.
+func (h *Handler) updateDockhandSecretStatus(
+	log *common.Logger,
+	secret *dockhand.Secret,
+	managedSecret *corev1.Secret,
+	state dockhand.SecretState,
+	conditions ...metav1.Condition) error {
+
+	log.Debugf("updating %s status", secret.Name)
 	secretCopy := secret.DeepCopy()
 	secretCopy.Status.State = state
 
-	if secretCopy.Status.SyncTimestamp == "" {
-		secretCopy.Status.SyncTimestamp = time.Unix(0, 0).Format(time.RFC3339)
+	readyCondition := metav1.Condition{
+		Type:    dockhand.ConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  string(state),
+		Message: fmt.Sprintf("Secret %s has not been synced", secret.Spec.ManagedSecret.Name),
+	}
+	switch state {
+	case dockhand.Ready:
+		readyCondition.Status = metav1.ConditionTrue
+		readyCondition.Message = fmt.Sprintf("Secret %s is synced", secret.Spec.ManagedSecret.Name)
+	case dockhand.Degraded:
+		readyCondition.Status = metav1.ConditionTrue
+		readyCondition.Message = fmt.Sprintf("Secret %s keeps the data of its last sync", secret.Spec.ManagedSecret.Name)
+	}
+	for _, condition := range append([]metav1.Condition{readyCondition}, conditions...) {
+		condition.ObservedGeneration = secret.Generation
+		meta.SetStatusCondition(&secretCopy.Status.Conditions, condition)
+	}
+
+	if secretCopy.Status.SyncTimestamp == nil {
+		secretCopy.Status.SyncTimestamp = &metav1.Time{Time: time.Unix(0, 0)}
 	}
 
 	// generation successfully processed so store observedGeneration
 	if state == dockhand.Ready {
 		secretCopy.Status.ObservedAnnotationChecksum = k8s.GetAnnotationsChecksum(secretCopy.Annotations)
 		secretCopy.Status.ObservedGeneration = secret.Generation
-		secretCopy.Status.SyncTimestamp = time.Now().Format(time.RFC3339)
+		secretCopy.Status.SyncTimestamp = &metav1.Time{Time: time.Now()}
 	}
 
 	if managedSecret != nil {
@@ -0,0 +1,656 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers/file"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	testNamespace     = "default"
+	testProfile       = "file"
+	testConfigMap     = "secrets"
+	testDocumentKey   = "secrets.yaml"
+	testSecret        = "app"
+	testManagedSecret = "app-secret"
+)
+
+// memoryProvider stands in for a Vault backend that PushSecrets write to, it holds every version of its secrets in
+// memory. onPut runs before a secret is written.
+type memoryProvider struct {
+	secrets map[string][]string
+	onPut   func(name string)
+	mutex   sync.Mutex
+}
+
+func (p *memoryProvider) Name() string {
+	return "memory"
+}
+
+func (p *memoryProvider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.Vault != nil
+}
+
+func (p *memoryProvider) ValidateConfig(_ *dockhand.Profile) error {
+	return nil
+}
+
+func (p *memoryProvider) NewClient(_ context.Context, _ *dockhand.Profile, _ providers.Reader) (providers.Client, error) {
+	return p, nil
+}
+
+func (p *memoryProvider) FuncNames() []string {
+	return []string{"memory"}
+}
+
+func (p *memoryProvider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.Vault == nil {
+		return "", false
+	}
+	return source.Vault.Path, true
+}
+
+func (p *memoryProvider) FuncMap(render *providers.Render) template.FuncMap {
+	return template.FuncMap{
+		"memory": func(name string) (string, error) {
+			return p.GetSecret(render.Context, name, "")
+		},
+	}
+}
+
+func (p *memoryProvider) GetSecret(_ context.Context, name string, _ string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	versions := p.secrets[name]
+	if len(versions) == 0 {
+		return "", fmt.Errorf("memory secret %s not found", name)
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (p *memoryProvider) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+func (p *memoryProvider) SecretVersion(_ context.Context, name string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.secrets[name]) == 0 {
+		return "", nil
+	}
+	return fmt.Sprint(len(p.secrets[name])), nil
+}
+
+func (p *memoryProvider) PutSecret(_ context.Context, name string, value string) (string, error) {
+	if p.onPut != nil {
+		p.onPut(name)
+	}
+	return p.write(name, value), nil
+}
+
+func (p *memoryProvider) DeleteSecret(_ context.Context, name string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.secrets, name)
+	return nil
+}
+
+// write stores value as a new version of secret name, as when it is written outside of the operator
+func (p *memoryProvider) write(name string, value string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.secrets[name] = append(p.secrets[name], value)
+	return fmt.Sprint(len(p.secrets[name]))
+}
+
+// testController holds the fake controllers of a Handler registered with the file and memory providers, the test
+// Profile serves the document held in a ConfigMap
+type testController struct {
+	backend      *memoryProvider
+	secrets      *fakeController[*corev1.Secret, *corev1.SecretList]
+	configMaps   *fakeController[*corev1.ConfigMap, *corev1.ConfigMapList]
+	deployments  *fakeController[*appsv1.Deployment, *appsv1.DeploymentList]
+	daemonSets   *fakeController[*appsv1.DaemonSet, *appsv1.DaemonSetList]
+	statefulSets *fakeController[*appsv1.StatefulSet, *appsv1.StatefulSetList]
+	dhSecrets    *fakeController[*dockhand.Secret, *dockhand.SecretList]
+	profiles     *fakeController[*dockhand.Profile, *dockhand.ProfileList]
+	pushSecrets  *fakeController[*dockhand.PushSecret, *dockhand.PushSecretList]
+	kubernetes   *fake.Clientset
+}
+
+func newTestController(t *testing.T, opts ...Option) *testController {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c := &testController{
+		kubernetes:   fake.NewSimpleClientset(),
+		backend:      &memoryProvider{secrets: make(map[string][]string)},
+		secrets:      newFakeController[*corev1.Secret, *corev1.SecretList]("secrets"),
+		configMaps:   newFakeController[*corev1.ConfigMap, *corev1.ConfigMapList]("configmaps"),
+		deployments:  newFakeController[*appsv1.Deployment, *appsv1.DeploymentList]("deployments"),
+		daemonSets:   newFakeController[*appsv1.DaemonSet, *appsv1.DaemonSetList]("daemonsets"),
+		statefulSets: newFakeController[*appsv1.StatefulSet, *appsv1.StatefulSetList]("statefulsets"),
+		dhSecrets:    newFakeController[*dockhand.Secret, *dockhand.SecretList]("secrets.dhs.dockhand.dev"),
+		profiles:     newFakeController[*dockhand.Profile, *dockhand.ProfileList]("profiles.dhs.dockhand.dev"),
+		pushSecrets:  newFakeController[*dockhand.PushSecret, *dockhand.PushSecretList]("pushsecrets.dhs.dockhand.dev"),
+	}
+	registry := providers.NewRegistry()
+	registry.Register(&file.Provider{})
+	registry.Register(c.backend)
+	Register(
+		ctx,
+		testNamespace,
+		c.kubernetes.CoreV1().Events(""),
+		c.daemonSets,
+		c.deployments,
+		c.statefulSets,
+		c.secrets,
+		c.configMaps,
+		c.dhSecrets,
+		c.profiles,
+		c.pushSecrets,
+		false,
+		append([]Option{WithProviders(registry)}, opts...)...)
+
+	mustCreate(t, c.configMaps, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: testConfigMap, Namespace: testNamespace},
+		Data: map[string]string{testDocumentKey: `
+db: s3cr3t
+database:
+  username: app
+  password: s3cr3t
+`},
+	})
+	// the document is loaded again on every render so that tests can change it
+	mustCreate(t, c.profiles, &dockhand.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: testProfile, Namespace: testNamespace},
+		Spec: dockhand.ProfileSpec{File: &dockhand.File{
+			CacheTTL:     "0s",
+			ConfigMapRef: &dockhand.ConfigMapRef{Name: testConfigMap, Key: testDocumentKey},
+		}},
+	})
+	return c
+}
+
+// setBackendSecret sets secret name of the document served by the file Profile
+func (c *testController) setBackendSecret(t *testing.T, name string, value interface{}) {
+	t.Helper()
+	configMap, err := c.configMaps.Get(testNamespace, testConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting configmap: %v", err)
+	}
+	document := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(configMap.Data[testDocumentKey]), &document); err != nil {
+		t.Fatalf("parsing document: %v", err)
+	}
+	document[name] = value
+	data, err := yaml.Marshal(document)
+	if err != nil {
+		t.Fatalf("writing document: %v", err)
+	}
+	configMap.Data[testDocumentKey] = string(data)
+	if _, err := c.configMaps.Update(configMap); err != nil {
+		t.Fatalf("updating configmap: %v", err)
+	}
+}
+
+// newSecret returns a Dockhand Secret of the file Profile rendering data with the v1 template functions
+func newSecret(data map[string]string) *dockhand.Secret {
+	return &dockhand.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testSecret, Namespace: testNamespace},
+		Spec: dockhand.SecretSpec{
+			Profile:           dockhand.ProfileRef{Name: testProfile},
+			Data:              data,
+			TemplateFunctions: "v1",
+			ManagedSecret:     dockhand.ManagedSecretSpec{Name: testManagedSecret, Type: string(corev1.SecretTypeOpaque)},
+		},
+	}
+}
+
+// syncSecret runs the change handler of the Dockhand Secret
+func (c *testController) syncSecret() error {
+	return c.dhSecrets.sync(testNamespace + "/" + testSecret)
+}
+
+func (c *testController) dockhandSecret(t *testing.T) *dockhand.Secret {
+	t.Helper()
+	secret, err := c.dhSecrets.Get(testNamespace, testSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting dockhand secret: %v", err)
+	}
+	return secret
+}
+
+func (c *testController) managedSecret(t *testing.T) *corev1.Secret {
+	t.Helper()
+	secret, err := c.secrets.Get(testNamespace, testManagedSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting managed secret: %v", err)
+	}
+	return secret
+}
+
+func assertState(t *testing.T, secret *dockhand.Secret, state dockhand.SecretState) {
+	t.Helper()
+	if secret.Status.State != state {
+		t.Errorf("state = %s, want %s", secret.Status.State, state)
+	}
+	ready := meta.FindStatusCondition(secret.Status.Conditions, dockhand.ConditionReady)
+	if ready == nil {
+		t.Fatalf("Ready condition is missing")
+	}
+	if ready.Reason != string(state) {
+		t.Errorf("Ready reason = %s, want %s", ready.Reason, state)
+	}
+}
+
+func TestSecretCreatesManagedSecret(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{
+		"password": `<< fileText "db" >>`,
+		"username": `<< fileJson "database" "username" >>`,
+	}))
+
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	managed := c.managedSecret(t)
+	if got := string(managed.Data["password"]); got != "s3cr3t" {
+		t.Errorf("password = %q, want s3cr3t", got)
+	}
+	if got := string(managed.Data["username"]); got != "app" {
+		t.Errorf("username = %q, want app", got)
+	}
+	if owner := managed.Labels[dockhand.DockhandSecretLabelKey]; owner != testSecret {
+		t.Errorf("owner label = %q, want %s", owner, testSecret)
+	}
+	secret := c.dockhandSecret(t)
+	assertState(t, secret, dockhand.Ready)
+	if secret.Status.ObservedGeneration != secret.Generation {
+		t.Errorf("observedGeneration = %d, want %d", secret.Status.ObservedGeneration, secret.Generation)
+	}
+	if secret.Status.ObservedSecretResourceVersion != managed.ResourceVersion {
+		t.Errorf("observedSecretResourceVersion = %s, want %s", secret.Status.ObservedSecretResourceVersion, managed.ResourceVersion)
+	}
+}
+
+func TestSecretUpdatesManagedSecret(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< fileText "db" >>`}))
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	synced := c.managedSecret(t)
+
+	// an unchanged Secret is not rendered again
+	c.setBackendSecret(t, "db", "rotated")
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if managed := c.managedSecret(t); managed.ResourceVersion != synced.ResourceVersion {
+		t.Errorf("unchanged secret updated the managed secret to %s", managed.Data["password"])
+	}
+
+	secret := c.dockhandSecret(t)
+	secret.Spec.Data["username"] = `app`
+	if _, err := c.dhSecrets.Update(secret); err != nil {
+		t.Fatalf("updating dockhand secret: %v", err)
+	}
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	managed := c.managedSecret(t)
+	if got := string(managed.Data["password"]); got != "rotated" {
+		t.Errorf("password = %q, want rotated", got)
+	}
+	if got := string(managed.Data["username"]); got != "app" {
+		t.Errorf("username = %q, want app", got)
+	}
+	secret = c.dockhandSecret(t)
+	assertState(t, secret, dockhand.Ready)
+	if secret.Status.ObservedGeneration != 2 {
+		t.Errorf("observedGeneration = %d, want 2", secret.Status.ObservedGeneration)
+	}
+}
+
+func TestSecretReconcileSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := tracing.NewTracerProvider("dockhand-secrets-operator", sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracerProvider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< fileText "db" >>`}))
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	secret := c.dockhandSecret(t)
+	secret.Spec.Data["username"] = `<< fileText "db" >>`
+	if _, err := c.dhSecrets.Update(secret); err != nil {
+		t.Fatalf("updating dockhand secret: %v", err)
+	}
+	exporter.Reset()
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	spans := make(map[string][]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+	reconciles := spans["onDockhandSecretChange"]
+	if len(reconciles) != 1 {
+		t.Fatalf("got %d reconcile spans, want 1 in %v", len(reconciles), spans)
+	}
+	reconcile := reconciles[0]
+	if reconcile.Parent.IsValid() {
+		t.Errorf("reconcile span has parent %s, want a root span", reconcile.Parent.SpanID())
+	}
+
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{name: "file.fileText", count: 2},
+		{name: "update Secret", count: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if len(spans[test.name]) != test.count {
+				t.Fatalf("got %d spans, want %d", len(spans[test.name]), test.count)
+			}
+			for _, span := range spans[test.name] {
+				if span.Parent.SpanID() != reconcile.SpanContext.SpanID() {
+					t.Errorf("parent = %s, want the reconcile span %s", span.Parent.SpanID(), reconcile.SpanContext.SpanID())
+				}
+				if span.SpanContext.TraceID() != reconcile.SpanContext.TraceID() {
+					t.Errorf("trace = %s, want the trace of the reconcile %s", span.SpanContext.TraceID(), reconcile.SpanContext.TraceID())
+				}
+			}
+		})
+	}
+	for _, attribute := range spans["file.fileText"][0].Attributes {
+		if attribute.Key == tracing.AttributeProfile && attribute.Value.AsString() != testNamespace+"/"+testProfile {
+			t.Errorf("profile attribute = %s, want %s/%s", attribute.Value.AsString(), testNamespace, testProfile)
+		}
+	}
+}
+
+func TestSecretRepairsDrift(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< fileText "db" >>`}))
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	managed := c.managedSecret(t)
+	managed.Data["password"] = []byte("tampered")
+	if _, err := c.secrets.Update(managed); err != nil {
+		t.Fatalf("updating managed secret: %v", err)
+	}
+	if err := c.secrets.sync(testNamespace + "/" + testManagedSecret); err != nil {
+		t.Fatalf("sync managed secret: %v", err)
+	}
+	if !c.dhSecrets.takeEnqueued(testNamespace + "/" + testSecret) {
+		t.Errorf("changing the managed secret did not enqueue the dockhand secret")
+	}
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if got := string(c.managedSecret(t).Data["password"]); got != "s3cr3t" {
+		t.Errorf("password = %q, want s3cr3t", got)
+	}
+
+	if err := c.secrets.Delete(testNamespace, testManagedSecret, nil); err != nil {
+		t.Fatalf("deleting managed secret: %v", err)
+	}
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if got := string(c.managedSecret(t).Data["password"]); got != "s3cr3t" {
+		t.Errorf("password of the recreated secret = %q, want s3cr3t", got)
+	}
+}
+
+func TestSecretProfileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		config  func(config *dockhand.File)
+		data    string
+		wantErr string
+	}{
+		{name: "missing profile", profile: "missing", data: `<< fileText "db" >>`, wantErr: "missing"},
+		{name: "invalid profile", profile: testProfile, config: func(config *dockhand.File) { config.ConfigMapRef.Key = "" }, data: `<< fileText "db" >>`, wantErr: "file.configMapRef requires name and key"},
+		{name: "missing backend secret", profile: testProfile, data: `<< fileText "other" >>`, wantErr: "file secret other not found"},
+		{name: "missing backend key", profile: testProfile, data: `<< fileJson "database" "token" >>`, wantErr: "file secret database does not contain token"},
+		{name: "unknown function", profile: testProfile, data: `<< vault "db" "password" >>`, wantErr: `function "vault" not defined`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t)
+			if test.config != nil {
+				profile, _ := c.profiles.Get(testNamespace, testProfile, metav1.GetOptions{})
+				test.config(profile.Spec.File)
+				if _, err := c.profiles.Update(profile); err != nil {
+					t.Fatalf("updating profile: %v", err)
+				}
+			}
+			secret := newSecret(map[string]string{"password": test.data})
+			secret.Spec.Profile.Name = test.profile
+			mustCreate(t, c.dhSecrets, secret)
+
+			err := c.syncSecret()
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("sync error = %v, want %s", err, test.wantErr)
+			}
+			if _, err := c.secrets.Get(testNamespace, testManagedSecret, metav1.GetOptions{}); !errors.IsNotFound(err) {
+				t.Errorf("managed secret was created, error %v", err)
+			}
+			assertState(t, c.dockhandSecret(t), dockhand.ErrApplied)
+		})
+	}
+}
+
+func TestSecretRedactsBackendValuesFromErrors(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< required (fileText "db") "" >>`}))
+
+	err := c.syncSecret()
+	if err == nil || !strings.Contains(err.Error(), "required value is missing") {
+		t.Fatalf("sync error = %v, want required value is missing", err)
+	}
+	if strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("sync error %q contains the value returned by the backend", err)
+	}
+	for _, condition := range c.dockhandSecret(t).Status.Conditions {
+		if strings.Contains(condition.Message, "s3cr3t") {
+			t.Errorf("condition %s %q contains the value returned by the backend", condition.Type, condition.Message)
+		}
+	}
+}
+
+func TestSecretKeepsStaleDataWhenBackendFails(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail makes the next sync of the Secret fail
+		fail       func(t *testing.T, c *testController)
+		wantState  dockhand.SecretState
+		wantReason string
+	}{
+		{
+			name: "backend unavailable",
+			fail: func(t *testing.T, c *testController) {
+				if err := c.configMaps.Delete(testNamespace, testConfigMap, nil); err != nil {
+					t.Fatalf("deleting configmap: %v", err)
+				}
+			},
+			wantState:  dockhand.Degraded,
+			wantReason: dockhand.ReasonBackendUnavailable,
+		},
+		{
+			name: "spec edit",
+			fail: func(t *testing.T, c *testController) {
+				secret := c.dockhandSecret(t)
+				secret.Spec.Data["password"] = `<< fileText "unavailable" >>`
+				if _, err := c.dhSecrets.Update(secret); err != nil {
+					t.Fatalf("updating dockhand secret: %v", err)
+				}
+			},
+			wantState: dockhand.ErrApplied,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t)
+			secret := newSecret(map[string]string{"password": `<< fileText "db" >>`})
+			secret.Spec.StaleOnError = &dockhand.StaleOnError{MaxStaleness: metav1.Duration{Duration: time.Hour}}
+			secret.Spec.SyncInterval = metav1.Duration{Duration: time.Minute}
+			mustCreate(t, c.dhSecrets, secret)
+			if err := c.syncSecret(); err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			// the sync interval has passed
+			secret = c.dockhandSecret(t)
+			secret.Status.SyncTimestamp = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+			if _, err := c.dhSecrets.UpdateStatus(secret); err != nil {
+				t.Fatalf("updating dockhand secret status: %v", err)
+			}
+
+			test.fail(t, c)
+			if err := c.syncSecret(); err == nil {
+				t.Fatalf("sync succeeded")
+			}
+			if got := string(c.managedSecret(t).Data["password"]); got != "s3cr3t" {
+				t.Errorf("password = %q, want the last synced s3cr3t", got)
+			}
+			secret = c.dockhandSecret(t)
+			assertState(t, secret, test.wantState)
+			degraded := meta.FindStatusCondition(secret.Status.Conditions, dockhand.ConditionDegraded)
+			if test.wantReason == "" {
+				if degraded != nil {
+					t.Errorf("Degraded condition = %+v, want none", degraded)
+				}
+			} else if degraded == nil || degraded.Reason != test.wantReason {
+				t.Errorf("Degraded condition = %+v, want reason %s", degraded, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestSecretRollsOutWorkloads(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< fileText "db" >>`}))
+	workloadLabels := map[string]string{
+		dockhand.AutoUpdateLabelKey:                             "true",
+		dockhand.DockhandSecretNamesLabelPrefixKey + testSecret: "true",
+	}
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{dockhand.SecretNamesAnnotationKey: testManagedSecret}},
+	}
+	mustCreate(t, c.deployments, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: testNamespace, Labels: workloadLabels},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	})
+	mustCreate(t, c.daemonSets, &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: testNamespace, Labels: workloadLabels},
+		Spec:       appsv1.DaemonSetSpec{Template: template},
+	})
+	mustCreate(t, c.statefulSets, &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: testNamespace, Labels: workloadLabels},
+		Spec:       appsv1.StatefulSetSpec{Template: template},
+	})
+	// workloads without the autoUpdate label are not rolled out
+	mustCreate(t, c.deployments, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "manual",
+			Namespace: testNamespace,
+			Labels:    map[string]string{dockhand.DockhandSecretNamesLabelPrefixKey + testSecret: "true"},
+		},
+		Spec: appsv1.DeploymentSpec{Template: template},
+	})
+
+	if err := c.syncSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	checksum, err := k8s.SecretsChecksum([]string{testManagedSecret}, testNamespace, func(name string) (*corev1.Secret, error) {
+		return c.secrets.Get(testNamespace, name, metav1.GetOptions{})
+	})
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	patches := map[string][]byte{
+		"Deployment web":  c.deployments.patches[testNamespace+"/web"],
+		"DaemonSet agent": c.daemonSets.patches[testNamespace+"/agent"],
+		"StatefulSet db":  c.statefulSets.patches[testNamespace+"/db"],
+	}
+	for workload, patch := range patches {
+		if got := patchedChecksum(t, patch); got != checksum {
+			t.Errorf("%s checksum annotation = %q, want %s", workload, got, checksum)
+		}
+	}
+	if patch, ok := c.deployments.patches[testNamespace+"/manual"]; ok {
+		t.Errorf("Deployment manual was patched with %s", patch)
+	}
+}
+
+// patchedChecksum returns the secret checksum annotation set by a JSON patch of a workload
+func patchedChecksum(t *testing.T, patch []byte) string {
+	t.Helper()
+	if patch == nil {
+		return ""
+	}
+	var operations []k8s.PatchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		t.Fatalf("decoding patch %s: %v", patch, err)
+	}
+	annotationPath := "/spec/template/metadata/annotations/" + strings.ReplaceAll(dockhand.SecretChecksumAnnotationKey, "/", "~1")
+	for _, operation := range operations {
+		if operation.Path == annotationPath {
+			value, _ := operation.Value.(string)
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,301 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/rancher/wrangler/v3/pkg/kv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeController is an in-memory wrangler controller that stores objects like the API server and serves the cache
+// from the same objects. Handlers only run when the test syncs an object. Methods the controller does not use panic
+// through the nil embedded interface.
+type fakeController[T generic.RuntimeMetaObject, TList runtime.Object] struct {
+	generic.ControllerInterface[T, TList]
+
+	resource        schema.GroupResource
+	objects         map[string]T
+	indexers        map[string]generic.Indexer[T]
+	onChange        []generic.ObjectHandler[T]
+	onRemove        []generic.ObjectHandler[T]
+	enqueued        map[string]bool
+	patches         map[string][]byte
+	resourceVersion int
+	mutex           sync.Mutex
+}
+
+func newFakeController[T generic.RuntimeMetaObject, TList runtime.Object](resource string) *fakeController[T, TList] {
+	return &fakeController[T, TList]{
+		resource: schema.GroupResource{Resource: resource},
+		objects:  make(map[string]T),
+		indexers: make(map[string]generic.Indexer[T]),
+		enqueued: make(map[string]bool),
+		patches:  make(map[string][]byte),
+	}
+}
+
+func objectKey(obj metav1.Object) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+func (c *fakeController[T, TList]) copy(obj T) T {
+	return obj.DeepCopyObject().(T)
+}
+
+func (c *fakeController[T, TList]) Create(obj T) (T, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var zero T
+	if _, ok := c.objects[objectKey(obj)]; ok {
+		return zero, errors.NewAlreadyExists(c.resource, obj.GetName())
+	}
+	obj = c.copy(obj)
+	c.resourceVersion++
+	obj.SetResourceVersion(fmt.Sprint(c.resourceVersion))
+	obj.SetGeneration(1)
+	c.objects[objectKey(obj)] = obj
+	return c.copy(obj), nil
+}
+
+// Update stores obj with a new resourceVersion and generation when it changed
+func (c *fakeController[T, TList]) Update(obj T) (T, error) {
+	return c.update(obj, true)
+}
+
+// UpdateStatus stores obj with a new resourceVersion when it changed
+func (c *fakeController[T, TList]) UpdateStatus(obj T) (T, error) {
+	return c.update(obj, false)
+}
+
+// update stores obj like the API server, an obj of another resourceVersion than the stored object is a conflict
+func (c *fakeController[T, TList]) update(obj T, generation bool) (T, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var zero T
+	current, ok := c.objects[objectKey(obj)]
+	if !ok {
+		return zero, errors.NewNotFound(c.resource, obj.GetName())
+	}
+	if obj.GetResourceVersion() != "" && obj.GetResourceVersion() != current.GetResourceVersion() {
+		return zero, errors.NewConflict(c.resource, obj.GetName(), fmt.Errorf("the object has been modified"))
+	}
+	obj = c.copy(obj)
+	obj.SetResourceVersion(current.GetResourceVersion())
+	obj.SetGeneration(current.GetGeneration())
+	if equality.Semantic.DeepEqual(obj, current) {
+		return c.copy(current), nil
+	}
+	c.resourceVersion++
+	obj.SetResourceVersion(fmt.Sprint(c.resourceVersion))
+	if generation {
+		obj.SetGeneration(current.GetGeneration() + 1)
+	}
+	c.objects[objectKey(obj)] = obj
+	return c.copy(obj), nil
+}
+
+func (c *fakeController[T, TList]) Delete(namespace, name string, _ *metav1.DeleteOptions) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.objects[namespace+"/"+name]; !ok {
+		return errors.NewNotFound(c.resource, name)
+	}
+	delete(c.objects, namespace+"/"+name)
+	return nil
+}
+
+func (c *fakeController[T, TList]) Get(namespace, name string, _ metav1.GetOptions) (T, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var zero T
+	obj, ok := c.objects[namespace+"/"+name]
+	if !ok {
+		return zero, errors.NewNotFound(c.resource, name)
+	}
+	return c.copy(obj), nil
+}
+
+func (c *fakeController[T, TList]) List(namespace string, opts metav1.ListOptions) (TList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		var zero TList
+		return zero, err
+	}
+	return newList[T, TList](c.list(namespace, selector))
+}
+
+// newList returns a new TList holding items
+func newList[T generic.RuntimeMetaObject, TList runtime.Object](items []T) (TList, error) {
+	var list TList
+	list = reflect.New(reflect.TypeOf(list).Elem()).Interface().(TList)
+	objects := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		objects = append(objects, item)
+	}
+	return list, meta.SetList(list, objects)
+}
+
+// list returns copies of the objects of namespace matching selector ordered by name
+func (c *fakeController[T, TList]) list(namespace string, selector labels.Selector) []T {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var items []T
+	for _, obj := range c.objects {
+		if (namespace == "" || obj.GetNamespace() == namespace) && selector.Matches(labels.Set(obj.GetLabels())) {
+			items = append(items, c.copy(obj))
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return objectKey(items[i]) < objectKey(items[j])
+	})
+	return items
+}
+
+// Patch records the patch without applying it
+func (c *fakeController[T, TList]) Patch(namespace, name string, _ types.PatchType, data []byte, _ ...string) (T, error) {
+	obj, err := c.Get(namespace, name, metav1.GetOptions{})
+	if err != nil {
+		return obj, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.patches[namespace+"/"+name] = data
+	return obj, nil
+}
+
+func (c *fakeController[T, TList]) OnChange(_ context.Context, _ string, sync generic.ObjectHandler[T]) {
+	c.onChange = append(c.onChange, sync)
+}
+
+func (c *fakeController[T, TList]) OnRemove(_ context.Context, _ string, sync generic.ObjectHandler[T]) {
+	c.onRemove = append(c.onRemove, sync)
+}
+
+func (c *fakeController[T, TList]) Enqueue(namespace, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.enqueued[namespace+"/"+name] = true
+}
+
+func (c *fakeController[T, TList]) EnqueueAfter(namespace, name string, _ time.Duration) {
+	c.Enqueue(namespace, name)
+}
+
+func (c *fakeController[T, TList]) Cache() generic.CacheInterface[T] {
+	return &fakeCache[T, TList]{controller: c}
+}
+
+// mustCreate stores obj in controller and fails the test on error
+func mustCreate[T generic.RuntimeMetaObject, TList runtime.Object](t *testing.T, controller *fakeController[T, TList], obj T) {
+	t.Helper()
+	if _, err := controller.Create(obj); err != nil {
+		t.Fatalf("creating %s: %v", objectKey(obj), err)
+	}
+}
+
+// sync runs the change handlers on the stored object key, or on nil when it does not exist
+func (c *fakeController[T, TList]) sync(key string) error {
+	namespace, name := kv.Split(key, "/")
+	obj, err := c.Get(namespace, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	for _, handler := range c.onChange {
+		if _, err := handler(key, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove runs the remove handlers on the stored object key, as when it is deleted while it has a finalizer
+func (c *fakeController[T, TList]) remove(key string) error {
+	namespace, name := kv.Split(key, "/")
+	obj, err := c.Get(namespace, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	for _, handler := range c.onRemove {
+		if _, err := handler(key, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeEnqueued reports whether key was enqueued since the last call and forgets it
+func (c *fakeController[T, TList]) takeEnqueued(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	enqueued := c.enqueued[key]
+	delete(c.enqueued, key)
+	return enqueued
+}
+
+// fakeCache serves the objects of its controller
+type fakeCache[T generic.RuntimeMetaObject, TList runtime.Object] struct {
+	controller *fakeController[T, TList]
+}
+
+func (c *fakeCache[T, TList]) Get(namespace, name string) (T, error) {
+	return c.controller.Get(namespace, name, metav1.GetOptions{})
+}
+
+func (c *fakeCache[T, TList]) List(namespace string, selector labels.Selector) ([]T, error) {
+	return c.controller.list(namespace, selector), nil
+}
+
+func (c *fakeCache[T, TList]) AddIndexer(indexName string, indexer generic.Indexer[T]) {
+	c.controller.indexers[indexName] = indexer
+}
+
+func (c *fakeCache[T, TList]) GetByIndex(indexName, key string) ([]T, error) {
+	indexer, ok := c.controller.indexers[indexName]
+	if !ok {
+		return nil, fmt.Errorf("index %s does not exist", indexName)
+	}
+	var matches []T
+	for _, obj := range c.controller.list("", labels.Everything()) {
+		keys, err := indexer(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k == key {
+				matches = append(matches, obj)
+				break
+			}
+		}
+	}
+	return matches, nil
+}
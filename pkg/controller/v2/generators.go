@@ -0,0 +1,345 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultPasswordLength      = 32
+	maxPasswordLength          = 1024
+	defaultSymbolCharacters    = "!#%+-.:=@^_~"
+	defaultRSABits             = 4096
+	defaultCertificateDuration = 365 * 24 * time.Hour
+
+	lowerCharacters = "abcdefghijklmnopqrstuvwxyz"
+	upperCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitCharacters = "0123456789"
+)
+
+// generateSecretData returns the keys generated by generators and the rotation of each generator. Keys stored in
+// managedSecret are reused unless the rotation of their generator has changed since they were generated. Generated
+// keys must not be keys of data, the rendered value would be reused as the generated value on the next sync.
+func generateSecretData(generators []dockhand.Generator, data map[string]string, managedSecret *corev1.Secret) (map[string][]byte, map[string]string, error) {
+	generatedData := make(map[string][]byte)
+	rotations := make(map[string]string, len(generators))
+	if len(generators) == 0 {
+		return generatedData, rotations, nil
+	}
+
+	storedRotations := make(map[string]string)
+	if managedSecret != nil {
+		if val, ok := managedSecret.Annotations[dockhand.GeneratorRotationsAnnotationKey]; ok {
+			// a malformed annotation is treated as no rotations, regenerating every value
+			_ = json.Unmarshal([]byte(val), &storedRotations)
+		}
+	}
+
+	// generatedBy holds the generator of every generated key
+	generatedBy := make(map[string]string)
+	for _, generator := range generators {
+		if _, ok := rotations[generator.Name]; ok {
+			return nil, nil, fmt.Errorf("generator %s is defined more than once", generator.Name)
+		}
+		keys, err := generatorKeys(generator)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, key := range keys {
+			if _, ok := data[key]; ok {
+				return nil, nil, fmt.Errorf("generator %s generates key %s, which is also defined in data", generator.Name, key)
+			}
+			if owner, ok := generatedBy[key]; ok {
+				return nil, nil, fmt.Errorf("generators %s and %s both generate key %s", owner, generator.Name, key)
+			}
+			generatedBy[key] = generator.Name
+		}
+		rotations[generator.Name] = generator.Rotation
+
+		if stored, ok := storedRotations[generator.Name]; ok && stored == generator.Rotation && managedSecret != nil {
+			reused := make(map[string][]byte, len(keys))
+			for _, key := range keys {
+				if value, ok := managedSecret.Data[key]; ok {
+					reused[key] = value
+				}
+			}
+			if len(reused) == len(keys) {
+				for key, value := range reused {
+					generatedData[key] = value
+				}
+				continue
+			}
+		}
+
+		generated, err := generate(generator)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generator %s: %v", generator.Name, err)
+		}
+		for key, value := range generated {
+			generatedData[key] = value
+		}
+	}
+	return generatedData, rotations, nil
+}
+
+// setGeneratorRotations records rotations in the annotations of managedSecret
+func setGeneratorRotations(managedSecret *corev1.Secret, rotations map[string]string) error {
+	if len(rotations) == 0 {
+		delete(managedSecret.Annotations, dockhand.GeneratorRotationsAnnotationKey)
+		return nil
+	}
+	val, err := json.Marshal(rotations)
+	if err != nil {
+		return err
+	}
+	managedSecret.Annotations[dockhand.GeneratorRotationsAnnotationKey] = string(val)
+	return nil
+}
+
+// generatedFunc returns the generated template function, which returns a key generated by a generator
+func generatedFunc(data map[string][]byte) func(string) (string, error) {
+	return func(key string) (string, error) {
+		value, ok := data[key]
+		if !ok {
+			return "", fmt.Errorf("no generator generates %s", key)
+		}
+		return string(value), nil
+	}
+}
+
+// generatorKeys returns the keys of the managed secret generated by generator
+func generatorKeys(generator dockhand.Generator) ([]string, error) {
+	if generator.Name == "" {
+		return nil, fmt.Errorf("generators require a name")
+	}
+	var keys [][]string
+	if generator.Password != nil {
+		keys = append(keys, []string{generator.Name})
+	}
+	if generator.UUID != nil {
+		keys = append(keys, []string{generator.Name})
+	}
+	if generator.SSHKey != nil {
+		keys = append(keys, []string{generator.Name, generator.Name + ".pub"})
+	}
+	if generator.Certificate != nil {
+		keys = append(keys, []string{generator.Name + ".crt", generator.Name + ".key"})
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("generator %s requires exactly one of password, uuid, sshKey or certificate", generator.Name)
+	}
+	return keys[0], nil
+}
+
+func generate(generator dockhand.Generator) (map[string][]byte, error) {
+	switch {
+	case generator.Password != nil:
+		password, err := generatePassword(generator.Password)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{generator.Name: []byte(password)}, nil
+	case generator.UUID != nil:
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{generator.Name: []byte(id.String())}, nil
+	case generator.SSHKey != nil:
+		privateKey, publicKey, err := generateSSHKey(generator.SSHKey)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{generator.Name: privateKey, generator.Name + ".pub": publicKey}, nil
+	default:
+		certificate, privateKey, err := generateCertificate(generator.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{generator.Name + ".crt": certificate, generator.Name + ".key": privateKey}, nil
+	}
+}
+
+// generatePassword returns a password of exactly the number of digits and symbols of policy, the remaining characters
+// are letters.
+func generatePassword(policy *dockhand.PasswordGenerator) (string, error) {
+	length := policy.Length
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+	if length < 0 || length > maxPasswordLength {
+		return "", fmt.Errorf("password length must be between 1 and %d", maxPasswordLength)
+	}
+	if policy.Digits < 0 || policy.Symbols < 0 || policy.Digits+policy.Symbols > length {
+		return "", fmt.Errorf("password digits and symbols exceed length %d", length)
+	}
+	symbols := policy.SymbolCharacters
+	if symbols == "" {
+		symbols = defaultSymbolCharacters
+	}
+	letters := lowerCharacters
+	if !policy.NoUpper {
+		letters += upperCharacters
+	}
+
+	password := make([]byte, 0, length)
+	for _, class := range []struct {
+		characters string
+		count      int
+	}{
+		{digitCharacters, policy.Digits},
+		{symbols, policy.Symbols},
+		{letters, length - policy.Digits - policy.Symbols},
+	} {
+		for i := 0; i < class.count; i++ {
+			c, err := randomInt(len(class.characters))
+			if err != nil {
+				return "", err
+			}
+			password = append(password, class.characters[c])
+		}
+	}
+
+	// shuffle so that the classes are not grouped
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+// generateSSHKey returns an OpenSSH private key and its authorized key
+func generateSSHKey(spec *dockhand.SSHKeyGenerator) ([]byte, []byte, error) {
+	var privateKey interface{}
+	var err error
+	switch strings.ToLower(spec.Type) {
+	case "", "ed25519":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		bits := spec.Bits
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < 2048 {
+			return nil, nil, fmt.Errorf("rsa keys require at least 2048 bits")
+		}
+		privateKey, err = rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch spec.Bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("ecdsa keys require 256, 384 or 521 bits")
+		}
+		privateKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("ssh key type %s is not one of rsa, ecdsa or ed25519", spec.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, spec.Comment)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	authorizedKey := ssh.MarshalAuthorizedKey(signer.PublicKey())
+	if spec.Comment != "" {
+		authorizedKey = append(authorizedKey[:len(authorizedKey)-1], []byte(" "+spec.Comment+"\n")...)
+	}
+	return pem.EncodeToMemory(block), authorizedKey, nil
+}
+
+// generateCertificate returns a self-signed certificate and its private key
+func generateCertificate(spec *dockhand.CertificateGenerator) ([]byte, []byte, error) {
+	if spec.CommonName == "" && len(spec.DNSNames) == 0 {
+		return nil, nil, fmt.Errorf("certificates require a commonName or dnsNames")
+	}
+	duration := spec.Duration.Duration
+	if duration == 0 {
+		duration = defaultCertificateDuration
+	}
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	notBefore := time.Now().Add(-5 * time.Minute)
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: spec.CommonName},
+		DNSNames:              spec.DNSNames,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(duration),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}),
+		nil
+}
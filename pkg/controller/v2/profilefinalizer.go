@@ -0,0 +1,183 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/rancher/wrangler/v3/pkg/kv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxReportedDependents limits the dependents listed in the events and conditions of a Profile
+const maxReportedDependents = 10
+
+// onDockhandProfileRemove blocks the deletion of a Profile while Secrets, PushSecrets or Profiles reference it, unless
+// the Profile has the force-delete annotation. The dependents are reported in the status and events of the Profile,
+// those outside its namespace only by their number.
+func (h *Handler) onDockhandProfileRemove(key string, profile *dockhand.Profile) (*dockhand.Profile, error) {
+	if profile == nil {
+		return nil, nil
+	}
+	log := h.reconcileLog(kindProfile, key)
+
+	dependents, err := h.getProfileDependents(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(dependents) == 0 {
+		log.Infof("dockhand profile removed %s", key)
+		h.enqueueDeletedProfiles(fallbackProfileKeys(profile)...)
+		return nil, nil
+	}
+
+	dependents = reportedDependents(profile.Namespace, dependents)
+	summary := dependentsSummary(dependents)
+	if profile.Annotations[dockhand.ForceDeleteAnnotationKey] == "true" {
+		log.Warnf("force deleting profile %s used by %s", key, summary)
+		h.recorder.Eventf(profile, corev1.EventTypeWarning, "ForceDeleted", "Profile deleted while used by %s", summary)
+		h.enqueueDeletedProfiles(fallbackProfileKeys(profile)...)
+		return nil, nil
+	}
+
+	h.recorder.Eventf(profile, corev1.EventTypeWarning, "ErrProfileInUse", "Profile can not be deleted while used by %s", summary)
+	if err := h.updateProfileStatus(profile, dependents, metav1.Condition{
+		Type:   dockhand.ConditionDeletionBlocked,
+		Status: metav1.ConditionTrue,
+		Reason: dockhand.ReasonInUse,
+		Message: fmt.Sprintf(
+			"Profile is used by %s, remove the references or set the %s annotation to true",
+			summary,
+			dockhand.ForceDeleteAnnotationKey),
+	}); err != nil {
+		log.LogIfError(err)
+	}
+	// the Profile is enqueued again when a dependent is removed, retries catch dependents that stop referencing it
+	return nil, fmt.Errorf("profile %s is used by %s", key, summary)
+}
+
+// getProfileDependents returns the Secrets, PushSecrets and Profiles that reference the Profile key and are not being
+// deleted, ordered by kind, namespace and name
+func (h *Handler) getProfileDependents(key string) ([]dockhand.ProfileDependent, error) {
+	var dependents []dockhand.ProfileDependent
+
+	secrets, err := h.dhSecretsController.Cache().GetByIndex(profilesIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		if secret.DeletionTimestamp == nil {
+			dependents = append(dependents, dockhand.ProfileDependent{Kind: "Secret", Namespace: secret.Namespace, Name: secret.Name})
+		}
+	}
+
+	pushSecrets, err := h.pushSecrets.Cache().GetByIndex(profilesIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, pushSecret := range pushSecrets {
+		if pushSecret.DeletionTimestamp == nil {
+			dependents = append(dependents, dockhand.ProfileDependent{Kind: "PushSecret", Namespace: pushSecret.Namespace, Name: pushSecret.Name})
+		}
+	}
+
+	profiles, err := h.dhSecretsProfileController.Cache().GetByIndex(fallbacksIndex, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles {
+		if profile.DeletionTimestamp == nil {
+			dependents = append(dependents, dockhand.ProfileDependent{Kind: "Profile", Namespace: profile.Namespace, Name: profile.Name})
+		}
+	}
+
+	sort.Slice(dependents, func(i, j int) bool {
+		if dependents[i].Kind != dependents[j].Kind {
+			return dependents[i].Kind < dependents[j].Kind
+		}
+		if dependents[i].Namespace != dependents[j].Namespace {
+			return dependents[i].Namespace < dependents[j].Namespace
+		}
+		return dependents[i].Name < dependents[j].Name
+	})
+	return dependents, nil
+}
+
+// enqueueDeletedProfiles enqueues the Profiles with the namespace/name keys that are being deleted so that their
+// deletion is retried once a dependent is removed
+func (h *Handler) enqueueDeletedProfiles(keys ...string) {
+	for _, key := range keys {
+		namespace, name := kv.Split(key, "/")
+		if profile, err := h.dhSecretsProfileController.Cache().Get(namespace, name); err == nil && profile.DeletionTimestamp != nil {
+			h.dhSecretsProfileController.Enqueue(namespace, name)
+		}
+	}
+}
+
+// reportedDependents keeps the dependents in namespace and replaces the others by their count per kind, so that a
+// Profile shared with --allow-cross-namespace does not disclose the resources of other tenants. dependents must be
+// ordered by kind.
+func reportedDependents(namespace string, dependents []dockhand.ProfileDependent) []dockhand.ProfileDependent {
+	var reported, counted []dockhand.ProfileDependent
+	for _, dependent := range dependents {
+		if dependent.Namespace == namespace {
+			reported = append(reported, dependent)
+			continue
+		}
+		if len(counted) == 0 || counted[len(counted)-1].Kind != dependent.Kind {
+			counted = append(counted, dockhand.ProfileDependent{Kind: dependent.Kind})
+		}
+		counted[len(counted)-1].Count++
+	}
+	return append(reported, counted...)
+}
+
+// dependentsSummary lists the first dependents as Kind namespace/name, or as the number of dependents of a kind in
+// other namespaces
+func dependentsSummary(dependents []dockhand.ProfileDependent) string {
+	names := make([]string, 0, maxReportedDependents)
+	for i, dependent := range dependents {
+		if i == maxReportedDependents {
+			names = append(names, fmt.Sprintf("and %d more", len(dependents)-maxReportedDependents))
+			break
+		}
+		switch {
+		case dependent.Count == 1:
+			names = append(names, fmt.Sprintf("1 %s in another namespace", dependent.Kind))
+		case dependent.Count > 1:
+			names = append(names, fmt.Sprintf("%d %ss in other namespaces", dependent.Count, dependent.Kind))
+		default:
+			names = append(names, fmt.Sprintf("%s %s/%s", dependent.Kind, dependent.Namespace, dependent.Name))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// updateProfileStatus sets the dependents and condition of profile
+func (h *Handler) updateProfileStatus(profile *dockhand.Profile, dependents []dockhand.ProfileDependent, condition metav1.Condition) error {
+	profileCopy := profile.DeepCopy()
+	profileCopy.Status.Dependents = dependents
+	condition.ObservedGeneration = profile.Generation
+	meta.SetStatusCondition(&profileCopy.Status.Conditions, condition)
+	_, err := h.dhSecretsProfileController.UpdateStatus(profileCopy)
+	return err
+}
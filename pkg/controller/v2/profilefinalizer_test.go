@@ -0,0 +1,122 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProfileDeletionHidesOtherTenants(t *testing.T) {
+	c := newTestController(t)
+	mustCreate(t, c.dhSecrets, newSecret(map[string]string{"password": `<< fileText "db" >>`}))
+	for _, namespace := range []string{"tenant-b", "tenant-c"} {
+		secret := newSecret(map[string]string{"password": `<< fileText "db" >>`})
+		secret.Namespace = namespace
+		secret.Spec.Profile.Namespace = testNamespace
+		mustCreate(t, c.dhSecrets, secret)
+	}
+
+	key := testNamespace + "/" + testProfile
+	if err := c.profiles.remove(key); err == nil {
+		t.Fatal("expected the deletion of the profile to be blocked")
+	}
+
+	profile, err := c.profiles.Get(testNamespace, testProfile, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dockhand.ProfileDependent{
+		{Kind: "Secret", Namespace: testNamespace, Name: testSecret},
+		{Kind: "Secret", Count: 2},
+	}
+	if !reflect.DeepEqual(profile.Status.Dependents, want) {
+		t.Errorf("status.dependents = %+v, want %+v", profile.Status.Dependents, want)
+	}
+
+	condition := meta.FindStatusCondition(profile.Status.Conditions, dockhand.ConditionDeletionBlocked)
+	if condition == nil {
+		t.Fatal("DeletionBlocked condition not set")
+	}
+	messages := []string{condition.Message}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events, err := c.kubernetes.CoreV1().Events(testNamespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events.Items) > 0 {
+			for _, event := range events.Items {
+				messages = append(messages, event.Message)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no event recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, message := range messages {
+		if strings.Contains(message, "tenant-") {
+			t.Errorf("message %q names a dependent of another namespace", message)
+		}
+		if !strings.Contains(message, "Secret default/app, 2 Secrets in other namespaces") {
+			t.Errorf("message %q does not report the dependents", message)
+		}
+	}
+}
+
+func TestDependentsSummary(t *testing.T) {
+	tests := []struct {
+		name       string
+		dependents []dockhand.ProfileDependent
+		want       string
+	}{
+		{
+			name: "same namespace",
+			dependents: []dockhand.ProfileDependent{
+				{Kind: "PushSecret", Namespace: "default", Name: "push"},
+				{Kind: "Secret", Namespace: "default", Name: "app"},
+			},
+			want: "PushSecret default/push, Secret default/app",
+		},
+		{
+			name: "other namespaces",
+			dependents: []dockhand.ProfileDependent{
+				{Kind: "Profile", Namespace: "tenant-b", Name: "primary"},
+				{Kind: "Secret", Namespace: "default", Name: "app"},
+				{Kind: "Secret", Namespace: "tenant-b", Name: "app"},
+				{Kind: "Secret", Namespace: "tenant-c", Name: "app"},
+			},
+			want: "Secret default/app, 1 Profile in another namespace, 2 Secrets in other namespaces",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := dependentsSummary(reportedDependents("default", test.dependents)); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
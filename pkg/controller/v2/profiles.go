@@ -0,0 +1,362 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/rancher/wrangler/v3/pkg/kv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultFailoverTimeout bounds each call to a backend of a Profile with failover
+const defaultFailoverTimeout = 10 * time.Second
+
+const (
+	// profileHealthInterval is the interval between the health checks of the backends of a Profile
+	profileHealthInterval = 5 * time.Minute
+	// profileHealthTimeout bounds the health check of each backend of a Profile
+	profileHealthTimeout = 10 * time.Second
+)
+
+// aliasPattern restricts aliases to characters that keep suffixed function names valid template identifiers
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// resolveProfiles returns the Profiles referenced by secret keyed by alias, the profile of the Secret has an empty
+// alias, along with the resolution of each Profile. Every Profile is resolved even when one of them fails so that the
+// status reports all of them.
+func (h *Handler) resolveProfiles(log *common.Logger, secret *dockhand.Secret) (map[string]*dockhand.Profile, []dockhand.ProfileStatus, error) {
+	refs := make([]dockhand.AliasedProfileRef, 0, len(secret.Spec.Profiles)+1)
+	if secret.Spec.Profile.Name != "" {
+		refs = append(refs, dockhand.AliasedProfileRef{Name: secret.Spec.Profile.Name, Namespace: secret.Spec.Profile.Namespace})
+	}
+	refs = append(refs, secret.Spec.Profiles...)
+	if len(refs) == 0 {
+		err := fmt.Errorf("secret %s/%s does not reference a profile", secret.Namespace, secret.Name)
+		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrLoadingProfile", "Secret does not reference a profile")
+		return nil, nil, err
+	}
+
+	profiles := make(map[string]*dockhand.Profile, len(refs))
+	statuses := make([]dockhand.ProfileStatus, 0, len(refs))
+	aliases := make(map[string]bool, len(refs))
+	var resolveErr error
+	for i, ref := range refs {
+		profileNamespace := secret.Namespace
+		if ref.Namespace != "" {
+			profileNamespace = ref.Namespace
+		}
+		status := dockhand.ProfileStatus{Alias: ref.Alias, Name: ref.Name, Namespace: profileNamespace}
+
+		var profile *dockhand.Profile
+		var err error
+		switch {
+		case ref.Alias == "" && (i > 0 || secret.Spec.Profile.Name == ""):
+			err = fmt.Errorf("profiles[%s] requires an alias", ref.Name)
+		case ref.Alias != "" && !aliasPattern.MatchString(ref.Alias):
+			err = fmt.Errorf("profile alias %s may only contain letters, digits and underscores", ref.Alias)
+		case aliases[ref.Alias]:
+			err = fmt.Errorf("profile alias %s is used more than once", ref.Alias)
+		default:
+			profile, err = h.resolveProfile(log, secret, ref.Name, profileNamespace)
+		}
+		aliases[ref.Alias] = true
+
+		if err != nil {
+			status.Message = err.Error()
+			if resolveErr == nil {
+				resolveErr = err
+			}
+		} else {
+			status.Resolved = true
+			profiles[ref.Alias] = profile
+		}
+		statuses = append(statuses, status)
+	}
+	return profiles, statuses, resolveErr
+}
+
+// resolveProfile returns the Profile namespace/name of secret and verifies that its clients can be built
+func (h *Handler) resolveProfile(log *common.Logger, secret *dockhand.Secret, name string, namespace string) (*dockhand.Profile, error) {
+	if secret.Namespace != namespace && !h.crossNamespaceAuthorized {
+		h.recorder.Eventf(
+			secret,
+			corev1.EventTypeWarning,
+			"ErrUnauthorized",
+			"Could not access Profile[%s] in external namespace %s",
+			name,
+			namespace)
+		return nil, fmt.Errorf(
+			"could not access Profile[%s] in external namespace %s, cross namespace profile access is disabled",
+			name,
+			namespace)
+	}
+
+	profile, err := h.dhSecretsProfileController.Get(namespace, name, metav1.GetOptions{})
+	if err != nil {
+		log.Warnf("could not get profile %s/%s", namespace, name)
+		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrLoadingProfile", "Could not get profile %s/%s", namespace, name)
+		return nil, err
+	}
+
+	if _, err := h.getProfileClients(log, profile); err != nil {
+		h.recorder.Eventf(secret, corev1.EventTypeWarning, "ErrLoadingProfile", "Could not load Profile %s/%s: %v", namespace, name, err)
+		return nil, err
+	}
+	return profile, nil
+}
+
+// getRenderClients returns the clients of profile used to render Secrets. The backends of a Profile with failover
+// fall back to the same backend of its fallback Profiles, fallbacks that can not be loaded are skipped.
+func (h *Handler) getRenderClients(log *common.Logger, profile *dockhand.Profile) (map[string]providers.Client, error) {
+	clients, err := h.getProfileClients(log, profile)
+	if err != nil || profile.Spec.Failover == nil {
+		return clients, err
+	}
+
+	failover := profile.Spec.Failover
+	timeout := failover.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultFailoverTimeout
+	}
+	candidates := make(map[string][]providers.FailoverCandidate, len(clients))
+	for backend, client := range clients {
+		candidates[backend] = []providers.FailoverCandidate{{
+			Profile: profile.Namespace + "/" + profile.Name,
+			Client:  client,
+			Timeout: timeout,
+		}}
+	}
+
+	for _, ref := range failover.Fallbacks {
+		fallbackNamespace := profile.Namespace
+		if ref.Namespace != "" {
+			fallbackNamespace = ref.Namespace
+		}
+		fallbackName := fallbackNamespace + "/" + ref.Name
+		if fallbackNamespace != profile.Namespace && !h.crossNamespaceAuthorized {
+			log.Warnf("skipping fallback %s of profile %s/%s, cross namespace profile access is disabled", fallbackName, profile.Namespace, profile.Name)
+			continue
+		}
+		fallback, err := h.dhSecretsProfileController.Get(fallbackNamespace, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("skipping fallback %s of profile %s/%s: %v", fallbackName, profile.Namespace, profile.Name, err)
+			continue
+		}
+		fallbackClients, err := h.getProfileClients(log, fallback)
+		if err != nil {
+			log.Warnf("skipping fallback %s of profile %s/%s: %v", fallbackName, profile.Namespace, profile.Name, err)
+			continue
+		}
+		fallbackTimeout := ref.Timeout.Duration
+		if fallbackTimeout <= 0 {
+			fallbackTimeout = timeout
+		}
+		for backend := range candidates {
+			if client, ok := fallbackClients[backend]; ok {
+				candidates[backend] = append(candidates[backend], providers.FailoverCandidate{
+					Profile: fallbackName,
+					Client:  client,
+					Timeout: fallbackTimeout,
+				})
+			}
+		}
+	}
+
+	failoverClients := make(map[string]providers.Client, len(candidates))
+	for backend, backendCandidates := range candidates {
+		failoverClients[backend] = providers.NewFailoverClient(backend, backendCandidates)
+	}
+	return failoverClients, nil
+}
+
+// secretProfileKeys returns the namespace/name of every Profile referenced by secret
+func secretProfileKeys(secret *dockhand.Secret) []string {
+	refs := append([]dockhand.AliasedProfileRef{{Name: secret.Spec.Profile.Name, Namespace: secret.Spec.Profile.Namespace}}, secret.Spec.Profiles...)
+	keys := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref.Name == "" {
+			continue
+		}
+		namespace := secret.Namespace
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		keys = append(keys, namespace+"/"+ref.Name)
+	}
+	return keys
+}
+
+// fallbackProfileKeys returns the namespace/name of the fallback Profiles of profile
+func fallbackProfileKeys(profile *dockhand.Profile) []string {
+	if profile.Spec.Failover == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(profile.Spec.Failover.Fallbacks))
+	for _, ref := range profile.Spec.Failover.Fallbacks {
+		namespace := profile.Namespace
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		keys = append(keys, namespace+"/"+ref.Name)
+	}
+	return keys
+}
+
+// invalidateProfile deletes the cached clients of the Profile key and enqueues the Dockhand Secrets and PushSecrets
+// that use it, directly or as a fallback
+func (h *Handler) invalidateProfile(log *common.Logger, key string) {
+	h.profileClientsMutex.Lock()
+	delete(h.profileClients, key)
+	delete(h.profileGenerations, key)
+	h.profileClientsMutex.Unlock()
+
+	h.enqueueProfileDependents(log, key)
+	fallbackOf, err := h.dhSecretsProfileController.Cache().GetByIndex(fallbacksIndex, key)
+	if err != nil {
+		log.LogIfError(err)
+		return
+	}
+	for _, profile := range fallbackOf {
+		h.enqueueProfileDependents(log, profile.Namespace+"/"+profile.Name)
+	}
+}
+
+// enqueueProfileDependents enqueues the Dockhand Secrets and PushSecrets that reference the Profile key
+func (h *Handler) enqueueProfileDependents(log *common.Logger, key string) {
+	dependents, err := h.dhSecretsController.Cache().GetByIndex(profilesIndex, key)
+	log.LogIfError(err)
+	for _, dhs := range dependents {
+		log.Infof("profile %s changed - enqueuing dockhand secret %s/%s", key, dhs.Namespace, dhs.Name)
+		h.dhSecretsController.Enqueue(dhs.Namespace, dhs.Name)
+	}
+	pushSecrets, err := h.pushSecrets.Cache().GetByIndex(profilesIndex, key)
+	log.LogIfError(err)
+	for _, pushSecret := range pushSecrets {
+		log.Infof("profile %s changed - enqueuing push secret %s/%s", key, pushSecret.Namespace, pushSecret.Name)
+		h.pushSecrets.Enqueue(pushSecret.Namespace, pushSecret.Name)
+	}
+}
+
+// enqueueCredentialDependents invalidates the Profiles whose credentials are read from the kubernetes Secret key
+func (h *Handler) enqueueCredentialDependents(log *common.Logger, key string) {
+	profiles, err := h.dhSecretsProfileController.Cache().GetByIndex(credentialsIndex, key)
+	if err != nil {
+		log.LogIfError(err)
+		return
+	}
+	for _, profile := range profiles {
+		log.Infof("credentials secret %s changed - invalidating profile %s/%s", key, profile.Namespace, profile.Name)
+		h.invalidateProfile(log, profile.Namespace+"/"+profile.Name)
+		h.dhSecretsProfileController.Enqueue(profile.Namespace, profile.Name)
+	}
+}
+
+// getProfilesChecksum returns the checksum of the current generation of the Profiles referenced by secret, their
+// fallback Profiles and the resourceVersion of the kubernetes Secrets holding their credentials
+func (h *Handler) getProfilesChecksum(secret *dockhand.Secret) string {
+	versions := make(map[string]string)
+	var addProfile func(key string, fallbacks bool)
+	addProfile = func(key string, fallbacks bool) {
+		if _, ok := versions["profile:"+key]; ok {
+			return
+		}
+		versions["profile:"+key] = ""
+		namespace, name := kv.Split(key, "/")
+		profile, err := h.dhSecretsProfileController.Cache().Get(namespace, name)
+		if err != nil {
+			return
+		}
+		// the generation of a Profile changes with its spec, unlike its resourceVersion which also changes with its
+		// status and finalizers
+		versions["profile:"+key] = strconv.FormatInt(profile.Generation, 10)
+		for _, ref := range providers.ProfileSecretRefs(profile) {
+			versions["secret:"+ref] = ""
+			refNamespace, refName := kv.Split(ref, "/")
+			if credentials, err := h.secrets.Cache().Get(refNamespace, refName); err == nil {
+				versions["secret:"+ref] = credentials.ResourceVersion
+			}
+		}
+		if fallbacks {
+			for _, fallback := range fallbackProfileKeys(profile) {
+				addProfile(fallback, false)
+			}
+		}
+	}
+	for _, key := range secretProfileKeys(secret) {
+		addProfile(key, true)
+	}
+	return sourcesChecksum(versions)
+}
+
+// checkProfileHealth reports the health of the backends of profile in its Ready condition and checks them again after
+// profileHealthInterval
+func (h *Handler) checkProfileHealth(log *common.Logger, profile *dockhand.Profile) error {
+	condition := metav1.Condition{
+		Type:    dockhand.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  dockhand.ReasonHealthy,
+		Message: "All backends are healthy",
+	}
+	clients, err := h.getProfileClients(log, profile)
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = dockhand.ReasonInvalidConfig
+		condition.Message = common.RedactError(err).Error()
+	} else {
+		backends := make([]string, 0, len(clients))
+		for backend := range clients {
+			backends = append(backends, backend)
+		}
+		sort.Strings(backends)
+		for _, backend := range backends {
+			ctx, cancel := context.WithTimeout(h.ctx, profileHealthTimeout)
+			err := clients[backend].HealthCheck(ctx)
+			cancel()
+			if err != nil {
+				condition.Status = metav1.ConditionFalse
+				condition.Reason = dockhand.ReasonBackendUnavailable
+				condition.Message = fmt.Sprintf("%s: %v", backend, common.RedactError(err))
+				break
+			}
+		}
+	}
+	if condition.Status == metav1.ConditionFalse {
+		log.Warnf("profile %s/%s is not ready: %s", profile.Namespace, profile.Name, condition.Message)
+	}
+	h.dhSecretsProfileController.EnqueueAfter(profile.Namespace, profile.Name, profileHealthInterval)
+
+	condition.ObservedGeneration = profile.Generation
+	profileCopy := profile.DeepCopy()
+	meta.SetStatusCondition(&profileCopy.Status.Conditions, condition)
+	if equality.Semantic.DeepEqual(profileCopy.Status, profile.Status) {
+		return nil
+	}
+	_, err = h.dhSecretsProfileController.UpdateStatus(profileCopy)
+	return err
+}
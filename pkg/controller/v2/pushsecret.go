@@ -0,0 +1,410 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// pushSecretsIndex indexes PushSecrets by the namespace/name of the kubernetes Secret they push
+const pushSecretsIndex = "dhs.dockhand.dev/pushSecrets"
+
+// pushConflictError is returned when a backend secret was written outside of the PushSecret
+type pushConflictError struct {
+	backend string
+	name    string
+	version string
+}
+
+func (e *pushConflictError) Error() string {
+	return fmt.Sprintf("%s secret %s version %s was not written by the PushSecret", e.backend, e.name, e.version)
+}
+
+// enqueuePushSecrets enqueues the PushSecrets that push the kubernetes Secret key
+func (h *Handler) enqueuePushSecrets(log *common.Logger, key string) {
+	pushSecrets, err := h.pushSecrets.Cache().GetByIndex(pushSecretsIndex, key)
+	if err != nil {
+		log.LogIfError(err)
+		return
+	}
+	for _, pushSecret := range pushSecrets {
+		log.Infof("secret %s changed - enqueuing push secret %s/%s", key, pushSecret.Namespace, pushSecret.Name)
+		h.pushSecrets.Enqueue(pushSecret.Namespace, pushSecret.Name)
+	}
+}
+
+// pushSecretProfileKey returns the namespace/name of the Profile of pushSecret
+func pushSecretProfileKey(pushSecret *dockhand.PushSecret) string {
+	namespace := pushSecret.Namespace
+	if pushSecret.Spec.Profile.Namespace != "" {
+		namespace = pushSecret.Spec.Profile.Namespace
+	}
+	return namespace + "/" + pushSecret.Spec.Profile.Name
+}
+
+// onPushSecretChange writes the keys of the kubernetes Secret of a PushSecret to the backends of its Profile
+func (h *Handler) onPushSecretChange(key string, pushSecret *dockhand.PushSecret) (*dockhand.PushSecret, error) {
+	if pushSecret == nil || pushSecret.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	log := h.reconcileLog(kindPushSecret, key)
+
+	secret, err := h.secrets.Cache().Get(pushSecret.Namespace, pushSecret.Spec.SecretName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the PushSecret is enqueued when the secret is created
+			h.recorder.Eventf(pushSecret, corev1.EventTypeWarning, "ErrSecretNotFound", "Secret %s/%s not found", pushSecret.Namespace, pushSecret.Spec.SecretName)
+			statusErr := h.updatePushSecretStatus(log, pushSecret, nil, pushSecret.Status.Pushed, dockhand.Pending, err)
+			log.LogIfError(statusErr)
+			return nil, nil
+		}
+		return nil, err
+	}
+	// the pushed values are redacted from logs, events and errors
+	common.SetSecretValues(pushSecretValuesOwner(pushSecret), secret.Data)
+
+	if pushSecret.Generation == pushSecret.Status.ObservedGeneration &&
+		secret.ResourceVersion == pushSecret.Status.ObservedSecretResourceVersion &&
+		pushSecret.Status.State == dockhand.Ready {
+		log.Debugf("skipping push %s/%s", pushSecret.Namespace, pushSecret.Name)
+		return nil, nil
+	}
+
+	clients, err := h.getPushSecretClients(log, pushSecret)
+	if err != nil {
+		h.recorder.Eventf(pushSecret, corev1.EventTypeWarning, "ErrLoadingProfile", "Could not load Profile: %v", err)
+		statusErr := h.updatePushSecretStatus(log, pushSecret, nil, pushSecret.Status.Pushed, dockhand.ErrApplied, err)
+		log.LogIfError(statusErr)
+		return nil, err
+	}
+
+	pushed, pushErr := h.pushSecretData(log, pushSecret, secret, clients)
+
+	state := dockhand.Ready
+	if pushErr != nil {
+		state = dockhand.ErrApplied
+		h.recorder.Eventf(pushSecret, corev1.EventTypeWarning, "ErrPushingSecret", "Could not push secret %v", common.RedactError(pushErr))
+	}
+	if err := h.updatePushSecretStatus(log, pushSecret, secret, pushed, state, pushErr); err != nil {
+		log.LogIfError(err)
+	}
+
+	// conflicts are not retried, they are resolved by changing the backend secret or the conflictPolicy
+	if _, conflict := pushErr.(*pushConflictError); conflict {
+		return nil, nil
+	}
+	return nil, common.RedactError(pushErr)
+}
+
+// pushSecretValuesOwner returns the owner of the values pushed by pushSecret in the redacted secret values
+func pushSecretValuesOwner(pushSecret *dockhand.PushSecret) string {
+	return "PushSecret/" + pushSecret.Namespace + "/" + pushSecret.Name
+}
+
+// onPushSecretRemove deletes the backend secrets written by a PushSecret with the Delete deletionPolicy
+func (h *Handler) onPushSecretRemove(key string, pushSecret *dockhand.PushSecret) (*dockhand.PushSecret, error) {
+	if pushSecret == nil {
+		return nil, nil
+	}
+	log := h.reconcileLog(kindPushSecret, key)
+	defer h.enqueueDeletedProfiles(pushSecretProfileKey(pushSecret))
+	common.ForgetSecretValues(pushSecretValuesOwner(pushSecret))
+	if pushSecret.Spec.DeletionPolicy != dockhand.DeletionPolicyDelete || len(pushSecret.Status.Pushed) == 0 {
+		return nil, nil
+	}
+	log.Infof("push secret removed %s/%s", pushSecret.Namespace, pushSecret.Name)
+
+	clients, err := h.getPushSecretClients(log, pushSecret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Warnf("profile of push secret %s/%s not found, backend secrets are retained", pushSecret.Namespace, pushSecret.Name)
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, pushed := range pushSecret.Status.Pushed {
+		if err := h.deletePushedSecret(log, pushSecret, pushed, clients); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// pushSecretData writes every entry of the PushSecret whose value or backend version changed and deletes the backend
+// secrets of removed entries. The backend secrets written are returned even when an entry fails.
+func (h *Handler) pushSecretData(
+	log *common.Logger,
+	pushSecret *dockhand.PushSecret,
+	secret *corev1.Secret,
+	clients map[string]providers.Client) ([]dockhand.PushedSecret, error) {
+
+	previous := make(map[string]dockhand.PushedSecret, len(pushSecret.Status.Pushed))
+	for _, pushed := range pushSecret.Status.Pushed {
+		previous[pushed.Backend+"/"+pushed.Name] = pushed
+	}
+
+	var pushedSecrets []dockhand.PushedSecret
+	var pushErr error
+	desired := make(map[string]bool, len(pushSecret.Spec.Data))
+	for _, data := range pushSecret.Spec.Data {
+		backend, name, err := h.pushRemoteRef(&data.RemoteRef)
+		if err != nil {
+			return pushSecret.Status.Pushed, err
+		}
+		key := backend + "/" + name
+		desired[key] = true
+		recorded, recordedOk := previous[key]
+
+		pushed, err := h.pushSecretKey(log, pushSecret, secret, data.SecretKey, backend, name, clients, recorded, recordedOk)
+		if err != nil {
+			if pushErr == nil {
+				pushErr = err
+			}
+			if !recordedOk {
+				continue
+			}
+			pushed = recorded
+		}
+		pushedSecrets = append(pushedSecrets, pushed)
+	}
+
+	for key, recorded := range previous {
+		if desired[key] {
+			continue
+		}
+		if pushSecret.Spec.DeletionPolicy == dockhand.DeletionPolicyDelete {
+			if err := h.deletePushedSecret(log, pushSecret, recorded, clients); err != nil {
+				if pushErr == nil {
+					pushErr = err
+				}
+				pushedSecrets = append(pushedSecrets, recorded)
+			}
+		}
+	}
+	return pushedSecrets, pushErr
+}
+
+// pushSecretKey writes secretKey of secret, or all keys when it is empty, to the backend secret name
+func (h *Handler) pushSecretKey(
+	log *common.Logger,
+	pushSecret *dockhand.PushSecret,
+	secret *corev1.Secret,
+	secretKey string,
+	backend string,
+	name string,
+	clients map[string]providers.Client,
+	recorded dockhand.PushedSecret,
+	recordedOk bool) (dockhand.PushedSecret, error) {
+
+	value, err := pushSecretValue(secret, secretKey)
+	if err != nil {
+		return dockhand.PushedSecret{}, err
+	}
+	writer, err := pushWriter(clients, backend)
+	if err != nil {
+		return dockhand.PushedSecret{}, err
+	}
+	sum := sha256.Sum256([]byte(value))
+	checksum := hex.EncodeToString(sum[:])
+
+	current, err := writer.SecretVersion(h.ctx, name)
+	if err != nil {
+		return dockhand.PushedSecret{}, err
+	}
+	if recordedOk && current != "" && current == recorded.Version && checksum == recorded.Checksum {
+		return recorded, nil
+	}
+	conflict := current != "" && (!recordedOk || current != recorded.Version)
+	if conflict && pushSecret.Spec.ConflictPolicy != dockhand.ConflictPolicyOverride {
+		return dockhand.PushedSecret{}, &pushConflictError{backend: backend, name: name, version: current}
+	}
+
+	log.Infof("pushing %s/%s to %s secret %s", secret.Namespace, secret.Name, backend, name)
+	version, err := writer.PutSecret(h.ctx, name, value)
+	if err != nil {
+		return dockhand.PushedSecret{}, err
+	}
+	h.recorder.Eventf(pushSecret, corev1.EventTypeNormal, "Success", "Pushed %s secret %s version %s", backend, name, version)
+	return dockhand.PushedSecret{Backend: backend, Name: name, Version: version, Checksum: checksum}, nil
+}
+
+// deletePushedSecret deletes a backend secret written by the PushSecret, unless it was modified since
+func (h *Handler) deletePushedSecret(log *common.Logger, pushSecret *dockhand.PushSecret, pushed dockhand.PushedSecret, clients map[string]providers.Client) error {
+	writer, err := pushWriter(clients, pushed.Backend)
+	if err != nil {
+		return err
+	}
+	current, err := writer.SecretVersion(h.ctx, pushed.Name)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return nil
+	}
+	if current != pushed.Version {
+		log.Warnf("%s secret %s was modified after it was pushed by %s/%s, it is retained", pushed.Backend, pushed.Name, pushSecret.Namespace, pushSecret.Name)
+		h.recorder.Eventf(pushSecret, corev1.EventTypeWarning, "Warn", "%s secret %s was modified after it was pushed, it is retained", pushed.Backend, pushed.Name)
+		return nil
+	}
+	log.Infof("deleting %s secret %s pushed by %s/%s", pushed.Backend, pushed.Name, pushSecret.Namespace, pushSecret.Name)
+	return writer.DeleteSecret(h.ctx, pushed.Name)
+}
+
+// getPushSecretClients returns the clients of the Profile of a PushSecret
+func (h *Handler) getPushSecretClients(log *common.Logger, pushSecret *dockhand.PushSecret) (map[string]providers.Client, error) {
+	profileNamespace := pushSecret.Namespace
+	if pushSecret.Spec.Profile.Namespace != "" {
+		profileNamespace = pushSecret.Spec.Profile.Namespace
+	}
+	if pushSecret.Namespace != profileNamespace && !h.crossNamespaceAuthorized {
+		return nil, fmt.Errorf(
+			"could not access Profile[%s] in external namespace %s, cross namespace profile access is disabled",
+			pushSecret.Spec.Profile.Name,
+			profileNamespace)
+	}
+	profile, err := h.dhSecretsProfileController.Get(profileNamespace, pushSecret.Spec.Profile.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return h.getProfileClients(log, profile)
+}
+
+// pushRemoteRef returns the provider and name of the backend secret referenced by ref
+func (h *Handler) pushRemoteRef(ref *dockhand.DataFromSource) (string, string, error) {
+	for _, provider := range h.providers.All() {
+		name, ok := provider.DataFromName(ref)
+		if !ok {
+			continue
+		}
+		if name, version := providers.SplitVersion(name); version != "" {
+			return "", "", fmt.Errorf("remoteRef %s of %s can not reference a version", name, provider.Name())
+		}
+		return provider.Name(), name, nil
+	}
+	return "", "", fmt.Errorf("remoteRef does not specify a secrets backend")
+}
+
+// pushWriter returns the client of backend when it supports writing secrets
+func pushWriter(clients map[string]providers.Client, backend string) (providers.Writer, error) {
+	client, ok := clients[backend]
+	if !ok {
+		return nil, fmt.Errorf("profile does not define %s", backend)
+	}
+	writer, ok := client.(providers.Writer)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support pushing secrets", backend)
+	}
+	return writer, nil
+}
+
+// pushSecretValue returns secretKey of secret, or every key as a json object when secretKey is empty
+func pushSecretValue(secret *corev1.Secret, secretKey string) (string, error) {
+	if secretKey != "" {
+		value, ok := secret.Data[secretKey]
+		if !ok {
+			return "", fmt.Errorf("secret %s/%s does not contain %s", secret.Namespace, secret.Name, secretKey)
+		}
+		return string(value), nil
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	value, err := json.Marshal(data)
+	return string(value), err
+}
+
+// updatePushSecretStatus sets the state, pushed secrets and the Ready and Synced conditions of the PushSecret
+func (h *Handler) updatePushSecretStatus(
+	log *common.Logger,
+	pushSecret *dockhand.PushSecret,
+	secret *corev1.Secret,
+	pushed []dockhand.PushedSecret,
+	state dockhand.SecretState,
+	pushErr error) error {
+
+	log.Debugf("updating push secret %s status", pushSecret.Name)
+	pushSecretCopy := pushSecret.DeepCopy()
+	pushSecretCopy.Status.State = state
+	pushSecretCopy.Status.Pushed = pushed
+
+	readyCondition := metav1.Condition{
+		Type:    dockhand.ConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  string(state),
+		Message: fmt.Sprintf("Secret %s has not been pushed", pushSecret.Spec.SecretName),
+	}
+	syncedCondition := metav1.Condition{
+		Type:    dockhand.ConditionSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  dockhand.ReasonPushed,
+		Message: "Backend secrets are up to date",
+	}
+	if state == dockhand.Ready {
+		readyCondition.Status = metav1.ConditionTrue
+		readyCondition.Message = fmt.Sprintf("Secret %s is pushed", pushSecret.Spec.SecretName)
+	}
+	if pushErr != nil {
+		syncedCondition.Status = metav1.ConditionFalse
+		syncedCondition.Reason = string(state)
+		syncedCondition.Message = common.Redact(pushErr.Error())
+		if _, conflict := pushErr.(*pushConflictError); conflict {
+			syncedCondition.Reason = dockhand.ReasonConflict
+		}
+	}
+	for _, condition := range []metav1.Condition{readyCondition, syncedCondition} {
+		condition.ObservedGeneration = pushSecret.Generation
+		meta.SetStatusCondition(&pushSecretCopy.Status.Conditions, condition)
+	}
+
+	if state == dockhand.Ready {
+		pushSecretCopy.Status.ObservedGeneration = pushSecret.Generation
+		pushSecretCopy.Status.SyncTimestamp = &metav1.Time{Time: time.Now()}
+		if secret != nil {
+			pushSecretCopy.Status.ObservedSecretResourceVersion = secret.ResourceVersion
+		}
+	}
+
+	// the backend secrets are already written, the status is retried on the current PushSecret so that the versions
+	// written are not reported as conflicts by the next push
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_, err := h.pushSecrets.UpdateStatus(pushSecretCopy)
+		if !errors.IsConflict(err) {
+			return err
+		}
+		current, getErr := h.pushSecrets.Get(pushSecret.Namespace, pushSecret.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		current.Status = pushSecretCopy.Status
+		pushSecretCopy = current
+		return err
+	})
+}
@@ -0,0 +1,282 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testPushProfile = "memory"
+	testPushSecret  = "push"
+)
+
+// newPushTestController returns a testController with a Profile of the memory provider and the kubernetes Secret
+// pushed by the PushSecrets of the tests
+func newPushTestController(t *testing.T) *testController {
+	t.Helper()
+	c := newTestController(t)
+	mustCreate(t, c.profiles, &dockhand.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: testPushProfile, Namespace: testNamespace},
+		Spec:       dockhand.ProfileSpec{Vault: &dockhand.Vault{}},
+	})
+	mustCreate(t, c.secrets, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testManagedSecret, Namespace: testNamespace},
+		Data:       map[string][]byte{"password": []byte("s3cr3t"), "token": []byte("t0k3n")},
+	})
+	return c
+}
+
+// newPushSecret returns a PushSecret of the memory Profile writing each key of the kubernetes Secret to the backend
+// secret of the same name
+func newPushSecret(keys ...string) *dockhand.PushSecret {
+	pushSecret := &dockhand.PushSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: testPushSecret, Namespace: testNamespace},
+		Spec: dockhand.PushSecretSpec{
+			Profile:    dockhand.ProfileRef{Name: testPushProfile},
+			SecretName: testManagedSecret,
+		},
+	}
+	for _, key := range keys {
+		pushSecret.Spec.Data = append(pushSecret.Spec.Data, dockhand.PushSecretData{
+			SecretKey: key,
+			RemoteRef: dockhand.DataFromSource{Vault: &dockhand.VaultSecretSource{Path: key}},
+		})
+	}
+	return pushSecret
+}
+
+func (c *testController) syncPushSecret() error {
+	return c.pushSecrets.sync(testNamespace + "/" + testPushSecret)
+}
+
+func (c *testController) pushSecret(t *testing.T) *dockhand.PushSecret {
+	t.Helper()
+	pushSecret, err := c.pushSecrets.Get(testNamespace, testPushSecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting push secret: %v", err)
+	}
+	return pushSecret
+}
+
+// assertPushed fails the test unless the status of the PushSecret records exactly versions of the backend secrets
+func assertPushed(t *testing.T, pushSecret *dockhand.PushSecret, versions map[string]string) {
+	t.Helper()
+	got := make(map[string]string, len(pushSecret.Status.Pushed))
+	for _, pushed := range pushSecret.Status.Pushed {
+		got[pushed.Name] = pushed.Version
+	}
+	if !reflect.DeepEqual(got, versions) {
+		t.Errorf("pushed versions = %v, want %v", got, versions)
+	}
+}
+
+func checksum(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPushSecretPushesKeys(t *testing.T) {
+	c := newPushTestController(t)
+	mustCreate(t, c.pushSecrets, newPushSecret("password", "token"))
+	if err := c.syncPushSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	for name, want := range map[string]string{"password": "s3cr3t", "token": "t0k3n"} {
+		if value, err := c.backend.GetSecret(context.Background(), name, ""); err != nil || value != want {
+			t.Errorf("backend secret %s = %q, %v, want %q", name, value, err, want)
+		}
+	}
+	pushSecret := c.pushSecret(t)
+	if pushSecret.Status.State != dockhand.Ready {
+		t.Errorf("state = %s, want %s", pushSecret.Status.State, dockhand.Ready)
+	}
+	want := []dockhand.PushedSecret{
+		{Backend: "memory", Name: "password", Version: "1", Checksum: checksum("s3cr3t")},
+		{Backend: "memory", Name: "token", Version: "1", Checksum: checksum("t0k3n")},
+	}
+	if !reflect.DeepEqual(pushSecret.Status.Pushed, want) {
+		t.Errorf("pushed = %+v, want %+v", pushSecret.Status.Pushed, want)
+	}
+
+	// a changed value is pushed as a new version
+	secret, _ := c.secrets.Get(testNamespace, testManagedSecret, metav1.GetOptions{})
+	secret.Data["password"] = []byte("rotated")
+	if _, err := c.secrets.Update(secret); err != nil {
+		t.Fatalf("updating secret: %v", err)
+	}
+	if err := c.syncPushSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	assertPushed(t, c.pushSecret(t), map[string]string{"password": "2", "token": "1"})
+}
+
+func TestPushSecretConflicts(t *testing.T) {
+	tests := []struct {
+		name           string
+		conflictPolicy string
+		// modified writes the backend secret after the first push instead of before it
+		modified     bool
+		wantConflict bool
+		wantValue    string
+	}{
+		{name: "existing secret", wantConflict: true, wantValue: "external"},
+		{name: "existing secret overridden", conflictPolicy: dockhand.ConflictPolicyOverride, wantValue: "rotated"},
+		{name: "secret modified after push", modified: true, wantConflict: true, wantValue: "external"},
+		{name: "secret modified after push overridden", modified: true, conflictPolicy: dockhand.ConflictPolicyOverride, wantValue: "rotated"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newPushTestController(t)
+			pushSecret := newPushSecret("password")
+			pushSecret.Spec.ConflictPolicy = test.conflictPolicy
+			mustCreate(t, c.pushSecrets, pushSecret)
+			if test.modified {
+				if err := c.syncPushSecret(); err != nil {
+					t.Fatalf("sync: %v", err)
+				}
+			}
+			c.backend.write("password", "external")
+
+			secret, _ := c.secrets.Get(testNamespace, testManagedSecret, metav1.GetOptions{})
+			secret.Data["password"] = []byte("rotated")
+			if _, err := c.secrets.Update(secret); err != nil {
+				t.Fatalf("updating secret: %v", err)
+			}
+			// conflicts are reported in the status and not retried
+			if err := c.syncPushSecret(); err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+
+			if value, _ := c.backend.GetSecret(context.Background(), "password", ""); value != test.wantValue {
+				t.Errorf("backend secret = %q, want %q", value, test.wantValue)
+			}
+			pushSecret = c.pushSecret(t)
+			synced := meta.FindStatusCondition(pushSecret.Status.Conditions, dockhand.ConditionSynced)
+			if synced == nil {
+				t.Fatal("Synced condition is missing")
+			}
+			if conflict := synced.Reason == dockhand.ReasonConflict; conflict != test.wantConflict {
+				t.Errorf("Synced condition = %s %s, want conflict %t", synced.Status, synced.Reason, test.wantConflict)
+			}
+			if test.wantConflict && pushSecret.Status.State != dockhand.ErrApplied {
+				t.Errorf("state = %s, want %s", pushSecret.Status.State, dockhand.ErrApplied)
+			}
+		})
+	}
+}
+
+func TestPushSecretDeletesRemovedEntries(t *testing.T) {
+	tests := []struct {
+		name           string
+		deletionPolicy string
+		modified       bool
+		wantDeleted    bool
+	}{
+		{name: "retain", deletionPolicy: dockhand.DeletionPolicyRetain},
+		{name: "delete", deletionPolicy: dockhand.DeletionPolicyDelete, wantDeleted: true},
+		{name: "delete modified after push", deletionPolicy: dockhand.DeletionPolicyDelete, modified: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newPushTestController(t)
+			pushSecret := newPushSecret("password", "token")
+			pushSecret.Spec.DeletionPolicy = test.deletionPolicy
+			mustCreate(t, c.pushSecrets, pushSecret)
+			if err := c.syncPushSecret(); err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			if test.modified {
+				c.backend.write("token", "external")
+			}
+
+			pushSecret = c.pushSecret(t)
+			pushSecret.Spec.Data = pushSecret.Spec.Data[:1]
+			if _, err := c.pushSecrets.Update(pushSecret); err != nil {
+				t.Fatalf("updating push secret: %v", err)
+			}
+			if err := c.syncPushSecret(); err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+
+			_, err := c.backend.GetSecret(context.Background(), "token", "")
+			if deleted := err != nil; deleted != test.wantDeleted {
+				t.Errorf("backend secret deleted = %t, want %t", deleted, test.wantDeleted)
+			}
+			assertPushed(t, c.pushSecret(t), map[string]string{"password": "1"})
+		})
+	}
+}
+
+func TestPushSecretRemoveDeletesPushedSecrets(t *testing.T) {
+	c := newPushTestController(t)
+	pushSecret := newPushSecret("password", "token")
+	pushSecret.Spec.DeletionPolicy = dockhand.DeletionPolicyDelete
+	mustCreate(t, c.pushSecrets, pushSecret)
+	if err := c.syncPushSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	c.backend.write("token", "external")
+
+	if err := c.pushSecrets.remove(testNamespace + "/" + testPushSecret); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := c.backend.GetSecret(context.Background(), "password", ""); err == nil {
+		t.Error("backend secret password was not deleted")
+	}
+	if value, _ := c.backend.GetSecret(context.Background(), "token", ""); value != "external" {
+		t.Errorf("backend secret token = %q, want the modified secret retained", value)
+	}
+}
+
+func TestPushSecretKeepsVersionsWhenStatusConflicts(t *testing.T) {
+	c := newPushTestController(t)
+	mustCreate(t, c.pushSecrets, newPushSecret("password"))
+	// the PushSecret changes while the secret is pushed, the status update of the push conflicts
+	c.backend.onPut = func(string) {
+		pushSecret := c.pushSecret(t)
+		pushSecret.Annotations = map[string]string{"example.com/changed": "true"}
+		if _, err := c.pushSecrets.Update(pushSecret); err != nil {
+			t.Errorf("updating push secret: %v", err)
+		}
+	}
+	if err := c.syncPushSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	c.backend.onPut = nil
+	assertPushed(t, c.pushSecret(t), map[string]string{"password": "1"})
+
+	// the version written is not a conflict of the next push
+	if err := c.syncPushSecret(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	pushSecret := c.pushSecret(t)
+	if pushSecret.Status.State != dockhand.Ready {
+		t.Errorf("state = %s, want %s", pushSecret.Status.State, dockhand.Ready)
+	}
+	assertPushed(t, pushSecret, map[string]string{"password": "1"})
+}
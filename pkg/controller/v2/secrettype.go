@@ -0,0 +1,184 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// keys used to build a kubernetes.io/dockerconfigjson secret when .dockerconfigjson is not templated directly
+const (
+	dockerConfigRegistryKey = "registry"
+	dockerConfigUsernameKey = "username"
+	dockerConfigPasswordKey = "password"
+	dockerConfigEmailKey    = "email"
+)
+
+// secretDataError is returned when rendered data is not valid for the type of the managed secret. The reason is
+// reported in the DataValid condition of the Dockhand Secret.
+type secretDataError struct {
+	reason  string
+	message string
+}
+
+func (e *secretDataError) Error() string {
+	return e.message
+}
+
+func newSecretDataError(reason string, format string, a ...interface{}) *secretDataError {
+	return &secretDataError{reason: reason, message: fmt.Sprintf(format, a...)}
+}
+
+// renderSecretType validates data against secretType, building any keys derived from other keys such as
+// .dockerconfigjson. Opaque and unknown types are not validated.
+func renderSecretType(secretType corev1.SecretType, data map[string][]byte) *secretDataError {
+	switch secretType {
+	case corev1.SecretTypeTLS:
+		return validateTLS(data)
+	case corev1.SecretTypeDockerConfigJson:
+		return renderDockerConfigJson(data)
+	case corev1.SecretTypeBasicAuth:
+		if _, ok := data[corev1.BasicAuthUsernameKey]; ok {
+			return nil
+		}
+		if _, ok := data[corev1.BasicAuthPasswordKey]; ok {
+			return nil
+		}
+		return newSecretDataError(
+			dockhand.ReasonMissingKey,
+			"%s requires %s or %s",
+			secretType,
+			corev1.BasicAuthUsernameKey,
+			corev1.BasicAuthPasswordKey)
+	case corev1.SecretTypeSSHAuth:
+		if _, ok := data[corev1.SSHAuthPrivateKey]; !ok {
+			return newSecretDataError(dockhand.ReasonMissingKey, "%s requires %s", secretType, corev1.SSHAuthPrivateKey)
+		}
+	}
+	return nil
+}
+
+// validateTLS checks that the certificate and key are present, match and that the certificate is currently valid.
+func validateTLS(data map[string][]byte) *secretDataError {
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if _, ok := data[key]; !ok {
+			return newSecretDataError(dockhand.ReasonMissingKey, "%s requires %s", corev1.SecretTypeTLS, key)
+		}
+	}
+
+	block, _ := pem.Decode(data[corev1.TLSCertKey])
+	if block == nil || block.Type != "CERTIFICATE" {
+		return newSecretDataError(dockhand.ReasonInvalidCertificate, "%s does not contain a PEM encoded certificate", corev1.TLSCertKey)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return newSecretDataError(dockhand.ReasonInvalidCertificate, "could not parse %s: %v", corev1.TLSCertKey, err)
+	}
+
+	if _, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey]); err != nil {
+		return newSecretDataError(dockhand.ReasonKeyMismatch, "%s does not match %s: %v", corev1.TLSPrivateKeyKey, corev1.TLSCertKey, err)
+	}
+
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return newSecretDataError(dockhand.ReasonCertificateExpired, "certificate %s expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return newSecretDataError(dockhand.ReasonInvalidCertificate, "certificate %s is not valid before %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// renderDockerConfigJson validates a templated .dockerconfigjson or builds it from the registry, username, password
+// and optional email keys, which are removed from the secret.
+func renderDockerConfigJson(data map[string][]byte) *secretDataError {
+	if dockerConfig, ok := data[corev1.DockerConfigJsonKey]; ok {
+		config := struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}{}
+		if err := json.Unmarshal(dockerConfig, &config); err != nil || config.Auths == nil {
+			return newSecretDataError(dockhand.ReasonInvalidDockerConfig, "%s must be a json object containing auths", corev1.DockerConfigJsonKey)
+		}
+		return nil
+	}
+
+	for _, key := range []string{dockerConfigRegistryKey, dockerConfigUsernameKey, dockerConfigPasswordKey} {
+		if _, ok := data[key]; !ok {
+			return newSecretDataError(
+				dockhand.ReasonMissingKey,
+				"%s requires %s or %s, %s and %s",
+				corev1.SecretTypeDockerConfigJson,
+				corev1.DockerConfigJsonKey,
+				dockerConfigRegistryKey,
+				dockerConfigUsernameKey,
+				dockerConfigPasswordKey)
+		}
+	}
+
+	username := string(data[dockerConfigUsernameKey])
+	password := string(data[dockerConfigPasswordKey])
+	auth := map[string]string{
+		"username": username,
+		"password": password,
+		"auth":     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	if email, ok := data[dockerConfigEmailKey]; ok {
+		auth["email"] = string(email)
+	}
+	dockerConfig, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			string(data[dockerConfigRegistryKey]): auth,
+		},
+	})
+	if err != nil {
+		return newSecretDataError(dockhand.ReasonInvalidDockerConfig, "could not build %s: %v", corev1.DockerConfigJsonKey, err)
+	}
+
+	for _, key := range []string{dockerConfigRegistryKey, dockerConfigUsernameKey, dockerConfigPasswordKey, dockerConfigEmailKey} {
+		delete(data, key)
+	}
+	data[corev1.DockerConfigJsonKey] = dockerConfig
+	return nil
+}
+
+// dataValidCondition returns the DataValid condition for the result of renderSecretType.
+func dataValidCondition(err *secretDataError) metav1.Condition {
+	if err != nil {
+		return metav1.Condition{
+			Type:    dockhand.ConditionDataValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  err.reason,
+			Message: err.message,
+		}
+	}
+	return metav1.Condition{
+		Type:    dockhand.ConditionDataValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  dockhand.ReasonValid,
+		Message: "Secret data is valid for the secret type",
+	}
+}
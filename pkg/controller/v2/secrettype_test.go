@@ -0,0 +1,175 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newCertificate returns a self signed certificate valid from notBefore to notAfter and its key in pem
+func newCertificate(t *testing.T, notBefore, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestRenderSecretType(t *testing.T) {
+	now := time.Now()
+	cert, key := newCertificate(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCert, expiredKey := newCertificate(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	futureCert, futureKey := newCertificate(t, now.Add(time.Hour), now.Add(2*time.Hour))
+	_, otherKey := newCertificate(t, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name       string
+		secretType corev1.SecretType
+		data       map[string]string
+		wantReason string
+	}{
+		{name: "opaque", secretType: corev1.SecretTypeOpaque, data: map[string]string{"anything": "value"}},
+		{name: "tls", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": string(cert), "tls.key": string(key)}},
+		{name: "tls missing key", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": string(cert)}, wantReason: dockhand.ReasonMissingKey},
+		{name: "tls not pem", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": "certificate", "tls.key": string(key)}, wantReason: dockhand.ReasonInvalidCertificate},
+		{name: "tls key mismatch", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": string(cert), "tls.key": string(otherKey)}, wantReason: dockhand.ReasonKeyMismatch},
+		{name: "tls expired", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": string(expiredCert), "tls.key": string(expiredKey)}, wantReason: dockhand.ReasonCertificateExpired},
+		{name: "tls not yet valid", secretType: corev1.SecretTypeTLS, data: map[string]string{"tls.crt": string(futureCert), "tls.key": string(futureKey)}, wantReason: dockhand.ReasonInvalidCertificate},
+		{name: "dockerconfigjson", secretType: corev1.SecretTypeDockerConfigJson, data: map[string]string{".dockerconfigjson": `{"auths": {}}`}},
+		{name: "dockerconfigjson without auths", secretType: corev1.SecretTypeDockerConfigJson, data: map[string]string{".dockerconfigjson": `{}`}, wantReason: dockhand.ReasonInvalidDockerConfig},
+		{name: "dockerconfigjson not json", secretType: corev1.SecretTypeDockerConfigJson, data: map[string]string{".dockerconfigjson": `auths`}, wantReason: dockhand.ReasonInvalidDockerConfig},
+		{name: "dockerconfigjson missing password", secretType: corev1.SecretTypeDockerConfigJson, data: map[string]string{"registry": "registry.example.com", "username": "app"}, wantReason: dockhand.ReasonMissingKey},
+		{name: "basic auth username", secretType: corev1.SecretTypeBasicAuth, data: map[string]string{"username": "app"}},
+		{name: "basic auth password", secretType: corev1.SecretTypeBasicAuth, data: map[string]string{"password": "s3cr3t"}},
+		{name: "basic auth missing keys", secretType: corev1.SecretTypeBasicAuth, data: map[string]string{"token": "s3cr3t"}, wantReason: dockhand.ReasonMissingKey},
+		{name: "ssh auth", secretType: corev1.SecretTypeSSHAuth, data: map[string]string{"ssh-privatekey": "key"}},
+		{name: "ssh auth missing key", secretType: corev1.SecretTypeSSHAuth, data: map[string]string{"ssh-publickey": "key"}, wantReason: dockhand.ReasonMissingKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := make(map[string][]byte, len(test.data))
+			for k, v := range test.data {
+				data[k] = []byte(v)
+			}
+			err := renderSecretType(test.secretType, data)
+			condition := dataValidCondition(err)
+			if test.wantReason == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if condition.Status != metav1.ConditionTrue || condition.Reason != dockhand.ReasonValid {
+					t.Errorf("condition = %+v, want valid", condition)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected reason %s", test.wantReason)
+			}
+			if condition.Status != metav1.ConditionFalse || condition.Reason != test.wantReason {
+				t.Errorf("condition = %s %s, want False %s", condition.Status, condition.Reason, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestRenderDockerConfigJsonFromKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+	}{
+		{name: "without email"},
+		{name: "with email", email: "app@example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := map[string][]byte{
+				"registry": []byte("registry.example.com"),
+				"username": []byte("app"),
+				"password": []byte("s3cr3t"),
+				"other":    []byte("kept"),
+			}
+			if test.email != "" {
+				data["email"] = []byte(test.email)
+			}
+			if err := renderSecretType(corev1.SecretTypeDockerConfigJson, data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, key := range []string{"registry", "username", "password", "email"} {
+				if _, ok := data[key]; ok {
+					t.Errorf("key %s was not removed", key)
+				}
+			}
+			if string(data["other"]) != "kept" {
+				t.Errorf("other = %q, want kept", data["other"])
+			}
+			var config struct {
+				Auths map[string]map[string]string `json:"auths"`
+			}
+			if err := json.Unmarshal(data[corev1.DockerConfigJsonKey], &config); err != nil {
+				t.Fatalf("%s is not json: %v", corev1.DockerConfigJsonKey, err)
+			}
+			auth := config.Auths["registry.example.com"]
+			want := map[string]string{
+				"username": "app",
+				"password": "s3cr3t",
+				"auth":     base64.StdEncoding.EncodeToString([]byte("app:s3cr3t")),
+			}
+			if test.email != "" {
+				want["email"] = test.email
+			}
+			if len(auth) != len(want) {
+				t.Errorf("auth = %v, want %v", auth, want)
+			}
+			for k, v := range want {
+				if auth[k] != v {
+					t.Errorf("auth %s = %q, want %q", k, auth[k], v)
+				}
+			}
+		})
+	}
+}
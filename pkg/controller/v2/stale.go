@@ -0,0 +1,109 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failBackendSync records that secret could not be synced because a secrets backend returned err. A Secret that is
+// stale on error keeps the data of its managed secret and is Degraded until maxStaleness has passed since its last
+// successful sync, after which it fails. A generation that has not been synced yet fails right away, its error may
+// come from the spec rather than from the backend.
+func (h *Handler) failBackendSync(log *common.Logger, secret *dockhand.Secret, err error) {
+	var conditions []metav1.Condition
+	if staleness, ok := h.getStaleness(secret); ok {
+		metrics.SetStale(secret.Namespace, secret.Name, staleness.Seconds())
+		maxStaleness := secret.Spec.StaleOnError.MaxStaleness.Duration
+		if staleness < maxStaleness {
+			log.Warnf("keeping data of %s/%s synced %s ago: %v", secret.Namespace, secret.Name, staleness.Round(time.Second), err)
+			h.recorder.Eventf(
+				secret,
+				corev1.EventTypeWarning,
+				"StaleData",
+				"Keeping data synced %s ago, secrets backend unavailable: %v",
+				staleness.Round(time.Second),
+				err)
+			metrics.SyncErrors.WithLabelValues(secret.Namespace, secret.Name, metrics.OutcomeStale).Inc()
+			// escalate on time even when retries back off beyond the remaining staleness budget
+			h.dhSecretsController.EnqueueAfter(secret.Namespace, secret.Name, maxStaleness-staleness)
+			statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.Degraded, metav1.Condition{
+				Type:    dockhand.ConditionDegraded,
+				Status:  metav1.ConditionTrue,
+				Reason:  dockhand.ReasonBackendUnavailable,
+				Message: err.Error(),
+			})
+			log.LogIfError(statusErr)
+			return
+		}
+
+		h.recorder.Eventf(
+			secret,
+			corev1.EventTypeWarning,
+			"ErrStalenessExceeded",
+			"Data synced %s ago exceeds maxStaleness %s",
+			staleness.Round(time.Second),
+			maxStaleness)
+		conditions = append(conditions, metav1.Condition{
+			Type:    dockhand.ConditionDegraded,
+			Status:  metav1.ConditionTrue,
+			Reason:  dockhand.ReasonStalenessExceeded,
+			Message: err.Error(),
+		})
+	}
+
+	metrics.SyncErrors.WithLabelValues(secret.Namespace, secret.Name, metrics.OutcomeFailed).Inc()
+	statusErr := h.updateDockhandSecretStatus(log, secret, nil, dockhand.ErrApplied, conditions...)
+	log.LogIfError(statusErr)
+}
+
+// getStaleness returns the time since the last successful sync of secret when it is stale on error, its generation was
+// synced and its managed secret exists.
+func (h *Handler) getStaleness(secret *dockhand.Secret) (time.Duration, bool) {
+	if secret.Spec.StaleOnError == nil || secret.Generation != secret.Status.ObservedGeneration {
+		return 0, false
+	}
+	lastSync := secret.Status.SyncTimestamp
+	if lastSync == nil || lastSync.Unix() <= 0 {
+		return 0, false
+	}
+	if _, err := h.secrets.Cache().Get(secret.Namespace, secret.Spec.ManagedSecret.Name); err != nil {
+		return 0, false
+	}
+	return time.Since(lastSync.Time), true
+}
+
+// syncedConditions returns the conditions of a successful sync of secret, which clears a Degraded condition
+func syncedConditions(secret *dockhand.Secret) []metav1.Condition {
+	conditions := []metav1.Condition{dataValidCondition(nil)}
+	if meta.FindStatusCondition(secret.Status.Conditions, dockhand.ConditionDegraded) != nil {
+		conditions = append(conditions, metav1.Condition{
+			Type:    dockhand.ConditionDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  dockhand.ReasonSynced,
+			Message: "Secret data is synced from the secrets backends",
+		})
+	}
+	return conditions
+}
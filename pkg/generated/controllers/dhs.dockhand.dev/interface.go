@@ -19,11 +19,13 @@ package dhs
 
 import (
 	v1alpha2 "github.com/boxboat/dockhand-secrets-operator/pkg/generated/controllers/dhs.dockhand.dev/v1alpha2"
+	v1beta1 "github.com/boxboat/dockhand-secrets-operator/pkg/generated/controllers/dhs.dockhand.dev/v1beta1"
 	"github.com/rancher/lasso/pkg/controller"
 )
 
 type Interface interface {
 	V1alpha2() v1alpha2.Interface
+	V1beta1() v1beta1.Interface
 }
 
 type group struct {
@@ -40,3 +42,7 @@ func New(controllerFactory controller.SharedControllerFactory) Interface {
 func (g *group) V1alpha2() v1alpha2.Interface {
 	return v1alpha2.New(g.controllerFactory)
 }
+
+func (g *group) V1beta1() v1beta1.Interface {
+	return v1beta1.New(g.controllerFactory)
+}
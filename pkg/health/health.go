@@ -0,0 +1,115 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+)
+
+// checkTimeout limits the time of each readiness check
+const checkTimeout = 5 * time.Second
+
+var log = common.ComponentLogger("health")
+
+// Check returns an error when the binary is not ready
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker serves the liveness of a binary on /healthz and its readiness on /readyz. The binary is ready when all of
+// its readiness checks succeed.
+type Checker struct {
+	checks []namedCheck
+	mutex  sync.RWMutex
+}
+
+// NewChecker returns a Checker without readiness checks
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// AddReadinessCheck adds check name to the readiness checks
+func (c *Checker) AddReadinessCheck(name string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Handler returns the handler of /healthz and /readyz
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", c.serveReadiness)
+	return mux
+}
+
+// Serve serves the Handler on address until ctx is done, an empty address disables the health server
+func (c *Checker) Serve(ctx context.Context, address string) {
+	if address == "" {
+		return
+	}
+	server := &http.Server{Addr: address, Handler: c.Handler()}
+	go func() {
+		<-ctx.Done()
+		log.LogIfError(server.Shutdown(context.Background()))
+	}()
+	log.Infof("serving health checks on %s", address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("health server failed: %v", err)
+	}
+}
+
+// serveReadiness runs the readiness checks and lists their results, the status is 503 when any of them fails
+func (c *Checker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	c.mutex.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mutex.RUnlock()
+
+	var results strings.Builder
+	ready := true
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := check.check(ctx)
+		cancel()
+		if err != nil {
+			ready = false
+			fmt.Fprintf(&results, "[-]%s failed: %v\n", check.name, err)
+		} else {
+			fmt.Fprintf(&results, "[+]%s ok\n", check.name)
+		}
+	}
+
+	if !ready {
+		log.Debugf("readiness check failed:\n%s", results.String())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprint(w, results.String())
+		return
+	}
+	_, _ = fmt.Fprint(w, results.String(), "ok\n")
+}
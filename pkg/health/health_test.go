@@ -0,0 +1,72 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		leader     error
+		caches     error
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "ready",
+			wantStatus: http.StatusOK,
+			wantBody:   []string{"[+]leader ok", "[+]caches ok", "ok"},
+		},
+		{
+			name:       "leader not known",
+			leader:     errors.New("leader of the Lease is not known"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   []string{"[-]leader failed: leader of the Lease is not known", "[+]caches ok"},
+		},
+		{
+			name:       "caches not synced",
+			caches:     errors.New("caches have not synced"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   []string{"[+]leader ok", "[-]caches failed: caches have not synced"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := NewChecker()
+			checker.AddReadinessCheck("leader", func(context.Context) error { return test.leader })
+			checker.AddReadinessCheck("caches", func(context.Context) error { return test.caches })
+
+			recorder := httptest.NewRecorder()
+			checker.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			for _, want := range test.wantBody {
+				if !strings.Contains(recorder.Body.String(), want) {
+					t.Errorf("body %q does not contain %q", recorder.Body.String(), want)
+				}
+			}
+		})
+	}
+}
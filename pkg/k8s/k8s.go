@@ -25,18 +25,33 @@ import (
 	"sort"
 	"strings"
 
-	dockhandv2 "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
 	"github.com/gobuffalo/packr/v2/file/resolver/encoding/hex"
-	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
+// certLog logs the management of the webhook certificates
+var certLog = common.ComponentLogger("certmanager")
+
+// annotations of the cert-manager CA injector
+const (
+	certManagerInjectCAFromAnnotationKey       = "cert-manager.io/inject-ca-from"
+	certManagerInjectCAFromSecretAnnotationKey = "cert-manager.io/inject-ca-from-secret"
+)
+
 type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
@@ -52,30 +67,6 @@ func CopyStringMap(source map[string]string) map[string]string {
 	return mapCopy
 }
 
-func GetDeployment(ctx context.Context, name string, namespace string) (*v1.Deployment, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-	return clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
-}
-
-func UpdateDeployment(ctx context.Context, deployment *v1.Deployment, namespace string) (*v1.Deployment, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-	return clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{})
-}
-
 func GetLeaseLock(leaseId string, leaseName string, namespace string) (*resourcelock.LeaseLock, error) {
 	common.Log.Infof("%s requesting %s/%s LeaseLock", leaseId, namespace, leaseName)
 	config, err := rest.InClusterConfig()
@@ -115,7 +106,7 @@ func GetDockhandSecretsListFromK8sSecrets(ctx context.Context, secretNames []str
 	for _, secretName := range secretNames {
 		if secret, err := secretClient.Get(ctx, secretName, metav1.GetOptions{}); !errors.IsNotFound(err) {
 			if secret.Labels != nil {
-				if val, ok := secret.Labels[dockhandv2.DockhandSecretLabelKey]; ok {
+				if val, ok := secret.Labels[dockhand.DockhandSecretLabelKey]; ok {
 					dhSecrets = append(dhSecrets, val)
 				}
 			}
@@ -153,14 +144,21 @@ func GetSecretsChecksum(ctx context.Context, names []string, namespace string) (
 		return "", err
 	}
 
+	secretsClient := clientset.CoreV1().Secrets(namespace)
+	return SecretsChecksum(names, namespace, func(name string) (*corev1.Secret, error) {
+		return secretsClient.Get(ctx, name, metav1.GetOptions{})
+	})
+}
+
+// SecretsChecksum returns a checksum of all of the data in the secrets names of namespace read with get
+func SecretsChecksum(names []string, namespace string, get func(name string) (*corev1.Secret, error)) (string, error) {
 	// sort the names to ensure the checksum doesn't change
 	sort.Strings(names)
 
 	hash := sha1.New()
 
-	secretsClient := clientset.CoreV1().Secrets(namespace)
 	for _, name := range names {
-		secret, err := secretsClient.Get(ctx, name, metav1.GetOptions{})
+		secret, err := get(name)
 		if err != nil {
 			common.Log.Warnf("error retrieving %s/%s %v", namespace, name, err)
 			return "", fmt.Errorf("unable to checksum secret %s/%s", namespace, name)
@@ -178,6 +176,16 @@ func GetSecretsChecksum(ctx context.Context, names []string, namespace string) (
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// CAInjectedByCertManager returns whether the cert-manager CA injector sets the CA bundles of an object with annotations
+func CAInjectedByCertManager(annotations map[string]string) bool {
+	for _, key := range []string{certManagerInjectCAFromAnnotationKey, certManagerInjectCAFromSecretAnnotationKey} {
+		if annotations[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
 // UpdateCABundleForWebhook updates the CA Bundle
 func UpdateCABundleForWebhook(ctx context.Context, name string, caBundleBytes []byte) error {
 	config, err := rest.InClusterConfig()
@@ -194,12 +202,16 @@ func UpdateCABundleForWebhook(ctx context.Context, name string, caBundleBytes []
 	if err != nil {
 		return err
 	}
+	if CAInjectedByCertManager(webhook.Annotations) {
+		certLog.Debugf("%s CABundle is injected by cert-manager - not updating webhook configuration", name)
+		return nil
+	}
 
 	change := false
 
 	for idx := range webhook.Webhooks {
 		if bytes.Compare(webhook.Webhooks[idx].ClientConfig.CABundle, caBundleBytes) != 0 {
-			common.Log.Infof("updating %s CABundle", webhook.Webhooks[idx].Name)
+			certLog.Infof("updating %s CABundle", webhook.Webhooks[idx].Name)
 			webhook.Webhooks[idx].ClientConfig.CABundle = caBundleBytes
 			change = true
 		}
@@ -210,13 +222,28 @@ func UpdateCABundleForWebhook(ctx context.Context, name string, caBundleBytes []
 			return err
 		}
 	} else {
-		common.Log.Debugf("no change detected with CA pem - not updating webhook configuration")
+		certLog.Debugf("no change detected with CA pem - not updating webhook configuration")
 	}
 
 	return nil
 }
 
-func UpdateTlsCertificateSecret(ctx context.Context, name string, namespace string, serverPem []byte, serverKey []byte, caPem []byte) error {
+// GetTlsCertificateSecret returns the TLS Secret namespace/name
+func GetTlsCertificateSecret(ctx context.Context, name string, namespace string) (*corev1.Secret, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// UpdateTlsCertificateSecret updates tlsSecret, or creates it when it has no resourceVersion. Updates fail when
+// tlsSecret changed since it was read.
+func UpdateTlsCertificateSecret(ctx context.Context, tlsSecret *corev1.Secret) error {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return err
@@ -226,28 +253,68 @@ func UpdateTlsCertificateSecret(ctx context.Context, name string, namespace stri
 		return err
 	}
 
-	tlsSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-		Type: corev1.SecretTypeTLS,
-		Data: map[string][]byte{
-			"tls.key": serverKey,
-			"tls.crt": serverPem,
-			"ca.crt":  caPem,
-		},
-	}
-	_, err = clientset.CoreV1().Secrets(namespace).Update(ctx, tlsSecret, metav1.UpdateOptions{})
-	if errors.IsNotFound(err) {
-		if _, err = clientset.CoreV1().Secrets(namespace).Create(ctx, tlsSecret, metav1.CreateOptions{}); err != nil {
+	if tlsSecret.ResourceVersion == "" {
+		if _, err = clientset.CoreV1().Secrets(tlsSecret.Namespace).Create(ctx, tlsSecret, metav1.CreateOptions{}); err != nil {
 			return err
 		}
-		common.Log.Infof("Created secret[%s]", tlsSecret.Name)
-	} else {
-		common.Log.Infof("Updated secret[%s]", tlsSecret.Name)
+		certLog.Infof("Created secret[%s]", tlsSecret.Name)
+		return nil
+	}
+	if _, err = clientset.CoreV1().Secrets(tlsSecret.Namespace).Update(ctx, tlsSecret, metav1.UpdateOptions{}); err != nil {
+		return err
 	}
+	certLog.Infof("Updated secret[%s]", tlsSecret.Name)
 	return nil
+}
+
+// CheckAPIServer returns an error when the kubernetes API server can not be reached with the in cluster config
+func CheckAPIServer(ctx context.Context) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// WatchSecret calls onChange with the Secret namespace/name whenever it is added or updated until ctx is done
+func WatchSecret(ctx context.Context, name string, namespace string, onChange func(*corev1.Secret)) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
 
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	_, err = factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				onChange(secret)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				onChange(secret)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	return nil
 }
 
 // GetServiceCertificate for service and namespace.
@@ -346,3 +413,106 @@ func GenerateSpecTemplateAnnotationPatch(target map[string]string, added map[str
 	}
 	return patch
 }
+
+// UpdateCABundleForCRDConversion updates the CA Bundle of the conversion webhook for CRDs using webhook conversion
+func UpdateCABundleForCRDConversion(ctx context.Context, names []string, caBundleBytes []byte) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	crdClient := clientset.ApiextensionsV1().CustomResourceDefinitions()
+	for _, name := range names {
+		crd, err := crdClient.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter ||
+			conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+			certLog.Debugf("%s does not use webhook conversion - not updating CABundle", name)
+			continue
+		}
+		if CAInjectedByCertManager(crd.Annotations) {
+			certLog.Debugf("%s CABundle is injected by cert-manager - not updating conversion", name)
+			continue
+		}
+		if bytes.Equal(conversion.Webhook.ClientConfig.CABundle, caBundleBytes) {
+			certLog.Debugf("no change detected with CA pem - not updating %s conversion", name)
+			continue
+		}
+		certLog.Infof("updating %s conversion CABundle", name)
+		conversion.Webhook.ClientConfig.CABundle = caBundleBytes
+		if _, err := crdClient.Update(ctx, crd, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateStorageVersion rewrites every stored object of a CRD in the current storage version and then drops the
+// previous versions from status.storedVersions so they can eventually be removed from the CRD.
+func MigrateStorageVersion(ctx context.Context, name string) error {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	crdClient := clientset.ApiextensionsV1().CustomResourceDefinitions()
+	crd, err := crdClient.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	storageVersion := ""
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			storageVersion = version.Name
+		}
+	}
+	if storageVersion == "" {
+		return fmt.Errorf("no storage version defined for %s", name)
+	}
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		common.Log.Debugf("%s already migrated to %s", name, storageVersion)
+		return nil
+	}
+
+	common.Log.Infof("migrating %s to storage version %s", name, storageVersion)
+	resourceClient := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    crd.Spec.Group,
+		Version:  storageVersion,
+		Resource: crd.Spec.Names.Plural,
+	})
+	list, err := resourceClient.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for idx := range list.Items {
+		item := &list.Items[idx]
+		// a no-op update is enough for the api server to persist the object in the storage version, a conflict means
+		// the object has already been written since it was listed
+		if _, err := resourceClient.Namespace(item.GetNamespace()).Update(ctx, item, metav1.UpdateOptions{}); err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+			return fmt.Errorf("unable to migrate %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+		}
+	}
+
+	crd.Status.StoredVersions = []string{storageVersion}
+	if _, err := crdClient.UpdateStatus(ctx, crd, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	common.Log.Infof("migrated %d %s to storage version %s", len(list.Items), name, storageVersion)
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a failed sync recorded by SyncErrors
+const (
+	OutcomeStale  = "stale"
+	OutcomeFailed = "failed"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// SyncErrors counts failed syncs of Dockhand Secrets, stale syncs kept the data of the managed secret
+	SyncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dockhand",
+		Name:      "secret_sync_errors_total",
+		Help:      "Failed syncs of Dockhand Secrets by outcome, stale syncs kept the last synced data.",
+	}, []string{"namespace", "name", "outcome"})
+
+	// Stale is 1 while a Dockhand Secret serves the data of its last successful sync
+	Stale = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dockhand",
+		Name:      "secret_stale",
+		Help:      "1 while a Dockhand Secret serves the data of its last successful sync.",
+	}, []string{"namespace", "name"})
+
+	// StalenessSeconds is the time since the last successful sync of a stale Dockhand Secret
+	StalenessSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dockhand",
+		Name:      "secret_staleness_seconds",
+		Help:      "Seconds since the last successful sync of a stale Dockhand Secret.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SyncErrors,
+		Stale,
+		StalenessSeconds)
+}
+
+// SetStale records whether the Dockhand Secret namespace/name serves stale data and for how long
+func SetStale(namespace, name string, seconds float64) {
+	Stale.WithLabelValues(namespace, name).Set(1)
+	StalenessSeconds.WithLabelValues(namespace, name).Set(seconds)
+}
+
+// ClearStale removes the staleness of the Dockhand Secret namespace/name
+func ClearStale(namespace, name string) {
+	Stale.DeleteLabelValues(namespace, name)
+	StalenessSeconds.DeleteLabelValues(namespace, name)
+}
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
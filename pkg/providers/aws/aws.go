@@ -0,0 +1,291 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	Name = "awsSecretsManager"
+
+	ecrUsername  = "AWS"
+	currentStage = "AWSCURRENT"
+)
+
+var log = common.ComponentLogger("provider." + Name)
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider for AWS Secrets Manager and ECR registry tokens
+type Provider struct{}
+
+type Client struct {
+	secretsManager *secretsmanager.Client
+	config         awssdk.Config
+	cache          *cache.Cache
+	registry       *providers.RegistryTokenSource
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.AwsSecretsManager != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.AwsSecretsManager
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+	}
+	return providers.ValidateSecretRef(Name+".secretAccessKeyRef", config.SecretAccessKeyRef)
+}
+
+func (p *Provider) NewClient(ctx context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.AwsSecretsManager
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	accessKeyID := ""
+	if config.AccessKeyId != nil {
+		accessKeyID = *config.AccessKeyId
+	}
+	secretAccessKey, err := providers.SecretRefValue(reader, profile.Namespace, config.SecretAccessKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	sdkConfig, err := loadConfig(ctx, config.Region, accessKeyID, secretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		secretsManager: secretsmanager.NewFromConfig(sdkConfig),
+		config:         sdkConfig,
+		cache:          cache.New(cacheTTL, cacheTTL),
+	}
+	client.registry = providers.NewRegistryTokenSource(client.mintEcrToken)
+	return client, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"aws", "awsJson", "awsText", "ecrToken", "ecrDockerConfigJson"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.AwsSecretsManager == nil {
+		return "", false
+	}
+	return source.AwsSecretsManager.Name, true
+}
+
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	ctx := providers.WithRender(render.RequestContext(), render)
+	getJSONSecret := func(name string, key string) (string, error) {
+		return c.getJSONSecret(ctx, name, key)
+	}
+	return template.FuncMap{
+		"aws":     getJSONSecret,
+		"awsJson": getJSONSecret,
+		"awsText": func(name string) (string, error) {
+			return c.getSecretString(ctx, name)
+		},
+		"ecrToken":            providers.RegistryTokenFunc(ctx, c.registry, &render.Refresh),
+		"ecrDockerConfigJson": providers.RegistryDockerConfigJsonFunc(ctx, c.registry, ecrUsername, &render.Refresh),
+	}
+}
+
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	return c.getSecretString(ctx, providers.WithVersion(name, version))
+}
+
+// getJSONSecret returns key of the json object stored in secret name, optionally suffixed with ?version=
+func (c *Client) getJSONSecret(ctx context.Context, name string, key string) (string, error) {
+	value, err := c.getSecretString(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return providers.JSONKey(Name, name, value, key)
+}
+
+// getSecretString returns the secret string of secret name, optionally suffixed with ?version=. A version selects the
+// version id, the AWSCURRENT version is returned without a version or with latest.
+func (c *Client) getSecretString(ctx context.Context, name string) (string, error) {
+	if value, ok := c.cache.Get(name); ok {
+		providers.RenderFromContext(ctx).Log(log).Debugf("using cached %s secret [%s]", Name, name)
+		return value.(string), nil
+	}
+
+	secretName, version := providers.SplitVersion(name)
+	input := &secretsmanager.GetSecretValueInput{SecretId: awssdk.String(secretName)}
+	if version == "" || version == "latest" {
+		input.VersionStage = awssdk.String(currentStage)
+	} else {
+		input.VersionId = awssdk.String(version)
+	}
+	output, err := c.secretsManager.GetSecretValue(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("secret{%s}: %v", secretName, err)
+	}
+	value := awssdk.ToString(output.SecretString)
+	c.cache.SetDefault(name, value)
+	return value, nil
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
+	_, err := sts.NewFromConfig(c.config).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	return err
+}
+
+// SecretVersion returns the id of the AWSCURRENT version of secret name, secrets scheduled for deletion do not exist
+func (c *Client) SecretVersion(ctx context.Context, name string) (string, error) {
+	output, err := c.secretsManager.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: awssdk.String(name),
+	})
+	if err != nil {
+		var notFound *smtypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	if output.DeletedDate != nil {
+		return "", nil
+	}
+	return currentVersion(output.VersionIdsToStages), nil
+}
+
+// PutSecret writes value as the AWSCURRENT version of secret name, secrets scheduled for deletion are restored first
+func (c *Client) PutSecret(ctx context.Context, name string, value string) (string, error) {
+	output, err := c.secretsManager.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: awssdk.String(name)})
+	if err != nil {
+		var notFound *smtypes.ResourceNotFoundException
+		if !errors.As(err, &notFound) {
+			return "", err
+		}
+		created, err := c.secretsManager.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         awssdk.String(name),
+			SecretString: awssdk.String(value),
+		})
+		if err != nil {
+			return "", err
+		}
+		return awssdk.ToString(created.VersionId), nil
+	}
+	if output.DeletedDate != nil {
+		if _, err := c.secretsManager.RestoreSecret(ctx, &secretsmanager.RestoreSecretInput{SecretId: awssdk.String(name)}); err != nil {
+			return "", err
+		}
+	}
+	put, err := c.secretsManager.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     awssdk.String(name),
+		SecretString: awssdk.String(value),
+	})
+	if err != nil {
+		return "", err
+	}
+	return awssdk.ToString(put.VersionId), nil
+}
+
+// DeleteSecret schedules secret name for deletion after the default recovery window
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	_, err := c.secretsManager.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+		SecretId: awssdk.String(name),
+	})
+	var notFound *smtypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// currentVersion returns the version id labeled AWSCURRENT
+func currentVersion(versionStages map[string][]string) string {
+	for version, stages := range versionStages {
+		for _, stage := range stages {
+			if stage == currentStage {
+				return version
+			}
+		}
+	}
+	return ""
+}
+
+// loadConfig loads the AWS SDK configuration of region, static credentials are used when both accessKeyID and
+// secretAccessKey are set otherwise chain credentials are used.
+func loadConfig(ctx context.Context, region, accessKeyID, secretAccessKey string) (awssdk.Config, error) {
+	configOpts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if accessKeyID != "" && secretAccessKey != "" {
+		configOpts = append(configOpts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")))
+	}
+	return awsconfig.LoadDefaultConfig(ctx, configOpts...)
+}
+
+// mintEcrToken mints ECR authorization tokens. The region is taken from the registry hostname
+// <account>.dkr.ecr.<region>.amazonaws.com when present, otherwise the region of the profile is used.
+func (c *Client) mintEcrToken(ctx context.Context, registry string) (*providers.RegistryToken, error) {
+	region := c.config.Region
+	if parts := strings.Split(registry, "."); len(parts) > 4 && parts[1] == "dkr" && parts[2] == "ecr" {
+		region = parts[3]
+	}
+	output, err := ecr.NewFromConfig(c.config, func(o *ecr.Options) {
+		o.Region = region
+	}).GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.AuthorizationData) == 0 || output.AuthorizationData[0].AuthorizationToken == nil {
+		return nil, fmt.Errorf("no ecr authorization token returned for %s", registry)
+	}
+	authData := output.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(*authData.AuthorizationToken)
+	if err != nil {
+		return nil, err
+	}
+	expiry := time.Now().Add(12 * time.Hour)
+	if authData.ExpiresAt != nil {
+		expiry = *authData.ExpiresAt
+	}
+	return &providers.RegistryToken{
+		Password: strings.TrimPrefix(string(decoded), ecrUsername+":"),
+		Expiry:   expiry,
+	}, nil
+}
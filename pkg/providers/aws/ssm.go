@@ -0,0 +1,241 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+const ParameterStoreName = "awsParameterStore"
+
+var ssmLog = common.ComponentLogger("provider." + ParameterStoreName)
+
+func init() {
+	providers.Register(&ParameterStoreProvider{})
+}
+
+// ParameterStoreProvider for AWS Systems Manager Parameter Store. SecureString parameters are always decrypted and
+// parameters can be pinned to a version or label with ?version=
+type ParameterStoreProvider struct{}
+
+type ParameterStoreClient struct {
+	ssm    *ssm.Client
+	config awssdk.Config
+	cache  *cache.Cache
+}
+
+func (p *ParameterStoreProvider) Name() string {
+	return ParameterStoreName
+}
+
+func (p *ParameterStoreProvider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.AwsParameterStore != nil
+}
+
+func (p *ParameterStoreProvider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.AwsParameterStore
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", ParameterStoreName, err)
+	}
+	return providers.ValidateSecretRef(ParameterStoreName+".secretAccessKeyRef", config.SecretAccessKeyRef)
+}
+
+func (p *ParameterStoreProvider) NewClient(ctx context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.AwsParameterStore
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	accessKeyID := ""
+	if config.AccessKeyId != nil {
+		accessKeyID = *config.AccessKeyId
+	}
+	secretAccessKey, err := providers.SecretRefValue(reader, profile.Namespace, config.SecretAccessKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	sdkConfig, err := loadConfig(ctx, config.Region, accessKeyID, secretAccessKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParameterStoreClient{
+		ssm:    ssm.NewFromConfig(sdkConfig),
+		config: sdkConfig,
+		cache:  cache.New(cacheTTL, cacheTTL),
+	}, nil
+}
+
+func (p *ParameterStoreProvider) FuncNames() []string {
+	return []string{"ssmJson", "ssmText"}
+}
+
+// DataFromName returns the parameter name of source, or its path with a trailing / to expand every parameter below it
+func (p *ParameterStoreProvider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.AwsParameterStore == nil {
+		return "", false
+	}
+	if source.AwsParameterStore.Path != "" {
+		return strings.TrimSuffix(source.AwsParameterStore.Path, "/") + "/", true
+	}
+	return source.AwsParameterStore.Name, true
+}
+
+// FuncMap returns the template functions of the client, parameters are read with the context of render so that the
+// calls end with the reconcile
+func (c *ParameterStoreClient) FuncMap(render *providers.Render) template.FuncMap {
+	ctx := providers.WithRender(render.RequestContext(), render)
+	return template.FuncMap{
+		"ssmJson": func(name string, key string) (string, error) {
+			return c.getJSONParameter(ctx, name, key)
+		},
+		"ssmText": func(name string) (string, error) {
+			return c.getTextParameter(ctx, name)
+		},
+	}
+}
+
+// GetSecret returns the value of parameter name at version. When name ends with / every parameter below it is
+// returned as a json object keyed by the parameter name relative to the path, with / replaced by _
+func (c *ParameterStoreClient) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	if strings.HasSuffix(name, "/") {
+		if version != "" && version != "latest" {
+			return "", fmt.Errorf("%s path %s can not be pinned to a version", ParameterStoreName, name)
+		}
+		parameters, err := c.getParametersByPath(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		value, err := json.Marshal(parameters)
+		return string(value), err
+	}
+	return c.getParameter(ctx, name, version)
+}
+
+func (c *ParameterStoreClient) HealthCheck(ctx context.Context) error {
+	_, err := sts.NewFromConfig(c.config).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	return err
+}
+
+// getTextParameter returns the value of parameter name, optionally suffixed with ?version=
+func (c *ParameterStoreClient) getTextParameter(ctx context.Context, name string) (string, error) {
+	name, version := providers.SplitVersion(name)
+	return c.getParameter(ctx, name, version)
+}
+
+// getJSONParameter returns key of the json object stored in parameter name, optionally suffixed with ?version=
+func (c *ParameterStoreClient) getJSONParameter(ctx context.Context, name string, key string) (string, error) {
+	value, err := c.getTextParameter(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return "", fmt.Errorf("%s parameter %s is not a json object: %v", ParameterStoreName, name, err)
+	}
+	keyValue, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("%s parameter %s does not contain %s", ParameterStoreName, name, key)
+	}
+	if text, ok := keyValue.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(keyValue)
+	return string(text), err
+}
+
+// getParameter returns the decrypted value of parameter name. A version selects the parameter version when numeric
+// and the parameter label otherwise.
+func (c *ParameterStoreClient) getParameter(ctx context.Context, name string, version string) (string, error) {
+	selector := name
+	if version != "" && version != "latest" {
+		selector = name + ":" + version
+	}
+	if value, ok := c.cache.Get(selector); ok {
+		providers.RenderFromContext(ctx).Log(ssmLog).Debugf("using cached %s parameter [%s]", ParameterStoreName, selector)
+		return value.(string), nil
+	}
+
+	output, err := c.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           awssdk.String(selector),
+		WithDecryption: awssdk.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("parameter{%s}: %v", selector, err)
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter{%s}: no value returned", selector)
+	}
+	value := *output.Parameter.Value
+	c.cache.SetDefault(selector, value)
+	return value, nil
+}
+
+// getParametersByPath returns the decrypted value of every parameter below path, it fails when two parameters are
+// flattened to the same key e.g. db/password and db_password
+func (c *ParameterStoreClient) getParametersByPath(ctx context.Context, path string) (map[string]string, error) {
+	if value, ok := c.cache.Get(path); ok {
+		providers.RenderFromContext(ctx).Log(ssmLog).Debugf("using cached %s path [%s]", ParameterStoreName, path)
+		return value.(map[string]string), nil
+	}
+
+	searchPath := strings.TrimSuffix(path, "/")
+	if searchPath == "" {
+		searchPath = "/"
+	}
+	parameters := make(map[string]string)
+	// names holds the parameter name of every key to report the parameters that are flattened to the same key
+	names := make(map[string]string)
+	paginator := ssm.NewGetParametersByPathPaginator(c.ssm, &ssm.GetParametersByPathInput{
+		Path:           awssdk.String(searchPath),
+		Recursive:      awssdk.Bool(true),
+		WithDecryption: awssdk.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("path{%s}: %v", path, err)
+		}
+		for _, parameter := range page.Parameters {
+			if parameter.Name == nil || parameter.Value == nil {
+				continue
+			}
+			key := strings.ReplaceAll(strings.TrimPrefix(*parameter.Name, path), "/", "_")
+			if _, ok := names[key]; ok {
+				return nil, fmt.Errorf("path{%s}: parameters %s and %s are both copied to key %s", path, names[key], *parameter.Name, key)
+			}
+			names[key] = *parameter.Name
+			parameters[key] = *parameter.Value
+		}
+	}
+	c.cache.SetDefault(path, parameters)
+	return parameters, nil
+}
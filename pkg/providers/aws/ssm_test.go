@@ -0,0 +1,142 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+// newFakeParameterStore returns a client of a Parameter Store serving parameters by name and by path
+func newFakeParameterStore(t *testing.T, parameters map[string]string) *ParameterStoreClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ Name, Path string }
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			if value, ok := parameters[input.Name]; ok {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"Parameter": map[string]interface{}{"Name": input.Name, "Value": value},
+				})
+				return
+			}
+		case "AmazonSSM.GetParametersByPath":
+			var page []map[string]interface{}
+			for name, value := range parameters {
+				if strings.HasPrefix(name, input.Path+"/") {
+					page = append(page, map[string]interface{}{"Name": name, "Value": value})
+				}
+			}
+			sort.Slice(page, func(i, j int) bool {
+				return page[i]["Name"].(string) < page[j]["Name"].(string)
+			})
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"Parameters": page})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"ParameterNotFound","message":"not found"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client := ssm.New(ssm.Options{
+		BaseEndpoint: awssdk.String(server.URL),
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	})
+	return &ParameterStoreClient{ssm: client, cache: cache.New(time.Minute, time.Minute)}
+}
+
+func TestTemplateFunctionsUseRenderContext(t *testing.T) {
+	client := newFakeParameterStore(t, map[string]string{"/app/db": `{"password":"s3cr3t"}`})
+
+	ssmJson := client.FuncMap(&providers.Render{Context: context.Background()})["ssmJson"].(func(string, string) (string, error))
+	password, err := ssmJson("/app/db", "password")
+	if err != nil {
+		t.Fatalf("ssmJson: %v", err)
+	}
+	if password != "s3cr3t" {
+		t.Errorf("ssmJson = %s, want s3cr3t", password)
+	}
+
+	// the parameter is not cached, the call fails with the cancelled reconcile
+	client.cache.Flush()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ssmText := client.FuncMap(&providers.Render{Context: ctx})["ssmText"].(func(string) (string, error))
+	if _, err := ssmText("/app/db"); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("ssmText error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParametersByPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		want       string
+		wantErr    string
+	}{
+		{
+			name: "nested parameters",
+			parameters: map[string]string{
+				"/app/db/password": "s3cr3t",
+				"/app/api_key":     "key",
+				"/other/token":     "token",
+			},
+			want: `{"api_key":"key","db_password":"s3cr3t"}`,
+		},
+		{
+			name: "colliding keys",
+			parameters: map[string]string{
+				"/app/db/password": "s3cr3t",
+				"/app/db_password": "other",
+			},
+			wantErr: "parameters /app/db/password and /app/db_password are both copied to key db_password",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeParameterStore(t, test.parameters)
+			value, err := client.GetSecret(context.Background(), "/app/", "")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != test.want {
+				t.Errorf("value = %s, want %s", value, test.want)
+			}
+		})
+	}
+}
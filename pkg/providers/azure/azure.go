@@ -0,0 +1,295 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	Name = "azureKeyVault"
+
+	acrUsername          = "00000000-0000-0000-0000-000000000000"
+	azureManagementScope = "https://management.azure.com/.default"
+	azureKeyVaultScope   = "https://vault.azure.net/.default"
+	keyVaultURLFormat    = "https://%s.vault.azure.net/"
+	// acrExchangeTimeout limits the time of the ACR token exchange
+	acrExchangeTimeout = 30 * time.Second
+)
+
+// acrClient exchanges Azure AD access tokens for ACR refresh tokens
+var acrClient = &http.Client{Timeout: acrExchangeTimeout}
+
+var log = common.ComponentLogger("provider." + Name)
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider for Azure Key Vault and ACR registry tokens
+type Provider struct{}
+
+type Client struct {
+	keyVault   *azsecrets.Client
+	cache      *cache.Cache
+	tenant     string
+	credential azcore.TokenCredential
+	registry   *providers.RegistryTokenSource
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.AzureKeyVault != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.AzureKeyVault
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+	}
+	if config.KeyVault == "" {
+		return fmt.Errorf("%s.keyVault is required", Name)
+	}
+	return providers.ValidateSecretRef(Name+".clientSecretRef", config.ClientSecretRef)
+}
+
+func (p *Provider) NewClient(ctx context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.AzureKeyVault
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := ""
+	if config.ClientId != nil {
+		clientID = *config.ClientId
+	}
+	clientSecret, err := providers.SecretRefValue(reader, profile.Namespace, config.ClientSecretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential azcore.TokenCredential
+	if clientID != "" && clientSecret != "" {
+		credential, err = azidentity.NewClientSecretCredential(config.Tenant, clientID, clientSecret, nil)
+	} else {
+		credential, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: config.Tenant})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyVault, err := azsecrets.NewClient(fmt.Sprintf(keyVaultURLFormat, config.KeyVault), credential, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		keyVault:   keyVault,
+		cache:      cache.New(cacheTTL, cacheTTL),
+		tenant:     config.Tenant,
+		credential: credential,
+	}
+	client.registry = providers.NewRegistryTokenSource(client.mintAcrToken)
+	return client, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"azureJson", "azureText", "acrToken", "acrDockerConfigJson"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.AzureKeyVault == nil {
+		return "", false
+	}
+	return source.AzureKeyVault.Name, true
+}
+
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	ctx := providers.WithRender(render.RequestContext(), render)
+	return template.FuncMap{
+		"azureJson": func(name string, key string) (string, error) {
+			return c.getJSONSecret(ctx, name, key)
+		},
+		"azureText": func(name string) (string, error) {
+			return c.getTextSecret(ctx, name)
+		},
+		"acrToken":            providers.RegistryTokenFunc(ctx, c.registry, &render.Refresh),
+		"acrDockerConfigJson": providers.RegistryDockerConfigJsonFunc(ctx, c.registry, acrUsername, &render.Refresh),
+	}
+}
+
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	return c.getTextSecret(ctx, providers.WithVersion(name, version))
+}
+
+// getJSONSecret returns key of the json object stored in secret name, optionally suffixed with ?version=
+func (c *Client) getJSONSecret(ctx context.Context, name string, key string) (string, error) {
+	value, err := c.getTextSecret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return providers.JSONKey(Name, name, value, key)
+}
+
+// getTextSecret returns the value of secret name, optionally suffixed with ?version=. The current version is returned
+// without a version or with latest.
+func (c *Client) getTextSecret(ctx context.Context, name string) (string, error) {
+	if value, ok := c.cache.Get(name); ok {
+		providers.RenderFromContext(ctx).Log(log).Debugf("using cached %s secret [%s]", Name, name)
+		return value.(string), nil
+	}
+
+	secretName, version := providers.SplitVersion(name)
+	if version == "latest" {
+		version = ""
+	}
+	resp, err := c.keyVault.GetSecret(ctx, secretName, version, nil)
+	if err != nil {
+		return "", fmt.Errorf("secret{%s}: %v", secretName, err)
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("secret{%s}: no value returned", secretName)
+	}
+	c.cache.SetDefault(name, *resp.Value)
+	return *resp.Value, nil
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
+	_, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureKeyVaultScope}})
+	return err
+}
+
+// SecretVersion returns the current version of secret name
+func (c *Client) SecretVersion(ctx context.Context, name string) (string, error) {
+	resp, err := c.keyVault.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return resp.ID.Version(), nil
+}
+
+// PutSecret writes value as a new version of secret name
+func (c *Client) PutSecret(ctx context.Context, name string, value string) (string, error) {
+	resp, err := c.keyVault.SetSecret(ctx, name, azsecrets.SetSecretParameters{Value: &value}, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID.Version(), nil
+}
+
+// DeleteSecret deletes secret name, which is retained as a deleted secret when soft-delete is enabled on the vault
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	if _, err := c.keyVault.DeleteSecret(ctx, name, nil); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// mintAcrToken mints ACR refresh tokens by exchanging an Azure AD access token with the registry.
+func (c *Client) mintAcrToken(ctx context.Context, registry string) (*providers.RegistryToken, error) {
+	aadToken, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureManagementScope}})
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {c.tenant},
+		"access_token": {aadToken.Token},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+registry+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := acrClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acr token exchange for %s failed with status %s", registry, resp.Status)
+	}
+	exchange := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&exchange); err != nil {
+		return nil, err
+	}
+	if exchange.RefreshToken == "" {
+		return nil, fmt.Errorf("no acr refresh token returned for %s", registry)
+	}
+
+	expiry, err := jwtExpiry(exchange.RefreshToken)
+	if err != nil {
+		expiry = aadToken.ExpiresOn
+	}
+	return &providers.RegistryToken{Password: exchange.RefreshToken, Expiry: expiry}, nil
+}
+
+// jwtExpiry returns the exp claim of a JWT without verifying it
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("token is not a jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+	claims := struct {
+		Exp int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, errors.New("jwt does not contain exp")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"text/template"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+var failoverLog = common.ComponentLogger("provider.failover")
+
+// FailoverCandidate is the client of a backend configured by the Profile namespace/name
+type FailoverCandidate struct {
+	Profile string
+	Client  Client
+	// Timeout of each call, 0 waits until the call returns
+	Timeout time.Duration
+}
+
+// FailoverClient calls its candidates in order until one of them succeeds. The first candidate is the client of the
+// Profile, the others are the clients of its fallbacks.
+type FailoverClient struct {
+	backend    string
+	candidates []FailoverCandidate
+}
+
+// NewFailoverClient returns a client of backend that fails over between candidates, which must not be empty
+func NewFailoverClient(backend string, candidates []FailoverCandidate) *FailoverClient {
+	return &FailoverClient{backend: backend, candidates: candidates}
+}
+
+// FuncMap returns the functions of the first candidate, each of which fails over to the function of the same name of
+// the other candidates when it returns an error or times out. Each call of a function that can fail over gets the
+// functions of the candidates from a Render of its own, so that only the candidate that succeeds records in render.
+func (c *FailoverClient) FuncMap(render *Render) template.FuncMap {
+	funcMaps := make([]template.FuncMap, len(c.candidates))
+	funcMaps[0] = c.candidates[0].Client.FuncMap(render)
+	for i := 1; i < len(c.candidates); i++ {
+		funcMaps[i] = c.candidates[i].Client.FuncMap(render.fork(render.RequestContext()))
+	}
+	funcMap := make(template.FuncMap, len(funcMaps[0]))
+	for name, function := range funcMaps[0] {
+		fnType := reflect.TypeOf(function)
+		if fnType.Kind() != reflect.Func || fnType.NumOut() == 0 || fnType.Out(fnType.NumOut()-1) != errorType {
+			// functions that do not return an error can not fail over
+			funcMap[name] = function
+			continue
+		}
+		candidates := make([]FailoverCandidate, 0, len(c.candidates))
+		for i, candidate := range c.candidates {
+			if reflect.TypeOf(funcMaps[i][name]) == fnType {
+				candidates = append(candidates, candidate)
+			}
+		}
+		funcMap[name] = c.failoverFunc(name, fnType, candidates, render)
+	}
+	return funcMap
+}
+
+// GetSecret returns the secret from the first candidate that succeeds
+func (c *FailoverClient) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	render := RenderFromContext(ctx)
+	var err error
+	for _, candidate := range c.candidates {
+		var value string
+		spanCtx, span := tracing.Start(ctx, c.backend+".GetSecret", c.spanAttributes(candidate, "GetSecret")...)
+		callCtx, cancel := contextWithTimeout(spanCtx, candidate.Timeout)
+		callRender := render.fork(callCtx)
+		value, err = candidate.Client.GetSecret(WithRender(callCtx, callRender), name, version)
+		cancel()
+		tracing.End(span, err)
+		if err == nil {
+			render.merge(callRender)
+			c.served(render, candidate.Profile)
+			return value, nil
+		}
+		render.Log(failoverLog).Warnf("%s of profile %s failed to get %s: %v", c.backend, candidate.Profile, name, err)
+	}
+	return "", err
+}
+
+// HealthCheck succeeds when any candidate is healthy
+func (c *FailoverClient) HealthCheck(ctx context.Context) error {
+	var err error
+	for _, candidate := range c.candidates {
+		callCtx, cancel := contextWithTimeout(ctx, candidate.Timeout)
+		err = candidate.Client.HealthCheck(callCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *FailoverClient) served(render *Render, servedBy string) {
+	if render != nil {
+		render.Served(c.candidates[0].Profile, c.backend, servedBy)
+	}
+}
+
+// spanAttributes returns the attributes of the span of a call of function to candidate
+func (c *FailoverClient) spanAttributes(candidate FailoverCandidate, function string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		tracing.AttributeBackend.String(c.backend),
+		tracing.AttributeProfile.String(candidate.Profile),
+		tracing.AttributeFunction.String(function),
+	}
+}
+
+// failoverFunc returns a function of type fnType that calls function name of each candidate in turn until one does
+// not return an error
+func (c *FailoverClient) failoverFunc(name string, fnType reflect.Type, candidates []FailoverCandidate, render *Render) interface{} {
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		var results []reflect.Value
+		for _, candidate := range candidates {
+			results = c.call(candidate, name, fnType, args, render)
+			err := results[len(results)-1]
+			if err.IsNil() {
+				return results
+			}
+			render.Log(failoverLog).Warnf("%s %s of profile %s failed: %v", c.backend, name, candidate.Profile, err.Interface())
+		}
+		return results
+	}).Interface()
+}
+
+// call calls function name of candidate with args. The function is taken from the FuncMap of a Render of its own with
+// a context that is cancelled when the call returns or times out. The Render is merged into render once the call
+// succeeds, and is discarded otherwise so that a call that times out can not change render while it keeps running.
+func (c *FailoverClient) call(candidate FailoverCandidate, name string, fnType reflect.Type, args []reflect.Value, render *Render) []reflect.Value {
+	spanCtx, span := tracing.Start(render.RequestContext(), c.backend+"."+name, c.spanAttributes(candidate, name)...)
+	callCtx, cancel := contextWithTimeout(spanCtx, candidate.Timeout)
+	defer cancel()
+
+	callRender := render.fork(callCtx)
+	function := reflect.ValueOf(candidate.Client.FuncMap(callRender)[name])
+	if !function.IsValid() || function.Type() != fnType {
+		err := fmt.Errorf("%s of profile %s does not provide %s", c.backend, candidate.Profile, name)
+		tracing.End(span, err)
+		return errorResults(fnType, err)
+	}
+	results := callWithTimeout(function, args, candidate.Timeout)
+	if err := results[len(results)-1]; !err.IsNil() {
+		tracing.End(span, err.Interface().(error))
+		return results
+	}
+	span.End()
+	render.merge(callRender)
+	c.served(render, candidate.Profile)
+	return results
+}
+
+// callWithTimeout calls function with args and returns an error when it does not return within timeout. A call that
+// times out keeps running in the background until it observes the cancellation of its context, so backend functions
+// must request their backend with the context of the render they were created for.
+func callWithTimeout(function reflect.Value, args []reflect.Value, timeout time.Duration) []reflect.Value {
+	call := func() []reflect.Value {
+		if function.Type().IsVariadic() {
+			return function.CallSlice(args)
+		}
+		return function.Call(args)
+	}
+	if timeout <= 0 {
+		return call()
+	}
+
+	done := make(chan []reflect.Value, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case results := <-done:
+		return results
+	case <-time.After(timeout):
+		return errorResults(function.Type(), fmt.Errorf("timed out after %s", timeout))
+	}
+}
+
+// errorResults returns the zero results of a function of fnType with err as its last result
+func errorResults(fnType reflect.Type, err error) []reflect.Value {
+	results := make([]reflect.Value, fnType.NumOut())
+	for i := range results {
+		results[i] = reflect.Zero(fnType.Out(i))
+	}
+	errValue := reflect.New(errorType).Elem()
+	errValue.Set(reflect.ValueOf(err))
+	results[len(results)-1] = errValue
+	return results
+}
+
+func contextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+)
+
+// fakeClient records source in the Render of each call of get, which returns the result of call
+type fakeClient struct {
+	source string
+	call   func(ctx context.Context) (string, error)
+}
+
+func (c *fakeClient) FuncMap(render *Render) template.FuncMap {
+	return template.FuncMap{
+		"get": func() (string, error) {
+			value, err := c.call(render.RequestContext())
+			render.AddSource("default", c.source, "1")
+			render.Refresh = time.Unix(1, 0)
+			return value, err
+		},
+	}
+}
+
+func (c *fakeClient) GetSecret(ctx context.Context, _ string, _ string) (string, error) {
+	value, err := c.call(ctx)
+	RenderFromContext(ctx).AddSource("default", c.source, "1")
+	return value, err
+}
+
+func (c *fakeClient) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+func TestFailoverMergesOnlyTheWinner(t *testing.T) {
+	stopped := make(chan struct{})
+	candidates := []FailoverCandidate{
+		{
+			Profile: "default/primary",
+			Timeout: 20 * time.Millisecond,
+			Client: &fakeClient{source: "primary", call: func(ctx context.Context) (string, error) {
+				// blocks until the failover cancels the call
+				<-ctx.Done()
+				defer close(stopped)
+				return "", ctx.Err()
+			}},
+		},
+		{
+			Profile: "default/failing",
+			Client: &fakeClient{source: "failing", call: func(ctx context.Context) (string, error) {
+				return "", errors.New("unavailable")
+			}},
+		},
+		{
+			Profile: "default/fallback",
+			Client: &fakeClient{source: "fallback", call: func(ctx context.Context) (string, error) {
+				return "value", nil
+			}},
+		},
+	}
+	client := NewFailoverClient("memory", candidates)
+
+	tests := []struct {
+		name string
+		get  func(render *Render) (string, error)
+	}{
+		{name: "template function", get: func(render *Render) (string, error) {
+			return client.FuncMap(render)["get"].(func() (string, error))()
+		}},
+		{name: "GetSecret", get: func(render *Render) (string, error) {
+			return client.GetSecret(WithRender(render.RequestContext(), render), "name", "")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stopped = make(chan struct{})
+			render := &Render{Context: context.Background()}
+			value, err := test.get(render)
+			if err != nil || value != "value" {
+				t.Fatalf("got %q, %v, want value", value, err)
+			}
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the context of the timed out call was not cancelled")
+			}
+
+			if sources := render.Sources(); !reflect.DeepEqual(sources, []string{"default/fallback"}) {
+				t.Errorf("sources = %v, want only the source of the fallback", sources)
+			}
+			want := []dockhand.BackendStatus{{Profile: "default/primary", Backend: "memory", ServedBy: "default/fallback"}}
+			if servedBy := render.ServedBy(); !reflect.DeepEqual(servedBy, want) {
+				t.Errorf("servedBy = %v, want %v", servedBy, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	Name = "file"
+
+	defaultCacheTTL = 60 * time.Second
+)
+
+var provider = &Provider{}
+
+func init() {
+	providers.Register(provider)
+}
+
+// SetBaseDir sets the directory of the operator that the paths of Profiles are resolved in, paths are rejected when
+// baseDir is empty
+func SetBaseDir(baseDir string) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.baseDir = baseDir
+}
+
+// Provider serves secrets from a YAML or JSON document held in a ConfigMap or a file mounted in the operator. The
+// document maps secret names to either a string or an object of keys.
+type Provider struct {
+	baseDir string
+	mutex   sync.RWMutex
+}
+
+type Client struct {
+	load     func() ([]byte, error)
+	cacheTTL time.Duration
+	document map[string]interface{}
+	loadedAt time.Time
+	mutex    sync.Mutex
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.File != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.File
+	if config.CacheTTL != "" {
+		if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+			return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+		}
+	}
+	if (config.ConfigMapRef == nil) == (config.Path == "") {
+		return fmt.Errorf("%s requires exactly one of configMapRef or path", Name)
+	}
+	if config.ConfigMapRef != nil && (config.ConfigMapRef.Name == "" || config.ConfigMapRef.Key == "") {
+		return fmt.Errorf("%s.configMapRef requires name and key", Name)
+	}
+	if config.Path != "" {
+		if _, err := p.resolvePath(config.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePath returns path within the base directory, relative paths are relative to the base directory
+func (p *Provider) resolvePath(path string) (string, error) {
+	p.mutex.RLock()
+	baseDir := p.baseDir
+	p.mutex.RUnlock()
+	if baseDir == "" {
+		return "", fmt.Errorf("%s.path is disabled, the controller requires --file-provider-base-dir", Name)
+	}
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	path = filepath.Clean(path)
+	if !withinDir(baseDir, path) {
+		return "", fmt.Errorf("%s.path %s is outside of %s", Name, path, baseDir)
+	}
+	return path, nil
+}
+
+// readFile reads path after resolving its symlinks, which must not leave the base directory
+func (p *Provider) readFile(path string) ([]byte, error) {
+	path, err := p.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.RLock()
+	baseDir := p.baseDir
+	p.mutex.RUnlock()
+	baseDir, err = filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	baseDir, err = filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if !withinDir(baseDir, target) {
+		return nil, fmt.Errorf("%s.path %s links outside of %s", Name, path, baseDir)
+	}
+	return os.ReadFile(target)
+}
+
+// withinDir reports whether the clean absolute path is dir or a descendant of dir
+func withinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (p *Provider) NewClient(_ context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.File
+	client := &Client{cacheTTL: defaultCacheTTL}
+	if config.CacheTTL != "" {
+		cacheTTL, err := time.ParseDuration(config.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		client.cacheTTL = cacheTTL
+	}
+
+	if config.ConfigMapRef != nil {
+		namespace := profile.Namespace
+		ref := *config.ConfigMapRef
+		client.load = func() ([]byte, error) {
+			configMap, err := reader.GetConfigMap(namespace, ref.Name)
+			if err != nil {
+				return nil, err
+			}
+			data, ok := configMap.Data[ref.Key]
+			if !ok {
+				return nil, fmt.Errorf("configmap %s/%s does not contain %s", namespace, ref.Name, ref.Key)
+			}
+			return []byte(data), nil
+		}
+	} else {
+		path := config.Path
+		client.load = func() ([]byte, error) {
+			return p.readFile(path)
+		}
+	}
+	return client, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"fileJson", "fileText"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.File == nil {
+		return "", false
+	}
+	return source.File.Name, true
+}
+
+func (c *Client) FuncMap(_ *providers.Render) template.FuncMap {
+	return template.FuncMap{
+		"fileJson": c.getJSONSecret,
+		"fileText": c.getTextSecret,
+	}
+}
+
+// GetSecret returns the secret name as text, versions are not supported
+func (c *Client) GetSecret(_ context.Context, name string, version string) (string, error) {
+	if version != "" && version != "latest" {
+		return "", fmt.Errorf("%s provider does not support secret versions", Name)
+	}
+	return c.getTextSecret(name)
+}
+
+func (c *Client) HealthCheck(_ context.Context) error {
+	_, err := c.getDocument()
+	return err
+}
+
+// getTextSecret returns a string secret as is and an object secret as json
+func (c *Client) getTextSecret(name string) (string, error) {
+	value, err := c.getValue(name)
+	if err != nil {
+		return "", err
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(value)
+	return string(text), err
+}
+
+// getJSONSecret returns key of an object secret, or of a string secret containing a json object
+func (c *Client) getJSONSecret(name string, key string) (string, error) {
+	value, err := c.getValue(name)
+	if err != nil {
+		return "", err
+	}
+	if text, ok := value.(string); ok {
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return "", fmt.Errorf("%s secret %s is not a json object: %v", Name, name, err)
+		}
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("%s secret %s is not an object", Name, name)
+	}
+	keyValue, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("%s secret %s does not contain %s", Name, name, key)
+	}
+	if text, ok := keyValue.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(keyValue)
+	return string(text), err
+}
+
+func (c *Client) getValue(name string) (interface{}, error) {
+	name, _ = providers.SplitVersion(name)
+	document, err := c.getDocument()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := document[name]
+	if !ok {
+		return nil, fmt.Errorf("%s secret %s not found", Name, name)
+	}
+	return value, nil
+}
+
+// getDocument returns the parsed document, loading it again once cacheTTL has passed
+func (c *Client) getDocument() (map[string]interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.document != nil && time.Since(c.loadedAt) < c.cacheTTL {
+		return c.document, nil
+	}
+	data, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	document := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("could not parse %s provider document: %v", Name, err)
+	}
+	c.document = document
+	c.loadedAt = time.Now()
+	return document, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+)
+
+func TestPathIsRestrictedToBaseDir(t *testing.T) {
+	root := t.TempDir()
+	baseDir := filepath.Join(root, "secrets")
+	if err := os.Mkdir(baseDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(baseDir, "secrets.yaml"), "db:\n  password: dev-password\n")
+	writeFile(t, filepath.Join(root, "outside.yaml"), "db:\n  password: outside\n")
+	if err := os.Symlink(filepath.Join(root, "outside.yaml"), filepath.Join(baseDir, "escape.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(baseDir, "secrets.yaml"), filepath.Join(baseDir, "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		baseDir string
+		path    string
+		wantErr string
+	}{
+		{name: "relative", baseDir: baseDir, path: "secrets.yaml"},
+		{name: "absolute", baseDir: baseDir, path: filepath.Join(baseDir, "secrets.yaml")},
+		{name: "symlink within", baseDir: baseDir, path: "link.yaml"},
+		{name: "disabled", path: filepath.Join(baseDir, "secrets.yaml"), wantErr: "--file-provider-base-dir"},
+		{name: "parent", baseDir: baseDir, path: "../outside.yaml", wantErr: "outside of"},
+		{name: "absolute outside", baseDir: baseDir, path: filepath.Join(root, "outside.yaml"), wantErr: "outside of"},
+		{name: "symlink escape", baseDir: baseDir, path: "escape.yaml", wantErr: "links outside of"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			password, err := readPassword(&Provider{baseDir: test.baseDir}, test.path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading %s: %v", test.path, err)
+			}
+			if password != "dev-password" {
+				t.Errorf("password = %s, want dev-password", password)
+			}
+		})
+	}
+}
+
+// readPassword reads the password of db from the document at path, as the controller would
+func readPassword(p *Provider, path string) (string, error) {
+	profile := &dockhand.Profile{Spec: dockhand.ProfileSpec{File: &dockhand.File{Path: path}}}
+	if err := p.ValidateConfig(profile); err != nil {
+		return "", err
+	}
+	client, err := p.NewClient(context.Background(), profile, nil)
+	if err != nil {
+		return "", err
+	}
+	return client.(*Client).getJSONSecret("db", "password")
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"reflect"
+	"text/template"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var stringType = reflect.TypeOf("")
+
+// InstrumentFuncMap returns funcMap with every function of backend of profile wrapped in a span, a child of the
+// Context of render. The strings the functions return are recorded in render with AddValue, so that the values a
+// backend returned can be redacted from the errors of later template calls that received them.
+func InstrumentFuncMap(render *Render, backend string, profile string, funcMap template.FuncMap) template.FuncMap {
+	instrumented := make(template.FuncMap, len(funcMap))
+	for name, function := range funcMap {
+		instrumented[name] = instrumentFunc(render, backend+"."+name, function, []attribute.KeyValue{
+			tracing.AttributeBackend.String(backend),
+			tracing.AttributeProfile.String(profile),
+			tracing.AttributeFunction.String(name),
+		})
+	}
+	return instrumented
+}
+
+// instrumentFunc returns a function of the type of function that calls it in the span spanName. The Context of render
+// is the context of the span during the call, so that the spans of the backend calls are its children.
+func instrumentFunc(render *Render, spanName string, function interface{}, attributes []attribute.KeyValue) interface{} {
+	fn := reflect.ValueOf(function)
+	fnType := fn.Type()
+	if fnType.Kind() != reflect.Func || render == nil {
+		return function
+	}
+	return reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+		ctx := render.Context
+		spanCtx, span := tracing.Start(render.RequestContext(), spanName, attributes...)
+		render.Context = spanCtx
+		defer func() {
+			render.Context = ctx
+		}()
+
+		var results []reflect.Value
+		if fnType.IsVariadic() {
+			results = fn.CallSlice(args)
+		} else {
+			results = fn.Call(args)
+		}
+		var err error
+		for _, result := range results {
+			switch {
+			case result.Type() == stringType:
+				render.AddValue(result.String())
+			case result.Type() == errorType && !result.IsNil():
+				err = result.Interface().(error)
+			}
+		}
+		tracing.End(span, err)
+		return results
+	}).Interface()
+}
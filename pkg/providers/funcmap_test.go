@@ -0,0 +1,79 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentFuncMap(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracing.NewTracerProvider("test", sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	client := NewFailoverClient("memory", []FailoverCandidate{
+		{Profile: "default/primary", Client: &fakeClient{source: "primary", call: func(ctx context.Context) (string, error) {
+			return "", errors.New("unavailable")
+		}}},
+		{Profile: "default/fallback", Client: &fakeClient{source: "fallback", call: func(ctx context.Context) (string, error) {
+			return "s3cr3t", nil
+		}}},
+	})
+	ctx, reconcile := tracing.Start(context.Background(), "reconcile")
+	render := &Render{Context: ctx}
+	get := InstrumentFuncMap(render, "memory", "default/primary", client.FuncMap(render))["get"].(func() (string, error))
+	if value, err := get(); err != nil || value != "s3cr3t" {
+		t.Fatalf("got %q, %v, want s3cr3t", value, err)
+	}
+	reconcile.End()
+
+	if render.Context != ctx {
+		t.Error("the context of the render was not restored after the call")
+	}
+	if _, ok := render.Values()["s3cr3t"]; !ok {
+		t.Errorf("values = %v, want the returned value recorded", render.Values())
+	}
+
+	// the spans end in order: the candidates, the function and the reconcile
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	primary, fallback, function := spans[0], spans[1], spans[2]
+	if function.Name != "memory.get" || function.Parent.SpanID() != reconcile.SpanContext().SpanID() {
+		t.Errorf("function span %s has parent %s, want memory.get of the reconcile", function.Name, function.Parent.SpanID())
+	}
+	for _, candidate := range []tracetest.SpanStub{primary, fallback} {
+		if candidate.Parent.SpanID() != function.SpanContext.SpanID() {
+			t.Errorf("candidate span %s has parent %s, want the function span", candidate.Name, candidate.Parent.SpanID())
+		}
+	}
+	if primary.Status.Code != codes.Error || fallback.Status.Code == codes.Error || function.Status.Code == codes.Error {
+		t.Errorf("statuses = %v %v %v, want only the primary candidate failed", primary.Status.Code, fallback.Status.Code, function.Status.Code)
+	}
+}
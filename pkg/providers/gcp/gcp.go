@@ -0,0 +1,263 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"text/template"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	Name = "gcpSecretsManager"
+
+	garUsername           = "oauth2accesstoken"
+	gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+var log = common.ComponentLogger("provider." + Name)
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider for GCP Secret Manager and Artifact Registry tokens
+type Provider struct{}
+
+type Client struct {
+	secretManager   *secretmanager.Client
+	cache           *cache.Cache
+	project         string
+	credentialsJson []byte
+	registry        *providers.RegistryTokenSource
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.GcpSecretsManager != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.GcpSecretsManager
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+	}
+	if config.Project == "" {
+		return fmt.Errorf("%s.project is required", Name)
+	}
+	return providers.ValidateSecretRef(Name+".credentialsFileSecretRef", config.CredentialsFileSecretRef)
+}
+
+func (p *Provider) NewClient(ctx context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.GcpSecretsManager
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsJson, err := providers.SecretRefValue(reader, profile.Namespace, config.CredentialsFileSecretRef)
+	if err != nil {
+		return nil, err
+	}
+	var opts []option.ClientOption
+	if config.CredentialsFileSecretRef != nil {
+		opts = append(opts, option.WithCredentialsJSON([]byte(credentialsJson)))
+	}
+	secretManager, err := secretmanager.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{
+		secretManager:   secretManager,
+		cache:           cache.New(cacheTTL, cacheTTL),
+		project:         config.Project,
+		credentialsJson: []byte(credentialsJson),
+	}
+	client.registry = providers.NewRegistryTokenSource(client.mintGarToken)
+	return client, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"gcpJson", "gcpText", "garToken", "garDockerConfigJson"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.GcpSecretsManager == nil {
+		return "", false
+	}
+	return source.GcpSecretsManager.Name, true
+}
+
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	ctx := providers.WithRender(render.RequestContext(), render)
+	return template.FuncMap{
+		"gcpJson": func(name string, key string) (string, error) {
+			return c.getJSONSecret(ctx, name, key)
+		},
+		"gcpText": func(name string) (string, error) {
+			return c.getTextSecret(ctx, name)
+		},
+		"garToken":            providers.RegistryTokenFunc(ctx, c.registry, &render.Refresh),
+		"garDockerConfigJson": providers.RegistryDockerConfigJsonFunc(ctx, c.registry, garUsername, &render.Refresh),
+	}
+}
+
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	return c.getTextSecret(ctx, providers.WithVersion(name, version))
+}
+
+// getJSONSecret returns key of the json object stored in secret name, optionally suffixed with ?version=
+func (c *Client) getJSONSecret(ctx context.Context, name string, key string) (string, error) {
+	value, err := c.getTextSecret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return providers.JSONKey(Name, name, value, key)
+}
+
+// getTextSecret returns the payload of secret name, optionally suffixed with ?version=. The latest version is returned
+// without a version.
+func (c *Client) getTextSecret(ctx context.Context, name string) (string, error) {
+	secretName, version := providers.SplitVersion(name)
+	if version == "" {
+		version = "latest"
+	}
+	versionName := c.secretName(secretName) + "/versions/" + version
+	if value, ok := c.cache.Get(versionName); ok {
+		providers.RenderFromContext(ctx).Log(log).Debugf("using cached %s secret [%s]", Name, versionName)
+		return value.(string), nil
+	}
+
+	result, err := c.secretManager.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: versionName})
+	if err != nil {
+		return "", fmt.Errorf("secret{%s}: %v", versionName, err)
+	}
+	value := string(result.GetPayload().GetData())
+	c.cache.SetDefault(versionName, value)
+	return value, nil
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
+	tokenSource, err := c.tokenSource(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tokenSource.Token()
+	return err
+}
+
+// SecretVersion returns the number of the latest version of secret name
+func (c *Client) SecretVersion(ctx context.Context, name string) (string, error) {
+	version, err := c.secretManager.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
+		Name: c.secretName(name) + "/versions/latest",
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return path.Base(version.Name), nil
+}
+
+// PutSecret adds value as a new version of secret name, the secret is created with automatic replication when it
+// does not exist
+func (c *Client) PutSecret(ctx context.Context, name string, value string) (string, error) {
+	_, err := c.secretManager.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+		Parent:   "projects/" + c.project,
+		SecretId: name,
+		Secret: &secretmanagerpb.Secret{
+			Replication: &secretmanagerpb.Replication{
+				Replication: &secretmanagerpb.Replication_Automatic_{Automatic: &secretmanagerpb.Replication_Automatic{}},
+			},
+		},
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return "", err
+	}
+	version, err := c.secretManager.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  c.secretName(name),
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(value)},
+	})
+	if err != nil {
+		return "", err
+	}
+	return path.Base(version.Name), nil
+}
+
+// DeleteSecret deletes secret name and all of its versions
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	err := c.secretManager.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: c.secretName(name)})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) secretName(name string) string {
+	return "projects/" + c.project + "/secrets/" + name
+}
+
+// tokenSource returns the token source of the profile credentials, or the default credentials when none are set.
+func (c *Client) tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	var creds *google.Credentials
+	var err error
+	if len(c.credentialsJson) > 0 {
+		creds, err = google.CredentialsFromJSON(ctx, c.credentialsJson, gcpCloudPlatformScope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, gcpCloudPlatformScope)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource, nil
+}
+
+// mintGarToken mints Google OAuth2 access tokens for Artifact Registry and Container Registry.
+func (c *Client) mintGarToken(ctx context.Context, _ string) (*providers.RegistryToken, error) {
+	tokenSource, err := c.tokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	expiry := token.Expiry
+	if expiry.IsZero() {
+		expiry = time.Now().Add(time.Hour)
+	}
+	return &providers.RegistryToken{Password: token.AccessToken, Expiry: expiry}, nil
+}
@@ -0,0 +1,353 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpjson
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	Name = "http"
+
+	requestTimeout  = 30 * time.Second
+	maxResponseSize = 1 << 20
+)
+
+var logger = common.ComponentLogger("provider." + Name)
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider retrieves secrets from HTTPS JSON APIs, authenticating with headers, a bearer token or a client certificate
+type Provider struct{}
+
+type Client struct {
+	http     *http.Client
+	baseURL  string
+	selector string
+	headers  http.Header
+	cache    *cache.Cache
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.Http != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.Http
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%s.url must be an absolute http or https url", Name)
+	}
+	if u.Scheme != "https" && authenticated(config) {
+		return fmt.Errorf("%s.url must be https when bearerTokenRef, headers with a valueRef or a client certificate are set", Name)
+	}
+	if config.Selector != "" {
+		if _, err := parseSelector(config.Selector); err != nil {
+			return fmt.Errorf("%s.selector: %v", Name, err)
+		}
+	}
+	for _, header := range config.Headers {
+		if header.Name == "" {
+			return fmt.Errorf("%s.headers require a name", Name)
+		}
+		if err := providers.ValidateSecretRef(Name+".headers.valueRef", header.ValueRef); err != nil {
+			return err
+		}
+	}
+	if (config.ClientCertificateRef == nil) != (config.ClientKeyRef == nil) {
+		return fmt.Errorf("%s requires both clientCertificateRef and clientKeyRef", Name)
+	}
+	for field, ref := range map[string]*dockhand.SecretRef{
+		"bearerTokenRef":       config.BearerTokenRef,
+		"clientCertificateRef": config.ClientCertificateRef,
+		"clientKeyRef":         config.ClientKeyRef,
+	} {
+		if err := providers.ValidateSecretRef(Name+"."+field, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticated reports whether config sends credentials, which must not be sent in plaintext
+func authenticated(config *dockhand.Http) bool {
+	if config.BearerTokenRef != nil || config.ClientCertificateRef != nil {
+		return true
+	}
+	for _, header := range config.Headers {
+		if header.ValueRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) NewClient(_ context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.Http
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	headers.Set("Accept", "application/json")
+	for _, header := range config.Headers {
+		value := header.Value
+		if header.ValueRef != nil {
+			if value, err = providers.SecretRefValue(reader, profile.Namespace, header.ValueRef); err != nil {
+				return nil, err
+			}
+		}
+		headers.Set(header.Name, value)
+	}
+	if config.BearerTokenRef != nil {
+		token, err := providers.SecretRefValue(reader, profile.Namespace, config.BearerTokenRef)
+		if err != nil {
+			return nil, err
+		}
+		headers.Set("Authorization", "Bearer "+strings.TrimSpace(token))
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CABundle != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(config.CABundle)) {
+			return nil, fmt.Errorf("%s.caBundle does not contain a pem certificate", Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCertificateRef != nil {
+		certificate, err := providers.SecretRefValue(reader, profile.Namespace, config.ClientCertificateRef)
+		if err != nil {
+			return nil, err
+		}
+		key, err := providers.SecretRefValue(reader, profile.Namespace, config.ClientKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		keyPair, err := tls.X509KeyPair([]byte(certificate), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s client certificate: %v", Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{
+		http: &http.Client{
+			Transport:     transport,
+			Timeout:       requestTimeout,
+			CheckRedirect: checkRedirect(authenticated(config)),
+		},
+		baseURL:  strings.TrimSuffix(config.URL, "/"),
+		selector: config.Selector,
+		headers:  headers,
+		cache:    cache.New(cacheTTL, cacheTTL),
+	}, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"httpJson", "httpText"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.Http == nil {
+		return "", false
+	}
+	return source.Http.Name, true
+}
+
+// FuncMap returns the template functions of the client, secrets are requested with the context of render so that the
+// requests end with the reconcile
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	ctx := providers.WithRender(render.RequestContext(), render)
+	return template.FuncMap{
+		"httpJson": func(path string, key string) (string, error) {
+			return c.getJSONSecret(ctx, path, key)
+		},
+		"httpText": func(path string) (string, error) {
+			return c.getTextSecret(ctx, path)
+		},
+	}
+}
+
+// GetSecret returns the secret at path name relative to the url of the profile, versions are not supported
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	if version != "" && version != "latest" {
+		return "", fmt.Errorf("%s provider does not support secret versions", Name)
+	}
+	return c.getTextSecret(ctx, name)
+}
+
+// HealthCheck verifies that the url of the profile responds, regardless of the status returned
+func (c *Client) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.headers.Clone()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// getTextSecret returns the selected value of the response for path, objects are returned as json
+func (c *Client) getTextSecret(ctx context.Context, path string) (string, error) {
+	value, err := c.getSecret(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return stringify(value)
+}
+
+// getJSONSecret returns key of the selected object of the response for path
+func (c *Client) getJSONSecret(ctx context.Context, path string, key string) (string, error) {
+	value, err := c.getSecret(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("%s secret %s is not an object", Name, path)
+	}
+	keyValue, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("%s secret %s does not contain %s", Name, path, key)
+	}
+	return stringify(keyValue)
+}
+
+// getSecret requests path and returns the value located by the selector of the profile
+func (c *Client) getSecret(ctx context.Context, path string) (interface{}, error) {
+	log := providers.RenderFromContext(ctx).Log(logger)
+	secretURL := c.baseURL + "/" + strings.TrimPrefix(path, "/")
+	if value, ok := c.cache.Get(secretURL); ok {
+		log.Debugf("using cached %s secret [%s]", Name, secretURL)
+		return value, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.headers.Clone()
+	log.Debugf("retrieving [%s] from %s provider", secretURL, Name)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s secret %s returned status %s", Name, path, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%s secret %s response exceeds %d bytes", Name, path, maxResponseSize)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("%s secret %s is not json: %v", Name, path, err)
+	}
+	if c.selector != "" {
+		// a JSONPath can not be shared between goroutines, so the selector is parsed for each request
+		selector, err := parseSelector(c.selector)
+		if err != nil {
+			return nil, err
+		}
+		results, err := selector.FindResults(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s secret %s: %v", Name, path, err)
+		}
+		if len(results) == 0 || len(results[0]) == 0 {
+			return nil, fmt.Errorf("%s secret %s: selector did not match", Name, path)
+		}
+		value = results[0][0].Interface()
+	}
+	c.cache.SetDefault(secretURL, value)
+	return value, nil
+}
+
+// checkRedirect refuses redirects from https to http, which would send the headers of the profile in plaintext. When
+// the profile sends credentials it also refuses redirects to another host, the client certificate and the headers of
+// the profile other than Authorization would be sent to it.
+func checkRedirect(credentials bool) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if via[0].URL.Scheme == "https" && req.URL.Scheme != "https" {
+			return fmt.Errorf("%s refused redirect from https to %s", Name, req.URL.Redacted())
+		}
+		if credentials && req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("%s refused redirect with credentials to another host %s", Name, req.URL.Redacted())
+		}
+		return nil
+	}
+}
+
+func parseSelector(selector string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(selector, "{") {
+		return nil, errors.New("selector must be a JSONPath expression e.g. {.data}")
+	}
+	path := jsonpath.New(Name)
+	if err := path.Parse(selector); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// stringify returns strings as is and any other json value as json
+func stringify(value interface{}) (string, error) {
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(value)
+	return string(text), err
+}
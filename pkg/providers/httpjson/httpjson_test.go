@@ -0,0 +1,351 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpjson
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const token = "s3cr3t-token"
+
+// fakeReader serves the credentials Secret of the profile
+type fakeReader struct {
+	data map[string][]byte
+}
+
+func (r fakeReader) GetSecret(namespace, name string) (*corev1.Secret, error) {
+	if name != "http-credentials" {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"},
+		Data:       r.data,
+	}, nil
+}
+
+func (r fakeReader) GetConfigMap(_, _ string) (*corev1.ConfigMap, error) {
+	return nil, nil
+}
+
+// newClientCertificate returns a self signed client certificate and key in pem
+func newClientCertificate(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dockhand"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newServer starts a TLS server that requires certificate and the bearer token, and counts the requests it serves
+func newServer(t *testing.T, certificate *x509.Certificate, requests *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Tenant") != "app" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secrets/db":
+			_, _ = w.Write([]byte(`{"data": {"username": "app", "password": "p@ssw0rd"}}`))
+		case "/v1/secrets/token":
+			_, _ = w.Write([]byte(`{"data": "api-token"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newProfile(url string, caBundle string) *dockhand.Profile {
+	return &dockhand.Profile{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "http"},
+		Spec: dockhand.ProfileSpec{Http: &dockhand.Http{
+			CacheTTL: "1m",
+			URL:      url + "/v1",
+			Selector: "{.data}",
+			Headers: []dockhand.HttpHeader{
+				{Name: "X-Tenant", Value: "app"},
+			},
+			BearerTokenRef:       &dockhand.SecretRef{Name: "http-credentials", Key: "token"},
+			ClientCertificateRef: &dockhand.SecretRef{Name: "http-credentials", Key: "tls.crt"},
+			ClientKeyRef:         &dockhand.SecretRef{Name: "http-credentials", Key: "tls.key"},
+			CABundle:             caBundle,
+		}},
+	}
+}
+
+func TestTemplateFunctions(t *testing.T) {
+	certificate, certificatePem, keyPem := newClientCertificate(t)
+	var requests int32
+	server := newServer(t, certificate, &requests)
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	reader := fakeReader{data: map[string][]byte{
+		"token":   []byte(token + "\n"),
+		"tls.crt": certificatePem,
+		"tls.key": keyPem,
+	}}
+
+	provider := &Provider{}
+	profile := newProfile(server.URL, caBundle)
+	if err := provider.ValidateConfig(profile); err != nil {
+		t.Fatalf("ValidateConfig: %v", err)
+	}
+	client, err := provider.NewClient(context.Background(), profile, reader)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	funcs := client.FuncMap(&providers.Render{Context: context.Background()})
+	httpJson := funcs["httpJson"].(func(string, string) (string, error))
+	httpText := funcs["httpText"].(func(string) (string, error))
+
+	tests := []struct {
+		name    string
+		get     func() (string, error)
+		want    string
+		wantErr string
+	}{
+		{name: "json key", get: func() (string, error) { return httpJson("secrets/db", "password") }, want: "p@ssw0rd"},
+		{name: "text", get: func() (string, error) { return httpText("/secrets/token") }, want: "api-token"},
+		{name: "object as json", get: func() (string, error) { return httpText("secrets/db") }, want: `{"password":"p@ssw0rd","username":"app"}`},
+		{name: "missing key", get: func() (string, error) { return httpJson("secrets/db", "host") }, wantErr: "host"},
+		{name: "missing secret", get: func() (string, error) { return httpText("secrets/missing") }, wantErr: "404"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.get()
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// secrets/db and secrets/token are served from the cache within cacheTTL
+	atomic.StoreInt32(&requests, 0)
+	if _, err := httpJson("secrets/db", "username"); err != nil {
+		t.Fatalf("httpJson: %v", err)
+	}
+	if _, err := client.GetSecret(context.Background(), "secrets/token", ""); err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("cached secrets made %d requests, want 0", got)
+	}
+}
+
+func TestTLSIsVerified(t *testing.T) {
+	certificate, certificatePem, keyPem := newClientCertificate(t)
+	var requests int32
+	server := newServer(t, certificate, &requests)
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	_, otherCertificatePem, otherKeyPem := newClientCertificate(t)
+
+	tests := []struct {
+		name     string
+		caBundle string
+		data     map[string][]byte
+		noClient bool
+	}{
+		{name: "server certificate not trusted", data: map[string][]byte{"token": []byte(token), "tls.crt": certificatePem, "tls.key": keyPem}},
+		{name: "client certificate not trusted", caBundle: caBundle, data: map[string][]byte{"token": []byte(token), "tls.crt": otherCertificatePem, "tls.key": otherKeyPem}},
+		{name: "no client certificate", caBundle: caBundle, data: map[string][]byte{"token": []byte(token)}, noClient: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile := newProfile(server.URL, test.caBundle)
+			if test.noClient {
+				profile.Spec.Http.ClientCertificateRef = nil
+				profile.Spec.Http.ClientKeyRef = nil
+			}
+			client, err := (&Provider{}).NewClient(context.Background(), profile, fakeReader{data: test.data})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			atomic.StoreInt32(&requests, 0)
+			if _, err := client.GetSecret(context.Background(), "secrets/token", ""); err == nil {
+				t.Error("expected a tls error")
+			}
+			if got := atomic.LoadInt32(&requests); got != 0 {
+				t.Errorf("server handled %d requests, want 0", got)
+			}
+		})
+	}
+}
+
+func TestCredentialsRequireHttps(t *testing.T) {
+	secretRef := &dockhand.SecretRef{Name: "http-credentials", Key: "token"}
+	tests := []struct {
+		name    string
+		config  dockhand.Http
+		wantErr bool
+	}{
+		{name: "http without credentials", config: dockhand.Http{URL: "http://secrets.local/v1", Headers: []dockhand.HttpHeader{{Name: "X-Tenant", Value: "app"}}}},
+		{name: "https with bearer token", config: dockhand.Http{URL: "https://secrets.local/v1", BearerTokenRef: secretRef}},
+		{name: "http with bearer token", config: dockhand.Http{URL: "http://secrets.local/v1", BearerTokenRef: secretRef}, wantErr: true},
+		{name: "http with header secret", config: dockhand.Http{URL: "http://secrets.local/v1", Headers: []dockhand.HttpHeader{{Name: "X-Api-Key", ValueRef: secretRef}}}, wantErr: true},
+		{name: "http with client certificate", config: dockhand.Http{URL: "http://secrets.local/v1", ClientCertificateRef: secretRef, ClientKeyRef: secretRef}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.CacheTTL = "1m"
+			err := (&Provider{}).ValidateConfig(&dockhand.Profile{Spec: dockhand.ProfileSpec{Http: &test.config}})
+			if test.wantErr && (err == nil || !strings.Contains(err.Error(), "must be https")) {
+				t.Errorf("error = %v, want https required", err)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRedirectToHttpIsRefused(t *testing.T) {
+	var plaintext int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&plaintext, 1)
+	}))
+	t.Cleanup(target.Close)
+	server := httptest.NewTLSServer(http.RedirectHandler(target.URL+"/v1/secrets/token", http.StatusFound))
+	t.Cleanup(server.Close)
+
+	profile := &dockhand.Profile{Spec: dockhand.ProfileSpec{Http: &dockhand.Http{
+		CacheTTL:       "1m",
+		URL:            server.URL + "/v1",
+		BearerTokenRef: &dockhand.SecretRef{Name: "http-credentials", Key: "token"},
+		CABundle:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+	}}}
+	client, err := (&Provider{}).NewClient(context.Background(), profile, fakeReader{data: map[string][]byte{"token": []byte(token)}})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := client.GetSecret(context.Background(), "secrets/token", ""); err == nil || !strings.Contains(err.Error(), "refused redirect") {
+		t.Errorf("error = %v, want refused redirect", err)
+	}
+	if got := atomic.LoadInt32(&plaintext); got != 0 {
+		t.Errorf("http server handled %d requests, want 0", got)
+	}
+}
+
+func TestRedirectToAnotherHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      []dockhand.HttpHeader
+		wantRedirect bool
+	}{
+		{name: "credentials", headers: []dockhand.HttpHeader{{Name: "X-Api-Key", ValueRef: &dockhand.SecretRef{Name: "http-credentials", Key: "token"}}}},
+		{name: "no credentials", headers: []dockhand.HttpHeader{{Name: "X-Tenant", Value: "app"}}, wantRedirect: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var apiKeys []string
+			target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				apiKeys = append(apiKeys, r.Header.Get("X-Api-Key"))
+				_, _ = w.Write([]byte(`{"value": "s3cr3t"}`))
+			}))
+			t.Cleanup(target.Close)
+			// the port of the target makes it another host
+			server := httptest.NewTLSServer(http.RedirectHandler(target.URL+"/v1/secrets/token", http.StatusFound))
+			t.Cleanup(server.Close)
+
+			profile := &dockhand.Profile{Spec: dockhand.ProfileSpec{Http: &dockhand.Http{
+				CacheTTL: "1m",
+				URL:      server.URL + "/v1",
+				Headers:  test.headers,
+				CABundle: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+			}}}
+			client, err := (&Provider{}).NewClient(context.Background(), profile, fakeReader{data: map[string][]byte{"token": []byte(token)}})
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			_, err = client.GetSecret(context.Background(), "secrets/token", "")
+			if test.wantRedirect {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "refused redirect with credentials to another host") {
+				t.Errorf("error = %v, want refused redirect", err)
+			}
+			if len(apiKeys) != 0 {
+				t.Errorf("other host received the api keys %v", apiKeys)
+			}
+		})
+	}
+}
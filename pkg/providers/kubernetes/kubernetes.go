@@ -0,0 +1,180 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/template"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/rancher/wrangler/v3/pkg/kv"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const Name = "kubernetes"
+
+var provider = &Provider{}
+
+func init() {
+	providers.Register(provider)
+}
+
+// SetNamespaceAccess sets the namespaces other than their own that Profiles can read Secrets from. Profiles can read
+// from any namespace when allowCrossNamespace is set, and only from their own namespace and namespaces otherwise.
+func SetNamespaceAccess(allowCrossNamespace bool, namespaces []string) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.allowCrossNamespace = allowCrossNamespace
+	provider.namespaces = make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		provider.namespaces[namespace] = true
+	}
+}
+
+// Provider serves the data of kubernetes Secrets, typically to mirror a Secret from one namespace into others. Secrets
+// are read from the cache of the operator and the Dockhand Secrets reading them are synced again when they change.
+type Provider struct {
+	allowCrossNamespace bool
+	namespaces          map[string]bool
+	mutex               sync.RWMutex
+}
+
+type Client struct {
+	provider   *Provider
+	reader     providers.Reader
+	namespace  string
+	namespaces map[string]bool
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.Kubernetes != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	for _, namespace := range profile.Spec.Kubernetes.Namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("%s.namespaces: %s %v", Name, namespace, errs)
+		}
+		if !p.allowed(profile.Namespace, namespace) {
+			return fmt.Errorf("%s.namespaces: the operator does not allow reading secrets from namespace %s", Name, namespace)
+		}
+	}
+	return nil
+}
+
+// allowed reports whether a Profile in profileNamespace may read the Secrets of namespace
+func (p *Provider) allowed(profileNamespace string, namespace string) bool {
+	if namespace == profileNamespace {
+		return true
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.allowCrossNamespace || p.namespaces[namespace]
+}
+
+func (p *Provider) NewClient(_ context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	client := &Client{
+		provider:   p,
+		reader:     reader,
+		namespace:  profile.Namespace,
+		namespaces: make(map[string]bool),
+	}
+	namespaces := profile.Spec.Kubernetes.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{profile.Namespace}
+	}
+	for _, namespace := range namespaces {
+		client.namespaces[namespace] = true
+	}
+	return client, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"k8sSecret"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.Kubernetes == nil {
+		return "", false
+	}
+	return source.Kubernetes.Namespace + "/" + source.Kubernetes.Name, true
+}
+
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	return template.FuncMap{
+		"k8sSecret": func(namespace, name, key string) (string, error) {
+			secret, err := c.getSecret(render, namespace, name)
+			if err != nil {
+				return "", err
+			}
+			value, ok := secret.Data[key]
+			if !ok {
+				return "", fmt.Errorf("secret %s/%s does not contain %s", namespace, name, key)
+			}
+			return string(value), nil
+		},
+	}
+}
+
+// GetSecret returns every key of the Secret namespace/name as a json object, versions are not supported
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	if version != "" && version != "latest" {
+		return "", fmt.Errorf("%s provider does not support secret versions", Name)
+	}
+	namespace, name := kv.Split(name, "/")
+	secret, err := c.getSecret(providers.RenderFromContext(ctx), namespace, name)
+	if err != nil {
+		return "", err
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	value, err := json.Marshal(data)
+	return string(value), err
+}
+
+// HealthCheck always succeeds, Secrets are read from the cache of the operator
+func (c *Client) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+// getSecret returns the Secret namespace/name when both the profile and the operator allow reading from namespace.
+// The Secret is recorded in render so that the Dockhand Secret is synced again when it changes.
+func (c *Client) getSecret(render *providers.Render, namespace, name string) (*corev1.Secret, error) {
+	if !c.namespaces[namespace] || !c.provider.allowed(c.namespace, namespace) {
+		return nil, fmt.Errorf("%s provider is not allowed to read secrets from namespace %s", Name, namespace)
+	}
+	secret, err := c.reader.GetSecret(namespace, name)
+	if render != nil {
+		resourceVersion := ""
+		if err == nil {
+			resourceVersion = secret.ResourceVersion
+		}
+		render.AddSource(namespace, name, resourceVersion)
+	}
+	return secret, err
+}
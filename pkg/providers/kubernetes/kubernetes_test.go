@@ -0,0 +1,117 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeReader serves a Secret holding password in every namespace
+type fakeReader struct{}
+
+func (r fakeReader) GetSecret(namespace, name string) (*corev1.Secret, error) {
+	if name != "db" {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte(namespace + "-password")},
+	}, nil
+}
+
+func (r fakeReader) GetConfigMap(_, _ string) (*corev1.ConfigMap, error) {
+	return nil, nil
+}
+
+func TestNamespaceAccess(t *testing.T) {
+	tests := []struct {
+		name                string
+		allowCrossNamespace bool
+		allowedNamespaces   []string
+		profileNamespaces   []string
+		namespace           string
+		wantErr             string
+	}{
+		{name: "own namespace", namespace: "tenant-a"},
+		{name: "own namespace listed", profileNamespaces: []string{"tenant-a"}, namespace: "tenant-a"},
+		{name: "other tenant", profileNamespaces: []string{"tenant-b"}, namespace: "tenant-b", wantErr: "does not allow reading secrets from namespace tenant-b"},
+		{name: "other tenant not listed", namespace: "tenant-b", wantErr: "not allowed to read secrets from namespace tenant-b"},
+		{name: "operator allowlist", allowedNamespaces: []string{"shared"}, profileNamespaces: []string{"tenant-a", "shared"}, namespace: "shared"},
+		{name: "operator allowlist other tenant", allowedNamespaces: []string{"shared"}, profileNamespaces: []string{"tenant-b"}, namespace: "tenant-b", wantErr: "does not allow"},
+		{name: "cross namespace", allowCrossNamespace: true, profileNamespaces: []string{"tenant-b"}, namespace: "tenant-b"},
+		{name: "cross namespace not listed", allowCrossNamespace: true, profileNamespaces: []string{"tenant-b"}, namespace: "tenant-c", wantErr: "not allowed to read secrets from namespace tenant-c"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provider{}
+			p.allowCrossNamespace = test.allowCrossNamespace
+			p.namespaces = make(map[string]bool)
+			for _, namespace := range test.allowedNamespaces {
+				p.namespaces[namespace] = true
+			}
+			profile := &dockhand.Profile{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "mirror"},
+				Spec:       dockhand.ProfileSpec{Kubernetes: &dockhand.Kubernetes{Namespaces: test.profileNamespaces}},
+			}
+
+			value, err := readPassword(p, profile, test.namespace)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading %s/db: %v", test.namespace, err)
+			}
+			if want := test.namespace + "-password"; value != want {
+				t.Errorf("password = %s, want %s", value, want)
+			}
+		})
+	}
+}
+
+// readPassword reads the password of the Secret db in namespace with the k8sSecret function of profile
+func readPassword(p *Provider, profile *dockhand.Profile, namespace string) (string, error) {
+	if err := p.ValidateConfig(profile); err != nil {
+		return "", err
+	}
+	client, err := p.NewClient(context.Background(), profile, fakeReader{})
+	if err != nil {
+		return "", err
+	}
+	render := &providers.Render{}
+	k8sSecret := client.FuncMap(render)["k8sSecret"].(func(string, string, string) (string, error))
+	value, err := k8sSecret(namespace, "db", "password")
+	if err != nil {
+		return "", err
+	}
+	if sources := render.Sources(); len(sources) != 1 || sources[0] != namespace+"/db" {
+		return "", fmt.Errorf("sources = %v, want %s/db", sources, namespace)
+	}
+	return value, nil
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const versionSeparator = "?version="
+
+// Provider is a secrets backend that can be configured in a Profile.
+type Provider interface {
+	// Name of the provider, which is the Profile field that configures it e.g. awsSecretsManager
+	Name() string
+	// Configured reports whether profile configures the provider
+	Configured(profile *dockhand.Profile) bool
+	// ValidateConfig checks the provider configuration of profile
+	ValidateConfig(profile *dockhand.Profile) error
+	// NewClient builds a client from the provider configuration of profile
+	NewClient(ctx context.Context, profile *dockhand.Profile, reader Reader) (Client, error)
+	// FuncNames are the names of the template functions provided by clients of the provider
+	FuncNames() []string
+	// DataFromName returns the name of the secret referenced by source when it references the provider
+	DataFromName(source *dockhand.DataFromSource) (string, bool)
+}
+
+// Client is a Provider configured by a Profile.
+type Client interface {
+	// FuncMap returns the template functions of the client, the earliest refresh time of any short-lived
+	// credentials rendered by the functions is recorded in render
+	FuncMap(render *Render) template.FuncMap
+	// GetSecret returns the text of secret name at version, the latest version is returned when version is empty.
+	// The Render of the Dockhand Secret is available from ctx with RenderFromContext.
+	GetSecret(ctx context.Context, name string, version string) (string, error)
+	// HealthCheck verifies that the backend can be reached with the configured credentials
+	HealthCheck(ctx context.Context) error
+}
+
+// Writer is implemented by the clients of backends that PushSecrets can write to
+type Writer interface {
+	// SecretVersion returns the current version of secret name, or an empty string when it does not exist
+	SecretVersion(ctx context.Context, name string) (string, error)
+	// PutSecret writes value as a new version of secret name, creating it when it does not exist, and returns the
+	// version written
+	PutSecret(ctx context.Context, name string, value string) (string, error)
+	// DeleteSecret deletes secret name, it succeeds when the secret does not exist
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// Reader reads the kubernetes resources referenced by a Profile
+type Reader interface {
+	GetSecret(namespace, name string) (*corev1.Secret, error)
+	GetConfigMap(namespace, name string) (*corev1.ConfigMap, error)
+}
+
+// Registry holds the available providers
+type Registry struct {
+	providers map[string]Provider
+	funcNames map[string]string
+	mutex     sync.RWMutex
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: make(map[string]Provider),
+		funcNames: make(map[string]string),
+	}
+}
+
+// DefaultRegistry holds the providers registered by the provider packages
+var DefaultRegistry = NewRegistry()
+
+// Register adds provider to the DefaultRegistry
+func Register(provider Provider) {
+	DefaultRegistry.Register(provider)
+}
+
+// All returns the providers of the DefaultRegistry
+func All() []Provider {
+	return DefaultRegistry.All()
+}
+
+// Register adds provider to the registry, it panics if the name or a template function of provider is already
+// registered.
+func (r *Registry) Register(provider Provider) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.providers[provider.Name()]; ok {
+		panic(fmt.Sprintf("provider %s is already registered", provider.Name()))
+	}
+	for _, funcName := range provider.FuncNames() {
+		if owner, ok := r.funcNames[funcName]; ok {
+			panic(fmt.Sprintf("template function %s of provider %s is already registered by %s", funcName, provider.Name(), owner))
+		}
+	}
+	for _, funcName := range provider.FuncNames() {
+		r.funcNames[funcName] = provider.Name()
+	}
+	r.providers[provider.Name()] = provider
+}
+
+// Get returns the provider registered as name
+func (r *Registry) Get(name string) (Provider, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// All returns the registered providers ordered by name
+func (r *Registry) All() []Provider {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	providers := make([]Provider, 0, len(r.providers))
+	for _, provider := range r.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name() < providers[j].Name()
+	})
+	return providers
+}
+
+// SecretRefValue returns the value of the key referenced by ref in namespace, or an empty string when ref is nil
+func SecretRefValue(reader Reader, namespace string, ref *dockhand.SecretRef) (string, error) {
+	if ref == nil {
+		return "", nil
+	}
+	secret, err := reader.GetSecret(namespace, ref.Name)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", nil
+	}
+	return string(secret.Data[ref.Key]), nil
+}
+
+var secretRefType = reflect.TypeOf(dockhand.SecretRef{})
+
+// ProfileSecretRefs returns the namespace/name of every kubernetes Secret referenced by the provider configuration of
+// profile, ordered by name
+func ProfileSecretRefs(profile *dockhand.Profile) []string {
+	names := make(map[string]bool)
+	var collect func(v reflect.Value)
+	collect = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if !v.IsNil() {
+				collect(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				collect(v.Index(i))
+			}
+		case reflect.Struct:
+			if v.Type() == secretRefType {
+				if ref := v.Interface().(dockhand.SecretRef); ref.Name != "" {
+					names[profile.Namespace+"/"+ref.Name] = true
+				}
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				collect(v.Field(i))
+			}
+		}
+	}
+	collect(reflect.ValueOf(profile.Spec))
+
+	refs := make([]string, 0, len(names))
+	for name := range names {
+		refs = append(refs, name)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// ValidateSecretRef checks that ref, when set, references a key of a secret
+func ValidateSecretRef(field string, ref *dockhand.SecretRef) error {
+	if ref != nil && (ref.Name == "" || ref.Key == "") {
+		return fmt.Errorf("%s requires name and key", field)
+	}
+	return nil
+}
+
+// SplitVersion splits a secret name of the form name?version=version
+func SplitVersion(name string) (string, string) {
+	if s := strings.SplitN(name, versionSeparator, 2); len(s) > 1 {
+		return s[0], s[1]
+	}
+	return name, ""
+}
+
+// WithVersion returns the secret name suffixed with ?version= when version is set
+func WithVersion(name string, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + versionSeparator + version
+}
+
+// JSONKey returns key of value, the json object stored in secret name of backend. String keys are returned as is and
+// other keys as json.
+func JSONKey(backend string, name string, value string, key string) (string, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return "", fmt.Errorf("%s secret %s is not a json object: %v", backend, name, err)
+	}
+	keyValue, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("%s secret %s does not contain %s", backend, name, key)
+	}
+	if text, ok := keyValue.(string); ok {
+		return text, nil
+	}
+	text, err := json.Marshal(keyValue)
+	return string(text), err
+}
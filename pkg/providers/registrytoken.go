@@ -0,0 +1,146 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+)
+
+const (
+	// registryTokenRefreshWindow is how long before expiry a registry token is minted again
+	registryTokenRefreshWindow = 15 * time.Minute
+	// minRegistryTokenRefresh is the shortest time a registry token is used before it is minted again, so that tokens
+	// minted close to their expiry do not mint a token on every render
+	minRegistryTokenRefresh = time.Minute
+)
+
+var registryLog = common.ComponentLogger("provider.registry")
+
+// RegistryToken is a short-lived docker registry password
+type RegistryToken struct {
+	Password string
+	Expiry   time.Time
+	refresh  time.Time
+}
+
+// RegistryTokenMintFunc mints a new token for registry
+type RegistryTokenMintFunc func(ctx context.Context, registry string) (*RegistryToken, error)
+
+// RegistryTokenSource mints short-lived registry tokens and caches them per registry until their RefreshTime.
+type RegistryTokenSource struct {
+	mint   RegistryTokenMintFunc
+	tokens map[string]*RegistryToken
+	now    func() time.Time
+	mutex  sync.Mutex
+}
+
+func NewRegistryTokenSource(mint RegistryTokenMintFunc) *RegistryTokenSource {
+	return &RegistryTokenSource{
+		mint:   mint,
+		tokens: make(map[string]*RegistryToken),
+		now:    time.Now,
+	}
+}
+
+// Token returns a valid token for registry, minting a new one with ctx when the cached token is due for refresh.
+func (s *RegistryTokenSource) Token(ctx context.Context, registry string) (*RegistryToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if token, ok := s.tokens[registry]; ok && now.Before(token.RefreshTime()) {
+		return token, nil
+	}
+	registryLog.Debugf("minting registry token for %s", registry)
+	token, err := s.mint(ctx, registry)
+	if err != nil {
+		return nil, err
+	}
+	token.refresh = registryTokenRefreshTime(now, token.Expiry)
+	s.tokens[registry] = token
+	return token, nil
+}
+
+// RefreshTime is the time at which a new token should be minted
+func (t *RegistryToken) RefreshTime() time.Time {
+	if t.refresh.IsZero() {
+		return t.Expiry.Add(-registryTokenRefreshWindow)
+	}
+	return t.refresh
+}
+
+// registryTokenRefreshTime returns the refresh time of a token minted at now that expires at expiry. Tokens are
+// refreshed registryTokenRefreshWindow before their expiry or after half of their remaining lifetime, whichever is
+// sooner, but never sooner than minRegistryTokenRefresh after they were minted.
+func registryTokenRefreshTime(now time.Time, expiry time.Time) time.Time {
+	refresh := expiry.Add(-registryTokenRefreshWindow)
+	if halfLife := now.Add(expiry.Sub(now) / 2); halfLife.Before(refresh) {
+		refresh = halfLife
+	}
+	if minRefresh := now.Add(minRegistryTokenRefresh); refresh.Before(minRefresh) {
+		refresh = minRefresh
+	}
+	return refresh
+}
+
+// RegistryTokenFunc returns a template function that renders the password of registry tokens from source, minted
+// with ctx, and records the earliest refresh time of the tokens used in refresh.
+func RegistryTokenFunc(ctx context.Context, source *RegistryTokenSource, refresh *time.Time) func(string) (string, error) {
+	return func(registry string) (string, error) {
+		token, err := source.Token(ctx, registry)
+		if err != nil {
+			return "", err
+		}
+		if refresh != nil && (refresh.IsZero() || token.RefreshTime().Before(*refresh)) {
+			*refresh = token.RefreshTime()
+		}
+		return token.Password, nil
+	}
+}
+
+// RegistryDockerConfigJsonFunc returns a template function that renders a .dockerconfigjson for registry using a
+// token from source.
+func RegistryDockerConfigJsonFunc(ctx context.Context, source *RegistryTokenSource, username string, refresh *time.Time) func(string) (string, error) {
+	tokenFunc := RegistryTokenFunc(ctx, source, refresh)
+	return func(registry string) (string, error) {
+		password, err := tokenFunc(registry)
+		if err != nil {
+			return "", err
+		}
+		return DockerConfigJson(registry, username, password)
+	}
+}
+
+// DockerConfigJson renders a .dockerconfigjson for a single registry
+func DockerConfigJson(registry, username, password string) (string, error) {
+	dockerConfig, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			registry: map[string]string{
+				"username": username,
+				"password": password,
+				"auth":     base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	})
+	return string(dockerConfig), err
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeMint mints tokens that expire lifetime after the current time of the test and counts them
+type fakeMint struct {
+	now      *time.Time
+	lifetime time.Duration
+	minted   int
+}
+
+func (m *fakeMint) mint(_ context.Context, registry string) (*RegistryToken, error) {
+	m.minted++
+	return &RegistryToken{Password: fmt.Sprintf("%s-%d", registry, m.minted), Expiry: m.now.Add(m.lifetime)}, nil
+}
+
+func TestRegistryTokenRefreshTime(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{name: "long-lived token refreshes before the refresh window", lifetime: 12 * time.Hour, want: 6 * time.Hour},
+		{name: "token refreshes within the refresh window", lifetime: 20 * time.Minute, want: 5 * time.Minute},
+		{name: "long-lived token refreshes after half its lifetime", lifetime: 40 * time.Minute, want: 20 * time.Minute},
+		{name: "token shorter than the refresh window refreshes after the minimum", lifetime: 10 * time.Minute, want: minRegistryTokenRefresh},
+		{name: "nearly expired token refreshes after the minimum", lifetime: 30 * time.Second, want: minRegistryTokenRefresh},
+		{name: "expired token refreshes after the minimum", lifetime: -time.Minute, want: minRegistryTokenRefresh},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := registryTokenRefreshTime(now, now.Add(test.lifetime)).Sub(now); got != test.want {
+				t.Errorf("refresh after %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRegistryTokenSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		lifetime   time.Duration
+		elapsed    time.Duration
+		wantMinted int
+	}{
+		{name: "cached token", lifetime: 12 * time.Hour, elapsed: time.Hour, wantMinted: 1},
+		{name: "token minted again after its refresh time", lifetime: 12 * time.Hour, elapsed: 7 * time.Hour, wantMinted: 2},
+		{name: "short-lived token is cached", lifetime: 20 * time.Minute, elapsed: 4 * time.Minute, wantMinted: 1},
+		{name: "short-lived token minted again within the refresh window", lifetime: 20 * time.Minute, elapsed: 5 * time.Minute, wantMinted: 2},
+		{name: "token shorter than the refresh window is cached for the minimum", lifetime: 10 * time.Minute, elapsed: 59 * time.Second, wantMinted: 1},
+		{name: "token shorter than the refresh window minted again after the minimum", lifetime: 10 * time.Minute, elapsed: time.Minute, wantMinted: 2},
+		{name: "nearly expired token is cached for the minimum", lifetime: 30 * time.Second, elapsed: 30 * time.Second, wantMinted: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+			mint := &fakeMint{now: &now, lifetime: test.lifetime}
+			source := NewRegistryTokenSource(mint.mint)
+			source.now = func() time.Time { return now }
+
+			var refresh time.Time
+			tokenFunc := RegistryTokenFunc(context.Background(), source, &refresh)
+			if _, err := tokenFunc("registry.example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if !refresh.After(now) {
+				t.Errorf("render refresh %s is not after the mint time %s", refresh, now)
+			}
+
+			now = now.Add(test.elapsed)
+			password, err := tokenFunc("registry.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mint.minted != test.wantMinted {
+				t.Errorf("minted %d tokens, want %d", mint.minted, test.wantMinted)
+			}
+			if want := fmt.Sprintf("registry.example.com-%d", test.wantMinted); password != want {
+				t.Errorf("password = %q, want %q", password, want)
+			}
+		})
+	}
+}
+
+func TestRegistryTokenSourceCachesPerRegistry(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	mint := &fakeMint{now: &now, lifetime: 12 * time.Hour}
+	source := NewRegistryTokenSource(mint.mint)
+	source.now = func() time.Time { return now }
+
+	for _, registry := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+		if _, err := source.Token(context.Background(), registry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mint.minted != 2 {
+		t.Errorf("minted %d tokens, want one per registry", mint.minted)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package providers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+)
+
+type renderKey struct{}
+
+// Render collects what clients observe while rendering a single Dockhand Secret
+type Render struct {
+	// Secret is the Dockhand Secret being rendered
+	Secret *dockhand.Secret
+	// Refresh is the earliest time at which short-lived credentials rendered by the functions must be refreshed
+	Refresh time.Time
+	// Context of the render, the spans of the backend calls of the template functions are its children
+	Context context.Context
+	// LogFields are added to the log entries of the providers while rendering, e.g. the correlation ID of the reconcile
+	LogFields common.Fields
+	sources   map[string]string
+	served    map[servedKey]string
+	values    map[string][]byte
+}
+
+type servedKey struct {
+	profile string
+	backend string
+}
+
+// AddSource records that resourceVersion of the kubernetes Secret namespace/name was read, the Dockhand Secret is
+// synced again when it changes. resourceVersion is empty when the Secret does not exist.
+func (r *Render) AddSource(namespace, name, resourceVersion string) {
+	if r.sources == nil {
+		r.sources = make(map[string]string)
+	}
+	r.sources[namespace+"/"+name] = resourceVersion
+}
+
+// Sources returns the namespace/name of the kubernetes Secrets read, ordered by name
+func (r *Render) Sources() []string {
+	if len(r.sources) == 0 {
+		return nil
+	}
+	sources := make([]string, 0, len(r.sources))
+	for source := range r.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// SourceVersions returns the resourceVersion read of each kubernetes Secret keyed by namespace/name
+func (r *Render) SourceVersions() map[string]string {
+	return r.sources
+}
+
+// AddValue records a value returned by a secrets backend, so that it can be redacted from the errors of the render
+func (r *Render) AddValue(value string) {
+	if r == nil || value == "" {
+		return
+	}
+	if r.values == nil {
+		r.values = make(map[string][]byte)
+	}
+	r.values[value] = []byte(value)
+}
+
+// Values returns the values recorded with AddValue keyed by themselves, in the form common.RedactError expects
+func (r *Render) Values() map[string][]byte {
+	if r == nil {
+		return nil
+	}
+	return r.values
+}
+
+// Served records that servedBy served a value of backend for profile. Fallbacks are kept over profile itself so that
+// a failover is reported even when other values were served by profile.
+func (r *Render) Served(profile, backend, servedBy string) {
+	if r.served == nil {
+		r.served = make(map[servedKey]string)
+	}
+	key := servedKey{profile: profile, backend: backend}
+	if current, ok := r.served[key]; ok && current != profile && servedBy == profile {
+		return
+	}
+	r.served[key] = servedBy
+}
+
+// ServedBy returns the profile that served the values of each backend recorded with Served, ordered by profile and
+// backend
+func (r *Render) ServedBy() []dockhand.BackendStatus {
+	if len(r.served) == 0 {
+		return nil
+	}
+	statuses := make([]dockhand.BackendStatus, 0, len(r.served))
+	for key, servedBy := range r.served {
+		statuses = append(statuses, dockhand.BackendStatus{Profile: key.profile, Backend: key.backend, ServedBy: servedBy})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Profile != statuses[j].Profile {
+			return statuses[i].Profile < statuses[j].Profile
+		}
+		return statuses[i].Backend < statuses[j].Backend
+	})
+	return statuses
+}
+
+// fork returns an empty Render of the same Dockhand Secret with ctx, which collects what a single call observes until
+// it is merged
+func (r *Render) fork(ctx context.Context) *Render {
+	if r == nil {
+		return &Render{Context: ctx}
+	}
+	return &Render{Secret: r.Secret, Context: ctx, LogFields: r.LogFields}
+}
+
+// merge records what fork observed in r
+func (r *Render) merge(fork *Render) {
+	if r == nil {
+		return
+	}
+	if !fork.Refresh.IsZero() && (r.Refresh.IsZero() || fork.Refresh.Before(r.Refresh)) {
+		r.Refresh = fork.Refresh
+	}
+	for source, resourceVersion := range fork.sources {
+		if r.sources == nil {
+			r.sources = make(map[string]string)
+		}
+		r.sources[source] = resourceVersion
+	}
+	for key, servedBy := range fork.served {
+		r.Served(key.profile, key.backend, servedBy)
+	}
+	for value := range fork.values {
+		r.AddValue(value)
+	}
+}
+
+// WithRender returns a copy of ctx carrying render, for clients to record what they read in GetSecret
+func WithRender(ctx context.Context, render *Render) context.Context {
+	return context.WithValue(ctx, renderKey{}, render)
+}
+
+// Log returns logger with the LogFields of the Render, logger is returned as is when the Render is nil
+func (r *Render) Log(logger *common.Logger) *common.Logger {
+	if r == nil || len(r.LogFields) == 0 {
+		return logger
+	}
+	return logger.With(r.LogFields)
+}
+
+// RequestContext returns the Context of the Render, or the background context when there is none
+func (r *Render) RequestContext() context.Context {
+	if r == nil || r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
+}
+
+// RenderFromContext returns the Render carried by ctx, or nil when there is none
+func RenderFromContext(ctx context.Context) *Render {
+	render, _ := ctx.Value(renderKey{}).(*Render)
+	return render
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// keyService decrypts SOPS data keys with the private keys of a Profile, instead of the keys of the operator
+// environment used by the local key service.
+type keyService struct {
+	ageIdentities sopsage.ParsedIdentities
+	pgpKeyRing    openpgp.EntityList
+}
+
+func newKeyService(ageKeys string, pgpKey string) (*keyService, error) {
+	service := &keyService{}
+	if ageKeys != "" {
+		identities, err := age.ParseIdentities(strings.NewReader(ageKeys))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse age keys: %v", err)
+		}
+		service.ageIdentities = identities
+	}
+	if pgpKey != "" {
+		keyRing, err := openpgp.ReadArmoredKeyRing(strings.NewReader(pgpKey))
+		if err != nil {
+			keyRing, err = openpgp.ReadKeyRing(strings.NewReader(pgpKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse pgp key: %v", err)
+		}
+		service.pgpKeyRing = keyRing
+	}
+	return service, nil
+}
+
+func (s *keyService) Encrypt(_ context.Context, _ *keyservice.EncryptRequest, _ ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return nil, errors.New("encryption is not supported")
+}
+
+// Decrypt decrypts the data key encrypted with an age or PGP master key
+func (s *keyService) Decrypt(_ context.Context, req *keyservice.DecryptRequest, _ ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch {
+	case req.Key.GetAgeKey() != nil:
+		plaintext, err = s.decryptAge(req.Key.GetAgeKey().Recipient, req.Ciphertext)
+	case req.Key.GetPgpKey() != nil:
+		plaintext, err = s.decryptPgp(req.Ciphertext)
+	default:
+		err = errors.New("only age and pgp keys are supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+func (s *keyService) decryptAge(recipient string, ciphertext []byte) ([]byte, error) {
+	if len(s.ageIdentities) == 0 {
+		return nil, errors.New("no age key configured")
+	}
+	key := &sopsage.MasterKey{Recipient: recipient, EncryptedKey: string(ciphertext)}
+	s.ageIdentities.ApplyToMasterKey(key)
+	return key.Decrypt()
+}
+
+func (s *keyService) decryptPgp(ciphertext []byte) ([]byte, error) {
+	if len(s.pgpKeyRing) == 0 {
+		return nil, errors.New("no pgp key configured")
+	}
+	block, err := armor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	message, err := openpgp.ReadMessage(block.Body, s.pgpKeyRing, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(message.UnverifiedBody)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/keyservice"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const Name = "sops"
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider decrypts the SOPS encrypted document carried in spec.sops of a Dockhand Secret with the age or PGP
+// private keys referenced by the Profile.
+type Provider struct{}
+
+// Client decrypts documents with the keys of a Profile. Decrypted documents are only kept for the render that
+// decrypted them, so that no plaintext outlives the sync of its Dockhand Secret.
+type Client struct {
+	keys *keyService
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.Sops != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.Sops
+	if config.AgeKeyRef == nil && config.PgpKeyRef == nil {
+		return fmt.Errorf("%s requires ageKeyRef or pgpKeyRef", Name)
+	}
+	if err := providers.ValidateSecretRef(Name+".ageKeyRef", config.AgeKeyRef); err != nil {
+		return err
+	}
+	return providers.ValidateSecretRef(Name+".pgpKeyRef", config.PgpKeyRef)
+}
+
+func (p *Provider) NewClient(_ context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.Sops
+	ageKeys, err := providers.SecretRefValue(reader, profile.Namespace, config.AgeKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	pgpKey, err := providers.SecretRefValue(reader, profile.Namespace, config.PgpKeyRef)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newKeyService(ageKeys, pgpKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{keys: keys}, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"sops"}
+}
+
+func (p *Provider) DataFromName(_ *dockhand.DataFromSource) (string, bool) {
+	return "", false
+}
+
+// FuncMap returns the sops template function, which decrypts the document of the Dockhand Secret at most once per
+// render
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	var document map[string]interface{}
+	var mutex sync.Mutex
+	return template.FuncMap{
+		"sops": func(path string) (string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if document == nil {
+				decrypted, err := c.getDocument(render)
+				if err != nil {
+					return "", err
+				}
+				document = decrypted
+			}
+			return lookup(document, path)
+		},
+	}
+}
+
+// GetSecret returns the value at the dotted path name of the document of the Dockhand Secret being rendered
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (string, error) {
+	if version != "" && version != "latest" {
+		return "", fmt.Errorf("%s provider does not support secret versions", Name)
+	}
+	document, err := c.getDocument(providers.RenderFromContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return lookup(document, name)
+}
+
+// HealthCheck always succeeds, the private keys are parsed when the client is created
+func (c *Client) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+// lookup returns the value at the dotted path of document, list items are selected by index. Objects and lists are
+// returned as json.
+func lookup(document map[string]interface{}, path string) (string, error) {
+	var value interface{} = document
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("sops document does not contain %s", path)
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("sops document does not contain %s", path)
+			}
+			value = node[index]
+		default:
+			return "", fmt.Errorf("sops document does not contain %s", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	}
+	text, err := json.Marshal(value)
+	return string(text), err
+}
+
+// getDocument returns the decrypted document of the Dockhand Secret being rendered
+func (c *Client) getDocument(render *providers.Render) (map[string]interface{}, error) {
+	if render == nil || render.Secret == nil {
+		return nil, errors.New("sops can only be used while rendering a Dockhand Secret")
+	}
+	secret := render.Secret
+	if secret.Spec.Sops == "" {
+		return nil, fmt.Errorf("secret %s/%s does not define spec.sops", secret.Namespace, secret.Name)
+	}
+	document, err := c.decrypt([]byte(secret.Spec.Sops))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt sops document of %s/%s: %v", secret.Namespace, secret.Name, err)
+	}
+	return document, nil
+}
+
+// decrypt decrypts a SOPS encrypted YAML or JSON document and verifies its MAC
+func (c *Client) decrypt(data []byte) (map[string]interface{}, error) {
+	var store sops.Store = &sopsyaml.Store{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		store = &sopsjson.Store{}
+	}
+	tree, err := store.LoadEncryptedFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{c.keys}, nil)
+	if err != nil {
+		return nil, err
+	}
+	cipher := aes.NewCipher()
+	computedMac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	fileMac, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt mac: %v", err)
+	}
+	if fileMac != computedMac {
+		return nil, errors.New("mac mismatch, the document has been modified")
+	}
+
+	plain, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, err
+	}
+	document := make(map[string]interface{})
+	if err := yaml.Unmarshal(plain, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// encrypt returns plain encrypted with SOPS for the age recipient
+func encrypt(t *testing.T, recipient string, plain string) string {
+	t.Helper()
+	store := &sopsyaml.Store{}
+	branches, err := store.LoadPlainFile([]byte(plain))
+	if err != nil {
+		t.Fatalf("loading plain document: %v", err)
+	}
+	masterKey, err := sopsage.MasterKeyFromRecipient(recipient)
+	if err != nil {
+		t.Fatalf("age master key: %v", err)
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{KeyGroups: []sops.KeyGroup{{masterKey}}, Version: "3.9.4"},
+	}
+	dataKey, errs := tree.GenerateDataKey()
+	if len(errs) > 0 {
+		t.Fatalf("generating data key: %v", errs)
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		t.Fatalf("encrypting mac: %v", err)
+	}
+	encrypted, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		t.Fatalf("emitting encrypted document: %v", err)
+	}
+	return string(encrypted)
+}
+
+func newTestClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newKeyService(identity.String(), "")
+	if err != nil {
+		t.Fatalf("newKeyService: %v", err)
+	}
+	return &Client{keys: keys}, identity.Recipient().String()
+}
+
+func newRender(document string) *providers.Render {
+	return &providers.Render{Secret: &dockhand.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+		Spec:       dockhand.SecretSpec{Sops: document},
+	}}
+}
+
+func TestSopsFunction(t *testing.T) {
+	client, recipient := newTestClient(t)
+	document := encrypt(t, recipient, `db:
+  password: s3cr3t
+  port: 5432
+users:
+  - name: alice
+  - name: bob
+`)
+	sopsFunc := client.FuncMap(newRender(document))["sops"].(func(string) (string, error))
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "db.password", want: "s3cr3t"},
+		{path: "db.port", want: "5432"},
+		{path: "users.1.name", want: "bob"},
+		{path: "users.0", want: `{"name":"alice"}`},
+		{path: "db.user", wantErr: "does not contain db.user"},
+		{path: "users.2.name", wantErr: "does not contain users.2.name"},
+		{path: "db.password.value", wantErr: "does not contain db.password.value"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			value, err := sopsFunc(test.path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("sops error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sops: %v", err)
+			}
+			if value != test.want {
+				t.Errorf("sops = %s, want %s", value, test.want)
+			}
+		})
+	}
+}
+
+func TestSopsDecryptsTheDocumentOfEachRender(t *testing.T) {
+	client, recipient := newTestClient(t)
+
+	for _, password := range []string{"first", "second"} {
+		render := newRender(encrypt(t, recipient, "password: "+password+"\n"))
+		value, err := client.FuncMap(render)["sops"].(func(string) (string, error))("password")
+		if err != nil {
+			t.Fatalf("sops: %v", err)
+		}
+		if value != password {
+			t.Errorf("sops = %s, want %s", value, password)
+		}
+		value, err = client.GetSecret(providers.WithRender(context.Background(), render), "password", "")
+		if err != nil {
+			t.Fatalf("GetSecret: %v", err)
+		}
+		if value != password {
+			t.Errorf("GetSecret = %s, want %s", value, password)
+		}
+	}
+}
+
+func TestSopsRejectsInvalidDocuments(t *testing.T) {
+	client, recipient := newTestClient(t)
+	_, otherRecipient := newTestClient(t)
+	document := encrypt(t, recipient, "password: s3cr3t\n")
+
+	tests := []struct {
+		name    string
+		render  *providers.Render
+		wantErr string
+	}{
+		{name: "no render", wantErr: "only be used while rendering"},
+		{name: "no document", render: newRender(""), wantErr: "does not define spec.sops"},
+		{name: "other key", render: newRender(encrypt(t, otherRecipient, "password: s3cr3t\n")), wantErr: "could not decrypt"},
+		{name: "modified", render: newRender(strings.Replace(document, "lastmodified: ", "lastmodified: 1", 1)), wantErr: "could not decrypt"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.FuncMap(test.render)["sops"].(func(string) (string, error))("password")
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("sops error = %v, want %s", err, test.wantErr)
+			}
+		})
+	}
+}
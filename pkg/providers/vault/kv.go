@@ -0,0 +1,315 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// tokenRenewMargin is the time before the Vault token expires at which it is renewed or a new AppRole login is made,
+// so that calls in flight do not fail
+const tokenRenewMargin = 30 * time.Second
+
+// vaultKVClient reads entire Vault KV secrets, which the dockcmd vault client does not support since it only exposes
+// single keys. The client logs in to Vault the first time a secret is read and keeps the token valid: AppRole tokens
+// are replaced by a new login and renewable tokens are renewed before they expire.
+type vaultKVClient struct {
+	addr     string
+	token    string
+	roleID   string
+	secretID string
+	client   *api.Client
+	// tokenExpiry is the time the token expires, zero when it does not expire
+	tokenExpiry time.Time
+	mutex       sync.Mutex
+}
+
+func newVaultKVClient(addr, token, roleID, secretID string) *vaultKVClient {
+	return &vaultKVClient{
+		addr:     addr,
+		token:    token,
+		roleID:   roleID,
+		secretID: secretID,
+	}
+}
+
+// appRole reports whether the client logs in with an AppRole
+func (c *vaultKVClient) appRole() bool {
+	return c.roleID != "" && c.secretID != ""
+}
+
+// apiClient returns the client logged in to Vault, logging in or renewing the token when it is about to expire
+func (c *vaultKVClient) apiClient(ctx context.Context) (*api.Client, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client != nil && (c.tokenExpiry.IsZero() || time.Now().Add(tokenRenewMargin).Before(c.tokenExpiry)) {
+		return c.client, nil
+	}
+	if c.client != nil && !c.appRole() {
+		secret, err := c.client.Auth().Token().RenewSelfWithContext(ctx, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to renew vault token: %v", err)
+		}
+		c.setTokenExpiry(secret)
+		return c.client, nil
+	}
+
+	config := api.DefaultConfig()
+	config.Address = c.addr
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if c.appRole() {
+		resp, err := client.Logical().WriteWithContext(ctx, "auth/approle/login", map[string]interface{}{
+			"role_id":   c.roleID,
+			"secret_id": c.secretID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil || resp.Auth == nil {
+			return nil, errors.New("failed to obtain vault token using roleId and secretId")
+		}
+		client.SetToken(resp.Auth.ClientToken)
+		c.client = client
+		c.setTokenExpiry(resp)
+		return client, nil
+	}
+
+	client.SetToken(c.token)
+	c.client = client
+	c.tokenExpiry = time.Time{}
+	// tokens that cannot be looked up are used as is until Vault rejects them
+	if secret, err := client.Auth().Token().LookupSelfWithContext(ctx); err == nil {
+		if renewable, _ := secret.TokenIsRenewable(); renewable {
+			c.setTokenExpiry(secret)
+		}
+	}
+	return client, nil
+}
+
+// setTokenExpiry records the expiry of the token returned in secret, c.mutex must be held
+func (c *vaultKVClient) setTokenExpiry(secret *api.Secret) {
+	c.tokenExpiry = time.Time{}
+	if ttl, err := secret.TokenTTL(); err == nil && ttl > 0 {
+		c.tokenExpiry = time.Now().Add(ttl)
+	}
+}
+
+// call runs fn with the client logged in to Vault. A permission denied response to an AppRole token means it expired
+// or was revoked early, fn is retried once after a new login.
+func (c *vaultKVClient) call(ctx context.Context, fn func(client *api.Client) error) error {
+	client, err := c.apiClient(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(client)
+	if !c.appRole() || !isPermissionDenied(err) {
+		return err
+	}
+
+	c.mutex.Lock()
+	if c.client == client {
+		c.client = nil
+	}
+	c.mutex.Unlock()
+	if client, err = c.apiClient(ctx); err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+// isPermissionDenied reports whether err is a 403 response of Vault
+func isPermissionDenied(err error) bool {
+	var respErr *api.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+// readSecret returns all keys of the KV v1 or v2 secret at path, version is only supported for KV v2.
+func (c *vaultKVClient) readSecret(ctx context.Context, path string, version string) (data map[string]interface{}, err error) {
+	if version == "latest" {
+		version = ""
+	}
+	err = c.call(ctx, func(client *api.Client) error {
+		mountPath, kvVersion, err := mountInfo(ctx, client, path)
+		if err != nil {
+			return err
+		}
+		if kvVersion != 2 {
+			secret, err := client.Logical().ReadWithContext(ctx, path)
+			if err != nil {
+				return err
+			}
+			if secret == nil {
+				return fmt.Errorf("vault secret %s not found", path)
+			}
+			data = secret.Data
+			return nil
+		}
+
+		mountPath, secretPath := splitMountPath(mountPath, path)
+		var secret *api.KVSecret
+		if version == "" {
+			secret, err = client.KVv2(mountPath).Get(ctx, secretPath)
+		} else {
+			v, convErr := strconv.Atoi(version)
+			if convErr != nil {
+				return fmt.Errorf("invalid vault secret version %s", version)
+			}
+			secret, err = client.KVv2(mountPath).GetVersion(ctx, secretPath, v)
+		}
+		if err != nil {
+			return err
+		}
+		data = secret.Data
+		return nil
+	})
+	return data, err
+}
+
+// secretVersion returns the current version of the KV v2 secret at path, KV v1 secrets are not versioned so the
+// checksum of their data is returned instead
+func (c *vaultKVClient) secretVersion(ctx context.Context, path string) (version string, err error) {
+	err = c.call(ctx, func(client *api.Client) error {
+		mountPath, kvVersion, err := mountInfo(ctx, client, path)
+		if err != nil {
+			return err
+		}
+		if kvVersion != 2 {
+			secret, err := client.Logical().ReadWithContext(ctx, path)
+			if err != nil || secret == nil {
+				return err
+			}
+			version, err = dataChecksum(secret.Data)
+			return err
+		}
+		mountPath, secretPath := splitMountPath(mountPath, path)
+		metadata, err := client.KVv2(mountPath).GetMetadata(ctx, secretPath)
+		if err != nil {
+			if errors.Is(err, api.ErrSecretNotFound) {
+				return nil
+			}
+			return err
+		}
+		version = strconv.Itoa(metadata.CurrentVersion)
+		return nil
+	})
+	return version, err
+}
+
+// writeSecret writes data to the secret at path and returns the version written, see secretVersion
+func (c *vaultKVClient) writeSecret(ctx context.Context, path string, data map[string]interface{}) (version string, err error) {
+	err = c.call(ctx, func(client *api.Client) error {
+		mountPath, kvVersion, err := mountInfo(ctx, client, path)
+		if err != nil {
+			return err
+		}
+		if kvVersion != 2 {
+			if _, err := client.Logical().WriteWithContext(ctx, path, data); err != nil {
+				return err
+			}
+			version, err = dataChecksum(data)
+			return err
+		}
+		mountPath, secretPath := splitMountPath(mountPath, path)
+		secret, err := client.KVv2(mountPath).Put(ctx, secretPath, data)
+		if err != nil {
+			return err
+		}
+		if secret.VersionMetadata == nil {
+			return fmt.Errorf("vault did not return the version written to %s", path)
+		}
+		version = strconv.Itoa(secret.VersionMetadata.Version)
+		return nil
+	})
+	return version, err
+}
+
+// deleteSecret deletes the secret at path, including every version of KV v2 secrets
+func (c *vaultKVClient) deleteSecret(ctx context.Context, path string) error {
+	return c.call(ctx, func(client *api.Client) error {
+		mountPath, kvVersion, err := mountInfo(ctx, client, path)
+		if err != nil {
+			return err
+		}
+		if kvVersion != 2 {
+			_, err := client.Logical().DeleteWithContext(ctx, path)
+			return err
+		}
+		mountPath, secretPath := splitMountPath(mountPath, path)
+		return client.KVv2(mountPath).DeleteMetadata(ctx, secretPath)
+	})
+}
+
+// healthCheck logs in to Vault and looks up the token in use
+func (c *vaultKVClient) healthCheck(ctx context.Context) error {
+	return c.call(ctx, func(client *api.Client) error {
+		_, err := client.Auth().Token().LookupSelfWithContext(ctx)
+		return err
+	})
+}
+
+// mountInfo returns the mount path and KV version for path, defaulting to KV v1 when it cannot be determined.
+func mountInfo(ctx context.Context, client *api.Client, path string) (string, int, error) {
+	secret, err := client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+path)
+	if err != nil {
+		var respErr *api.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == 404 {
+			return "", 1, nil
+		}
+		return "", 0, err
+	}
+	if secret == nil {
+		return "", 1, nil
+	}
+	mountPath, _ := secret.Data["path"].(string)
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok {
+		if options["version"] == "2" {
+			return mountPath, 2, nil
+		}
+	}
+	return mountPath, 1, nil
+}
+
+// splitMountPath returns the mount path and the path of a secret relative to its mount
+func splitMountPath(mountPath string, path string) (string, string) {
+	mountPath = strings.TrimSuffix(mountPath, "/")
+	return mountPath, strings.TrimPrefix(strings.TrimPrefix(path, mountPath), "/")
+}
+
+// dataChecksum returns the checksum of the json of data, which identifies the contents of unversioned secrets
+func dataChecksum(data map[string]interface{}) (string, error) {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(dataJson)
+	return hex.EncodeToString(sum[:]), nil
+}
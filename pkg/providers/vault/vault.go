@@ -0,0 +1,168 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+const Name = "vault"
+
+func init() {
+	providers.Register(&Provider{})
+}
+
+// Provider for HashiCorp Vault KV secrets
+type Provider struct{}
+
+// Client reads Vault KV secrets, the secrets read by the template functions and dataFrom are cached for the cacheTTL
+// of the Profile
+type Client struct {
+	kv    *vaultKVClient
+	cache *cache.Cache
+}
+
+func (p *Provider) Name() string {
+	return Name
+}
+
+func (p *Provider) Configured(profile *dockhand.Profile) bool {
+	return profile.Spec.Vault != nil
+}
+
+func (p *Provider) ValidateConfig(profile *dockhand.Profile) error {
+	config := profile.Spec.Vault
+	if _, err := time.ParseDuration(config.CacheTTL); err != nil {
+		return fmt.Errorf("%s.cacheTTL: %v", Name, err)
+	}
+	if config.Addr == "" {
+		return fmt.Errorf("%s.addr is required", Name)
+	}
+	if err := providers.ValidateSecretRef(Name+".secretIdRef", config.SecretIdRef); err != nil {
+		return err
+	}
+	return providers.ValidateSecretRef(Name+".tokenRef", config.TokenRef)
+}
+
+func (p *Provider) NewClient(_ context.Context, profile *dockhand.Profile, reader providers.Reader) (providers.Client, error) {
+	config := profile.Spec.Vault
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	roleID := ""
+	if config.RoleId != nil {
+		roleID = *config.RoleId
+	}
+	secretID, err := providers.SecretRefValue(reader, profile.Namespace, config.SecretIdRef)
+	if err != nil {
+		return nil, err
+	}
+	token, err := providers.SecretRefValue(reader, profile.Namespace, config.TokenRef)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		kv:    newVaultKVClient(config.Addr, token, roleID, secretID),
+		cache: cache.New(cacheTTL, cacheTTL),
+	}, nil
+}
+
+func (p *Provider) FuncNames() []string {
+	return []string{"vault"}
+}
+
+func (p *Provider) DataFromName(source *dockhand.DataFromSource) (string, bool) {
+	if source.Vault == nil {
+		return "", false
+	}
+	return source.Vault.Path, true
+}
+
+func (c *Client) FuncMap(render *providers.Render) template.FuncMap {
+	return template.FuncMap{
+		"vault": func(path string, key string) (string, error) {
+			secretData, err := c.readSecret(render.RequestContext(), path)
+			if err != nil {
+				return "", err
+			}
+			value, ok := secretData[key].(string)
+			if !ok {
+				return "", fmt.Errorf("could not convert vault response [%s][%s] to string", path, key)
+			}
+			return value, nil
+		},
+	}
+}
+
+// GetSecret returns all keys of the secret at path as json
+func (c *Client) GetSecret(ctx context.Context, path string, version string) (string, error) {
+	secretData, err := c.readSecret(ctx, providers.WithVersion(path, version))
+	if err != nil {
+		return "", err
+	}
+	secretJson, err := json.Marshal(secretData)
+	return string(secretJson), err
+}
+
+// readSecret returns all keys of the secret at path, optionally suffixed with ?version=, from the cache or Vault
+func (c *Client) readSecret(ctx context.Context, path string) (map[string]interface{}, error) {
+	if cached, ok := c.cache.Get(path); ok {
+		return cached.(map[string]interface{}), nil
+	}
+	secretPath, version := providers.SplitVersion(path)
+	secretData, err := c.kv.readSecret(ctx, secretPath, version)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.SetDefault(path, secretData)
+	return secretData, nil
+}
+
+// SecretVersion returns the current version of the secret at path
+func (c *Client) SecretVersion(ctx context.Context, path string) (string, error) {
+	return c.kv.secretVersion(ctx, path)
+}
+
+// PutSecret writes the keys of value, a json object, to the secret at path. Any other value is written to the key
+// value.
+func (c *Client) PutSecret(ctx context.Context, path string, value string) (string, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil || data == nil {
+		data = map[string]interface{}{"value": value}
+	}
+	c.cache.Delete(path)
+	return c.kv.writeSecret(ctx, path, data)
+}
+
+// DeleteSecret deletes the secret at path
+func (c *Client) DeleteSecret(ctx context.Context, path string) error {
+	c.cache.Delete(path)
+	return c.kv.deleteSecret(ctx, path)
+}
+
+func (c *Client) HealthCheck(ctx context.Context) error {
+	return c.kv.healthCheck(ctx)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/providers"
+	"github.com/patrickmn/go-cache"
+)
+
+// fakeVault serves AppRole logins and the KV v2 secret secret/app, tokens listed in revoked are denied
+type fakeVault struct {
+	leaseDuration int
+	logins        int
+	reads         int
+	revoked       map[string]bool
+	mutex         sync.Mutex
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		f.logins++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   fmt.Sprintf("token-%d", f.logins),
+				"lease_duration": f.leaseDuration,
+				"renewable":      true,
+			},
+		})
+		return
+	}
+	if f.revoked[r.Header.Get("X-Vault-Token")] {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/sys/internal/ui/mounts/secret/app":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"path": "secret/", "options": map[string]interface{}{"version": "2"}},
+		})
+	case "/v1/secret/data/app":
+		f.reads++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"password": "hunter2"},
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (f *fakeVault) revoke(token string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.revoked[token] = true
+}
+
+func (f *fakeVault) counts() (int, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.logins, f.reads
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func newFakeVault(t *testing.T, leaseDuration int) (*fakeVault, *vaultKVClient) {
+	t.Helper()
+	fake := &fakeVault{leaseDuration: leaseDuration, revoked: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, newVaultKVClient(server.URL, "", "role", "secret")
+}
+
+func TestReadSecretLogsInAgainWhenTokenIsDenied(t *testing.T) {
+	fake, kv := newFakeVault(t, 3600)
+	ctx := context.Background()
+
+	if _, err := kv.readSecret(ctx, "secret/app", ""); err != nil {
+		t.Fatalf("readSecret: %v", err)
+	}
+	fake.revoke("token-1")
+	data, err := kv.readSecret(ctx, "secret/app", "")
+	if err != nil {
+		t.Fatalf("readSecret after the token was revoked: %v", err)
+	}
+	if data["password"] != "hunter2" {
+		t.Errorf("password = %v, want hunter2", data["password"])
+	}
+	if logins, _ := fake.counts(); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+}
+
+func TestReadSecretLogsInAgainBeforeTokenExpires(t *testing.T) {
+	// the token expires within tokenRenewMargin so every read logs in again
+	fake, kv := newFakeVault(t, int(tokenRenewMargin/time.Second)/2)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := kv.readSecret(ctx, "secret/app", ""); err != nil {
+			t.Fatalf("readSecret: %v", err)
+		}
+	}
+	if logins, _ := fake.counts(); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+}
+
+func TestTemplateFunctionAndDataFromShareCache(t *testing.T) {
+	fake, kv := newFakeVault(t, 3600)
+	client := &Client{kv: kv, cache: cache.New(time.Minute, time.Minute)}
+	render := &providers.Render{Context: context.Background()}
+
+	vaultFunc := client.FuncMap(render)["vault"].(func(string, string) (string, error))
+	value, err := vaultFunc("secret/app", "password")
+	if err != nil {
+		t.Fatalf("vault: %v", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("vault = %s, want hunter2", value)
+	}
+	if _, err := vaultFunc("secret/app", "missing"); err == nil {
+		t.Errorf("vault of a missing key succeeded")
+	}
+	secretJson, err := client.GetSecret(context.Background(), "secret/app", "")
+	if err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+	if secretJson != `{"password":"hunter2"}` {
+		t.Errorf("GetSecret = %s", secretJson)
+	}
+	if _, reads := fake.counts(); reads != 1 {
+		t.Errorf("reads = %d, want 1", reads)
+	}
+}
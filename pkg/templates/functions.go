@@ -0,0 +1,384 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"sigs.k8s.io/yaml"
+)
+
+// v1FuncMap is the curated v1 function library. Functions are only ever added to a version, changing or removing a
+// function requires a new version so that existing templates keep rendering the same output.
+func v1FuncMap() template.FuncMap {
+	return template.FuncMap{
+		// encoding
+		"b64enc": b64enc,
+		"b64dec": b64dec,
+
+		// structured data
+		"toJson":   toJson,
+		"fromJson": fromJson,
+		"toYaml":   toYaml,
+		"fromYaml": fromYaml,
+		"jq":       jq,
+
+		// crypto
+		"bcrypt":    bcryptHash,
+		"htpasswd":  htpasswd,
+		"pemBundle": pemBundle,
+
+		// defaults
+		"default":  defaultValue,
+		"required": required,
+
+		// strings
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     title,
+		"trim":      strings.TrimSpace,
+		"camelCase": camelCase,
+		"snakeCase": snakeCase,
+		"kebabCase": kebabCase,
+	}
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("b64dec: %v", err)
+	}
+	return string(data), nil
+}
+
+func toJson(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toJson: %v", err)
+	}
+	return string(data), nil
+}
+
+func fromJson(s string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("fromJson: %v", err)
+	}
+	return v, nil
+}
+
+func toYaml(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("toYaml: %v", err)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func fromYaml(s string) (interface{}, error) {
+	data, err := yaml.YAMLToJSON([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("fromYaml: %v", err)
+	}
+	return fromJson(string(data))
+}
+
+// jq extracts the value at path from input, which is either json text or a value returned by fromJson or fromYaml.
+// Paths support object keys and array indexes e.g. .db.hosts[0].name or .["key.with.dots"].
+func jq(path string, input interface{}) (interface{}, error) {
+	value := input
+	if s, ok := input.(string); ok {
+		var err error
+		if value, err = fromJson(s); err != nil {
+			return nil, fmt.Errorf("jq: input is not json: %v", err)
+		}
+	}
+
+	segments, err := parseJqPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("jq: %v", err)
+	}
+	for _, segment := range segments {
+		switch key := segment.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("jq: cannot get key %q of non-object in %s", key, path)
+			}
+			if value, ok = object[key]; !ok {
+				return nil, fmt.Errorf("jq: key %q not found in %s", key, path)
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("jq: cannot index non-array in %s", path)
+			}
+			if key < 0 {
+				key += len(array)
+			}
+			if key < 0 || key >= len(array) {
+				return nil, fmt.Errorf("jq: index out of range in %s", path)
+			}
+			value = array[key]
+		}
+	}
+	return value, nil
+}
+
+// parseJqPath splits path into string object keys and int array indexes
+func parseJqPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("path %s must start with .", path)
+	}
+	var segments []interface{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end > i {
+				segments = append(segments, path[i:end])
+			}
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %s", path)
+			}
+			index := path[i+1 : i+end]
+			if unquoted, err := strconv.Unquote(index); err == nil {
+				segments = append(segments, unquoted)
+			} else if n, err := strconv.Atoi(index); err == nil {
+				segments = append(segments, n)
+			} else {
+				return nil, fmt.Errorf("invalid index [%s] in %s", index, path)
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q in %s", path[i], path)
+		}
+	}
+	return segments, nil
+}
+
+// bcryptHashPattern matches the bcrypt hashes in a rendered value
+var bcryptHashPattern = regexp.MustCompile(`\$2[aby]?\$\d{2}\$[./A-Za-z0-9]{53}`)
+
+// hashFuncs returns bcrypt and htpasswd functions that reuse a bcrypt hash found in previous, the value rendered for
+// the same key by the last sync, while it still matches the password. bcrypt salts every hash so rendering a new hash
+// on each sync would change the managed secret and roll its workloads every syncInterval.
+func hashFuncs(previous []byte) template.FuncMap {
+	hashes := bcryptHashPattern.FindAll(previous, -1)
+	hash := func(password string) (string, error) {
+		for _, h := range hashes {
+			if bcrypt.CompareHashAndPassword(h, []byte(password)) == nil {
+				return string(h), nil
+			}
+		}
+		return bcryptHash(password)
+	}
+	return template.FuncMap{
+		"bcrypt": hash,
+		"htpasswd": func(username, password string) (string, error) {
+			return htpasswdEntry(username, password, hash)
+		},
+	}
+}
+
+func bcryptHash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %v", err)
+	}
+	return string(hash), nil
+}
+
+// htpasswd renders an htpasswd entry using a bcrypt hash of password
+func htpasswd(username, password string) (string, error) {
+	return htpasswdEntry(username, password, bcryptHash)
+}
+
+func htpasswdEntry(username, password string, hashFunc func(string) (string, error)) (string, error) {
+	if strings.Contains(username, ":") {
+		return "", errors.New("htpasswd: username must not contain :")
+	}
+	hash, err := hashFunc(password)
+	if err != nil {
+		return "", err
+	}
+	return username + ":" + hash, nil
+}
+
+// pemBundle concatenates the PEM blocks of each argument, which may be a string or a list of strings, into a single
+// bundle. Arguments that contain anything other than PEM blocks are rejected.
+func pemBundle(pems ...interface{}) (string, error) {
+	var inputs []string
+	for _, p := range pems {
+		switch v := p.(type) {
+		case string:
+			inputs = append(inputs, v)
+		case []string:
+			inputs = append(inputs, v...)
+		case []interface{}:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return "", fmt.Errorf("pemBundle: unsupported argument type %T", item)
+				}
+				inputs = append(inputs, s)
+			}
+		default:
+			return "", fmt.Errorf("pemBundle: unsupported argument type %T", p)
+		}
+	}
+
+	var bundle bytes.Buffer
+	for i, input := range inputs {
+		rest := []byte(input)
+		blocks := 0
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			blocks++
+			if err := pem.Encode(&bundle, block); err != nil {
+				return "", fmt.Errorf("pemBundle: %v", err)
+			}
+		}
+		if blocks == 0 || len(bytes.TrimSpace(rest)) > 0 {
+			return "", fmt.Errorf("pemBundle: argument %d is not PEM encoded", i+1)
+		}
+	}
+	return bundle.String(), nil
+}
+
+// defaultValue returns value unless it is empty, in which case def is returned
+func defaultValue(def interface{}, value interface{}) interface{} {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// required returns value or fails rendering with message when value is empty
+func required(message string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, fmt.Errorf("required value is missing: %s", message)
+	}
+	return value, nil
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func title(s string) string {
+	return cases.Title(language.Und).String(s)
+}
+
+func camelCase(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word)
+		} else {
+			words[i] = capitalize(word)
+		}
+	}
+	return strings.Join(words, "")
+}
+
+func snakeCase(s string) string {
+	return strings.ToLower(strings.Join(splitWords(s), "_"))
+}
+
+func kebabCase(s string) string {
+	return strings.ToLower(strings.Join(splitWords(s), "-"))
+}
+
+func capitalize(word string) string {
+	runes := []rune(strings.ToLower(word))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+// splitWords splits s into words on separators and case changes e.g. "HTTPServer_port" is HTTP, Server and port
+func splitWords(s string) []string {
+	var words []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = nil
+		}
+	}
+
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(current) > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/pem"
+	"strings"
+	"testing"
+	"text/template"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	certPem = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("cert")}))
+	keyPem  = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+)
+
+func TestV1Functions(t *testing.T) {
+	funcMap := template.FuncMap{
+		"backend": func() string {
+			return `{"db":{"hosts":[{"name":"primary"},{"name":"replica"}]},"key.with.dots":"dotted"}`
+		},
+		"cert":  func() string { return certPem },
+		"key":   func() string { return keyPem },
+		"empty": func() string { return "" },
+		// list builds test input, it is not part of the v1 library
+		"list": func(v ...interface{}) []interface{} { return v },
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{name: "b64enc", template: `<< "s3cr3t" | b64enc >>`, want: "czNjcjN0"},
+		{name: "b64dec", template: `<< "czNjcjN0" | b64dec >>`, want: "s3cr3t"},
+		{name: "b64dec invalid", template: `<< "not base64!" | b64dec >>`, wantErr: "b64dec"},
+		{name: "toJson", template: `<< fromJson "{\"b\":1,\"a\":[true]}" | toJson >>`, want: `{"a":[true],"b":1}`},
+		{name: "fromJson keeps numbers", template: `<< (fromJson "{\"port\":5432}").port >>`, want: "5432"},
+		{name: "fromJson invalid", template: `<< fromJson "{" >>`, wantErr: "fromJson"},
+		{name: "toYaml", template: `<< fromJson "{\"user\":\"app\"}" | toYaml >>`, want: "user: app"},
+		{name: "fromYaml", template: `<< (fromYaml "user: app").user >>`, want: "app"},
+		{name: "fromYaml invalid", template: `<< fromYaml "user: [" >>`, wantErr: "fromYaml"},
+		{name: "jq key", template: `<< backend | jq ".db.hosts[0].name" >>`, want: "primary"},
+		{name: "jq negative index", template: `<< backend | jq ".db.hosts[-1].name" >>`, want: "replica"},
+		{name: "jq quoted key", template: `<< backend | jq ".[\"key.with.dots\"]" >>`, want: "dotted"},
+		{name: "jq parsed input", template: `<< fromYaml "a: {b: c}" | jq ".a.b" >>`, want: "c"},
+		{name: "jq identity", template: `<< "[1,2]" | jq "." | toJson >>`, want: "[1,2]"},
+		{name: "jq missing key", template: `<< backend | jq ".db.port" >>`, wantErr: `key "port" not found`},
+		{name: "jq index out of range", template: `<< backend | jq ".db.hosts[2]" >>`, wantErr: "index out of range"},
+		{name: "jq index of object", template: `<< backend | jq ".db[0]" >>`, wantErr: "cannot index non-array"},
+		{name: "jq key of array", template: `<< backend | jq ".db.hosts.name" >>`, wantErr: "cannot get key"},
+		{name: "jq path without dot", template: `<< backend | jq "db" >>`, wantErr: "must start with ."},
+		{name: "jq unterminated index", template: `<< backend | jq ".db[0" >>`, wantErr: "unterminated"},
+		{name: "jq invalid index", template: `<< backend | jq ".db[x]" >>`, wantErr: "invalid index"},
+		{name: "jq input not json", template: `<< "plain" | jq ".a" >>`, wantErr: "input is not json"},
+		{name: "htpasswd username with colon", template: `<< htpasswd "a:b" "password" >>`, wantErr: "must not contain :"},
+		{name: "pemBundle", template: `<< pemBundle cert key >>`, want: certPem + keyPem},
+		{name: "pemBundle list", template: `<< pemBundle (list cert key) >>`, want: certPem + keyPem},
+		{name: "pemBundle not pem", template: `<< pemBundle cert "plain" >>`, wantErr: "argument 2 is not PEM encoded"},
+		{name: "pemBundle trailing text", template: `<< pemBundle (printf "%s%s" cert "trailing") >>`, wantErr: "argument 1 is not PEM encoded"},
+		{name: "pemBundle unsupported argument", template: `<< pemBundle 1 >>`, wantErr: "unsupported argument type int"},
+		{name: "default of empty", template: `<< empty | default "fallback" >>`, want: "fallback"},
+		{name: "default of value", template: `<< "value" | default "fallback" >>`, want: "value"},
+		{name: "default of zero", template: `<< 0 | default 5 >>`, want: "5"},
+		{name: "required", template: `<< "value" | required "db password" >>`, want: "value"},
+		{name: "required empty", template: `<< empty | required "db password" >>`, wantErr: "required value is missing: db password"},
+		{name: "upper", template: `<< upper "MiXed" >>`, want: "MIXED"},
+		{name: "lower", template: `<< lower "MiXed" >>`, want: "mixed"},
+		{name: "title", template: `<< title "hello wORLD" >>`, want: "Hello World"},
+		{name: "trim", template: `<< trim "  padded\n" >>`, want: "padded"},
+		{name: "camelCase", template: `<< camelCase "db_host-name" >>`, want: "dbHostName"},
+		{name: "camelCase acronym", template: `<< camelCase "HTTPServer_port" >>`, want: "httpServerPort"},
+		{name: "snakeCase", template: `<< snakeCase "dbHostName" >>`, want: "db_host_name"},
+		{name: "snakeCase digits", template: `<< snakeCase "api2Key v3" >>`, want: "api2_key_v3"},
+		{name: "snakeCase acronym", template: `<< snakeCase "HTTPServer" >>`, want: "http_server"},
+		{name: "kebabCase", template: `<< kebabCase "DB Host_Name" >>`, want: "db-host-name"},
+		{name: "kebabCase separators only", template: `<< kebabCase "__--" >>`, want: ""},
+		{name: "sprig function", template: `<< sha256sum "value" >>`, wantErr: `function "sha256sum" not defined`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Render(test.template, V1, funcMap, nil)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Render error = %v, want %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("Render = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBcryptReusesMatchingHash(t *testing.T) {
+	first, err := Render(`<< bcrypt "s3cr3t" >>`, V1, nil, nil)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword(first, []byte("s3cr3t")); err != nil {
+		t.Fatalf("bcrypt does not match the password: %v", err)
+	}
+
+	again, err := Render(`<< bcrypt "s3cr3t" >>`, V1, nil, first)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(again) != string(first) {
+		t.Errorf("bcrypt rendered a new hash %s for an unchanged password, want %s", again, first)
+	}
+
+	changed, err := Render(`<< bcrypt "changed" >>`, V1, nil, first)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(changed) == string(first) {
+		t.Errorf("bcrypt kept the hash of the previous password")
+	}
+	if err := bcrypt.CompareHashAndPassword(changed, []byte("changed")); err != nil {
+		t.Errorf("bcrypt does not match the changed password: %v", err)
+	}
+}
+
+func TestHtpasswdReusesMatchingHash(t *testing.T) {
+	text := `<< htpasswd "alice" "a-pass" >>
+<< htpasswd "bob" "b-pass" >>`
+	first, err := Render(text, V1, nil, nil)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	for _, line := range strings.Split(string(first), "\n") {
+		user, hash, _ := strings.Cut(line, ":")
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(user[:1]+"-pass")); err != nil {
+			t.Errorf("htpasswd entry %s does not match the password: %v", line, err)
+		}
+	}
+
+	again, err := Render(text, V1, nil, first)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if string(again) != string(first) {
+		t.Errorf("htpasswd rendered %s for unchanged passwords, want %s", again, first)
+	}
+}
+
+func TestRenderUnknownVersion(t *testing.T) {
+	if _, err := Render(`value`, "v0", nil, nil); err == nil || !strings.Contains(err.Error(), "unknown template function version") {
+		t.Errorf("Render error = %v, want unknown template function version", err)
+	}
+}
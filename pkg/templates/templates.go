@@ -0,0 +1,71 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	dockcmdCommon "github.com/boxboat/dockcmd/cmd/common"
+)
+
+const (
+	// Sprig renders templates with the sprig function library, this is the default for compatibility
+	Sprig = "sprig"
+	// V1 renders templates with the curated v1 function library
+	V1 = "v1"
+
+	LeftDelim  = "<<"
+	RightDelim = ">>"
+)
+
+// versions maps each function library version to its functions
+var versions = map[string]template.FuncMap{
+	V1: v1FuncMap(),
+}
+
+// Render executes text as a template using the function library version along with the backend functions in
+// funcMap. An empty version uses the Sprig library. previous is the value rendered by the last sync, the bcrypt hashes
+// of the v1 library are reused from it while they match.
+func Render(text string, version string, funcMap template.FuncMap, previous []byte) ([]byte, error) {
+	if version == "" || version == Sprig {
+		return dockcmdCommon.ParseSecretsTemplate([]byte(text), funcMap)
+	}
+
+	functions, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown template function version %s", version)
+	}
+
+	tpl, err := template.New("secret").
+		Delims(LeftDelim, RightDelim).
+		Funcs(functions).
+		Funcs(hashFuncs(previous)).
+		Funcs(funcMap).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := tpl.Execute(&out, nil); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
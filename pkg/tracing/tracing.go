@@ -0,0 +1,98 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer of the operator spans
+const TracerName = "github.com/boxboat/dockhand-secrets-operator"
+
+// Standard attributes of spans
+const (
+	AttributeKind      = attribute.Key("dockhand.kind")
+	AttributeNamespace = attribute.Key("k8s.namespace.name")
+	AttributeName      = attribute.Key("dockhand.name")
+	AttributeProfile   = attribute.Key("dockhand.profile")
+	AttributeBackend   = attribute.Key("dockhand.backend")
+	AttributeFunction  = attribute.Key("dockhand.function")
+)
+
+// Setup exports the spans of service to the OTLP gRPC collector at endpoint host:port. Spans are not recorded when
+// endpoint is empty. The returned function flushes the pending spans and stops the exporter.
+func Setup(ctx context.Context, service string, endpoint string, insecure bool) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	provider := NewTracerProvider(service, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// NewTracerProvider returns the TracerProvider of service with its span processors, e.g. a syncer of an in-process
+// exporter
+func NewTracerProvider(service string, options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	serviceResource, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", service)))
+	if err != nil {
+		serviceResource = resource.Default()
+	}
+	return sdktrace.NewTracerProvider(append([]sdktrace.TracerProviderOption{sdktrace.WithResource(serviceResource)}, options...)...)
+}
+
+// Start starts the span name, a child of the span of ctx when there is one
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends span, recording err as its error status when it is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ObjectAttributes returns the attributes of the object kind namespace/name
+func ObjectAttributes(kind string, namespace string, name string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttributeKind.String(kind),
+		AttributeNamespace.String(namespace),
+		AttributeName.String(name),
+	}
+}
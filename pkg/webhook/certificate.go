@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// certificateReloadInterval is the interval at which certificate files are checked for changes
+const certificateReloadInterval = 10 * time.Second
+
+// CertificateReloader holds the certificate served by the webhook and swaps it when its source changes, so that
+// renewed certificates are served without restarting the webhook
+type CertificateReloader struct {
+	certificate atomic.Pointer[tls.Certificate]
+}
+
+// Certificate returns the served certificate, nil until one is loaded
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	return r.certificate.Load()
+}
+
+// GetCertificate is the tls.Config callback that returns the served certificate
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := r.certificate.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("no certificate loaded")
+}
+
+// WatchFiles serves the key pair of certFile and keyFile and reloads it when the files change until ctx is done
+func (r *CertificateReloader) WatchFiles(ctx context.Context, certFile string, keyFile string) error {
+	certPem, keyPem, err := readKeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if err := r.store(certPem, keyPem, certFile); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(certificateReloadInterval):
+			}
+			newCertPem, newKeyPem, err := readKeyPair(certFile, keyFile)
+			if err != nil {
+				webhookLog.Warnf("unable to read certificate %s: %v", certFile, err)
+				continue
+			}
+			if bytes.Equal(newCertPem, certPem) && bytes.Equal(newKeyPem, keyPem) {
+				continue
+			}
+			// the files of a mounted Secret are not swapped together, a mismatched pair is retried on the next check
+			if err := r.store(newCertPem, newKeyPem, certFile); err != nil {
+				webhookLog.Warnf("unable to reload certificate %s: %v", certFile, err)
+				continue
+			}
+			certPem, keyPem = newCertPem, newKeyPem
+		}
+	}()
+	return nil
+}
+
+// WatchSecret serves the key pair of the kubernetes TLS Secret namespace/name and reloads it when the Secret changes
+// until ctx is done
+func (r *CertificateReloader) WatchSecret(ctx context.Context, name string, namespace string) error {
+	source := namespace + "/" + name
+	return k8s.WatchSecret(ctx, name, namespace, func(secret *corev1.Secret) {
+		if err := r.store(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], source); err != nil {
+			webhookLog.Warnf("unable to load certificate %s: %v", source, err)
+		}
+	})
+}
+
+// store serves the key pair certPem and keyPem loaded from source when it differs from the served certificate
+func (r *CertificateReloader) store(certPem []byte, keyPem []byte, source string) error {
+	keyPair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return err
+	}
+	if current := r.certificate.Load(); current != nil && bytes.Equal(current.Certificate[0], keyPair.Certificate[0]) {
+		return nil
+	}
+	if err := common.CheckCertificateValid(&keyPair); err != nil {
+		webhookLog.Warnf("serving certificate %s: %v", source, err)
+	}
+	r.certificate.Store(&keyPair)
+	webhookLog.Infof("serving certificate %s valid for %d days", source, common.ValidDaysRemaining(keyPair.Certificate[0]))
+	return nil
+}
+
+func readKeyPair(certFile string, keyFile string) ([]byte, []byte, error) {
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPem, keyPem, nil
+}
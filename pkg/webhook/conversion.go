@@ -0,0 +1,154 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	dockhandv1alpha2 "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dockhandv1beta1 "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Convert method for the CRD conversion webhook
+func (server *Server) Convert(w http.ResponseWriter, r *http.Request) {
+	log := webhookLog.With(common.Fields{common.FieldCorrelationID: common.NewCorrelationID()})
+	var body []byte
+	if r.Body != nil {
+		if data, err := io.ReadAll(r.Body); err == nil {
+			body = data
+		}
+	}
+	if len(body) == 0 {
+		log.Error("empty body")
+		http.Error(w, "empty body", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		log.Errorf("Content-Type=%s, expect application/json", contentType)
+		http.Error(w, "invalid Content-Type, expect `application/json`", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	review := &apiextensionsv1.ConversionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		log.Errorf("Can't decode body: %v", err)
+		http.Error(w, "could not decode ConversionReview", http.StatusBadRequest)
+		return
+	}
+
+	log = webhookLog.With(common.Fields{common.FieldCorrelationID: string(review.Request.UID)})
+	review.Response = convertObjects(log, review.Request)
+	review.Request = nil
+
+	resp, err := json.Marshal(review)
+	if err != nil {
+		log.Errorf("Can't encode response: %v", err)
+		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write(resp); err != nil {
+		log.Errorf("Can't write response: %v", err)
+	}
+}
+
+func convertObjects(log *common.Logger, req *apiextensionsv1.ConversionRequest) *apiextensionsv1.ConversionResponse {
+	response := &apiextensionsv1.ConversionResponse{
+		UID: req.UID,
+	}
+	for _, obj := range req.Objects {
+		converted, err := convertObject(obj.Raw, req.DesiredAPIVersion)
+		if err != nil {
+			log.Warnf("conversion to %s failed: %v", req.DesiredAPIVersion, err)
+			response.ConvertedObjects = nil
+			response.Result = metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+			}
+			return response
+		}
+		response.ConvertedObjects = append(response.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+	response.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return response
+}
+
+func convertObject(raw []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return nil, err
+	}
+	if typeMeta.APIVersion == desiredAPIVersion {
+		return raw, nil
+	}
+
+	alpha := dockhandv1alpha2.SchemeGroupVersion.String()
+	beta := dockhandv1beta1.SchemeGroupVersion.String()
+
+	switch {
+	case typeMeta.Kind == "Secret" && typeMeta.APIVersion == alpha && desiredAPIVersion == beta:
+		src := &dockhandv1alpha2.Secret{}
+		dst := &dockhandv1beta1.Secret{}
+		if err := json.Unmarshal(raw, src); err != nil {
+			return nil, err
+		}
+		if err := src.ConvertTo(dst); err != nil {
+			return nil, err
+		}
+		return json.Marshal(dst)
+	case typeMeta.Kind == "Secret" && typeMeta.APIVersion == beta && desiredAPIVersion == alpha:
+		src := &dockhandv1beta1.Secret{}
+		dst := &dockhandv1alpha2.Secret{}
+		if err := json.Unmarshal(raw, src); err != nil {
+			return nil, err
+		}
+		if err := dst.ConvertFrom(src); err != nil {
+			return nil, err
+		}
+		return json.Marshal(dst)
+	case typeMeta.Kind == "Profile" && typeMeta.APIVersion == alpha && desiredAPIVersion == beta:
+		src := &dockhandv1alpha2.Profile{}
+		dst := &dockhandv1beta1.Profile{}
+		if err := json.Unmarshal(raw, src); err != nil {
+			return nil, err
+		}
+		if err := src.ConvertTo(dst); err != nil {
+			return nil, err
+		}
+		return json.Marshal(dst)
+	case typeMeta.Kind == "Profile" && typeMeta.APIVersion == beta && desiredAPIVersion == alpha:
+		src := &dockhandv1beta1.Profile{}
+		dst := &dockhandv1alpha2.Profile{}
+		if err := json.Unmarshal(raw, src); err != nil {
+			return nil, err
+		}
+		if err := dst.ConvertFrom(src); err != nil {
+			return nil, err
+		}
+		return json.Marshal(dst)
+	}
+
+	return nil, fmt.Errorf("unsupported conversion of %s %s to %s", typeMeta.APIVersion, typeMeta.Kind, desiredAPIVersion)
+}
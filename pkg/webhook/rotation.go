@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of a CA rotation recorded in the TLS Secret of the webhook. The CA bundle trusts the old and the new CA while
+// the serving certificate is switched, so that the API server trusts the certificate served by every replica.
+const (
+	// CARotationTrustNewCA the CA bundle trusts the new CA, the old serving certificate is still served
+	CARotationTrustNewCA = "TrustNewCA"
+	// CARotationRemoveOldCA the serving certificate is signed by the new CA, the CA bundle still trusts the old CA
+	CARotationRemoveOldCA = "RemoveOldCA"
+)
+
+var certManagerLog = common.ComponentLogger("certmanager")
+
+// Keys of the TLS Secret of the webhook
+const (
+	CABundleKey = "ca.crt"
+	// nextCertKey and nextKeyKey hold the serving certificate signed by the new CA until it is served
+	nextCertKey = "next-tls.crt"
+	nextKeyKey  = "next-tls.key"
+	// nextCAKey holds the new CA until it is the only CA of the bundle
+	nextCAKey = "next-ca.crt"
+)
+
+const (
+	// certificateRenewalDays is the remaining validity below which the serving certificate is renewed
+	certificateRenewalDays = 30
+	// caRotationPhaseDuration is the time each phase of a CA rotation lasts, which lets the API server load the CA
+	// bundle and the webhook replicas reload the serving certificate before the next phase
+	caRotationPhaseDuration = 2 * time.Minute
+)
+
+// RotateCertificate returns the TLS Secret of the webhook serviceName in namespace updated by the next step of the
+// rotation of its self-signed CA and serving certificate, or nil when tlsSecret needs no change. tlsSecret is nil
+// when it does not exist. The CA bundle to configure is in the CABundleKey of the Secret.
+func RotateCertificate(tlsSecret *corev1.Secret, serviceName string, namespace string, now time.Time) (*corev1.Secret, error) {
+	if tlsSecret == nil {
+		tlsSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: serviceName, Namespace: namespace},
+			Type:       corev1.SecretTypeTLS,
+		}
+	}
+	phase := tlsSecret.Annotations[dockhand.CARotationPhaseAnnotationKey]
+	if phase != "" && !phaseElapsed(tlsSecret, now) {
+		return nil, nil
+	}
+
+	updated := tlsSecret.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = make(map[string][]byte)
+	}
+	if updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+
+	switch phase {
+	case CARotationTrustNewCA:
+		if _, err := tls.X509KeyPair(updated.Data[nextCertKey], updated.Data[nextKeyKey]); err != nil {
+			certManagerLog.Warnf("restarting the CA rotation of %s/%s, the certificate of the new CA is not valid: %v", namespace, serviceName, err)
+			abandonRotation(updated)
+			break
+		}
+		certManagerLog.Infof("serving the certificate of the new CA of %s/%s", namespace, serviceName)
+		updated.Data[corev1.TLSCertKey] = updated.Data[nextCertKey]
+		updated.Data[corev1.TLSPrivateKeyKey] = updated.Data[nextKeyKey]
+		delete(updated.Data, nextCertKey)
+		delete(updated.Data, nextKeyKey)
+		setPhase(updated, CARotationRemoveOldCA, now)
+		return updated, nil
+	case CARotationRemoveOldCA:
+		if len(updated.Data[nextCAKey]) == 0 {
+			// the CA bundle trusts the new CA as well as the old one, keep it rather than trusting no CA
+			certManagerLog.Warnf("ending the CA rotation of %s/%s without removing the old CA, the new CA is missing", namespace, serviceName)
+			abandonRotation(updated)
+			break
+		}
+		certManagerLog.Infof("removing the old CA of %s/%s from the CA bundle", namespace, serviceName)
+		updated.Data[CABundleKey] = updated.Data[nextCAKey]
+		delete(updated.Data, nextCAKey)
+		delete(updated.Annotations, dockhand.CARotationPhaseAnnotationKey)
+		delete(updated.Annotations, dockhand.CARotationTimestampAnnotationKey)
+		return updated, nil
+	}
+
+	serving, err := servingCertificate(updated)
+	if err == nil && common.ValidDaysRemaining(serving.Certificate[0]) >= certificateRenewalDays {
+		if phase != "" {
+			// the abandoned rotation is removed from the Secret
+			return updated, nil
+		}
+		return nil, nil
+	}
+
+	caPem, certPem, keyPem, err := generateCertificate(serviceName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if serving == nil || common.CheckCertificateValid(serving) != nil || len(updated.Data[CABundleKey]) == 0 {
+		// nothing trusts or serves a valid certificate yet so there is no trust to keep
+		certManagerLog.Infof("issuing self signed certificate %s/%s", namespace, serviceName)
+		updated.Data[corev1.TLSCertKey] = certPem
+		updated.Data[corev1.TLSPrivateKeyKey] = keyPem
+		updated.Data[CABundleKey] = caPem
+		return updated, nil
+	}
+
+	certManagerLog.Infof("adding a new CA of %s/%s to the CA bundle", namespace, serviceName)
+	updated.Data[CABundleKey] = appendPem(updated.Data[CABundleKey], caPem)
+	updated.Data[nextCAKey] = caPem
+	updated.Data[nextCertKey] = certPem
+	updated.Data[nextKeyKey] = keyPem
+	setPhase(updated, CARotationTrustNewCA, now)
+	return updated, nil
+}
+
+// generateCertificate returns a new CA and a serving certificate and key of serviceName signed by it
+func generateCertificate(serviceName string, namespace string) ([]byte, []byte, []byte, error) {
+	caPem, caKey, err := common.GenerateSelfSignedCA(serviceName + "-ca")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dnsNames := []string{
+		serviceName + "." + namespace,
+		serviceName + "." + namespace + ".svc"}
+	certPem, keyPem, err := common.GenerateSignedCert(serviceName, dnsNames, caPem, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return caPem, certPem, keyPem, nil
+}
+
+// servingCertificate returns the serving certificate of tlsSecret
+func servingCertificate(tlsSecret *corev1.Secret) (*tls.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(tlsSecret.Data[corev1.TLSCertKey], tlsSecret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair, nil
+}
+
+// phaseElapsed returns whether the current phase of the CA rotation of tlsSecret has lasted caRotationPhaseDuration
+func phaseElapsed(tlsSecret *corev1.Secret, now time.Time) bool {
+	started, err := time.Parse(time.RFC3339, tlsSecret.Annotations[dockhand.CARotationTimestampAnnotationKey])
+	return err != nil || now.Sub(started) >= caRotationPhaseDuration
+}
+
+func setPhase(tlsSecret *corev1.Secret, phase string, now time.Time) {
+	tlsSecret.Annotations[dockhand.CARotationPhaseAnnotationKey] = phase
+	tlsSecret.Annotations[dockhand.CARotationTimestampAnnotationKey] = now.UTC().Format(time.RFC3339)
+}
+
+// abandonRotation removes the CA rotation in progress from tlsSecret, which keeps serving its current certificate. A
+// new CA left in the CA bundle is removed by the next rotation.
+func abandonRotation(tlsSecret *corev1.Secret) {
+	delete(tlsSecret.Data, nextCAKey)
+	delete(tlsSecret.Data, nextCertKey)
+	delete(tlsSecret.Data, nextKeyKey)
+	delete(tlsSecret.Annotations, dockhand.CARotationPhaseAnnotationKey)
+	delete(tlsSecret.Annotations, dockhand.CARotationTimestampAnnotationKey)
+}
+
+// appendPem returns the PEM bundle of bundle followed by pem
+func appendPem(bundle []byte, pem []byte) []byte {
+	appended := append([]byte(nil), bytes.TrimRight(bundle, "\n")...)
+	if len(appended) > 0 {
+		appended = append(appended, '\n')
+	}
+	return append(appended, pem...)
+}
@@ -0,0 +1,363 @@
+/*
+Copyright © 2021 BoxBoat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testServiceName = "webhook"
+	testNamespace   = "dockhand"
+)
+
+// testCA is a CA signing the serving certificates of the tests
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: testServiceName + "-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{certificate: certificate, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// servingCertificate returns a serving certificate of the webhook signed by the CA that is valid for validity and its
+// key in pem
+func (ca *testCA) servingCertificate(t *testing.T, validity time.Duration) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: testServiceName},
+		DNSNames:     []string{testServiceName + "." + testNamespace + ".svc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newTLSSecret returns the TLS Secret of the webhook with data in the CA rotation phase started at started
+func newTLSSecret(data map[string][]byte, phase string, started time.Time) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: testServiceName, Namespace: testNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       data,
+	}
+	if phase != "" {
+		secret.Annotations = map[string]string{
+			dockhand.CARotationPhaseAnnotationKey:     phase,
+			dockhand.CARotationTimestampAnnotationKey: started.UTC().Format(time.RFC3339),
+		}
+	}
+	return secret
+}
+
+// assertServes fails the test unless certPem and keyPem are a key pair of the webhook trusted by the CA bundle
+func assertServes(t *testing.T, certPem []byte, keyPem []byte, bundle []byte) {
+	t.Helper()
+	keyPair, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		t.Fatalf("serving certificate is not a key pair: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		t.Fatal("CA bundle contains no certificate")
+	}
+	if _, err := certificate.Verify(x509.VerifyOptions{
+		DNSName: testServiceName + "." + testNamespace + ".svc",
+		Roots:   roots,
+	}); err != nil {
+		t.Errorf("serving certificate is not trusted by the CA bundle: %v", err)
+	}
+}
+
+// countCertificates returns the number of certificates of the pem bundle
+func countCertificates(bundle []byte) int {
+	count := 0
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		count++
+	}
+	return count
+}
+
+func assertPhase(t *testing.T, secret *corev1.Secret, phase string, started time.Time) {
+	t.Helper()
+	if got := secret.Annotations[dockhand.CARotationPhaseAnnotationKey]; got != phase {
+		t.Errorf("phase = %q, want %q", got, phase)
+	}
+	want := ""
+	if phase != "" {
+		want = started.UTC().Format(time.RFC3339)
+	}
+	if got := secret.Annotations[dockhand.CARotationTimestampAnnotationKey]; got != want {
+		t.Errorf("phase timestamp = %q, want %q", got, want)
+	}
+}
+
+func TestRotateCertificate(t *testing.T) {
+	started := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	expiringCert, expiringKey := oldCA.servingCertificate(t, 10*24*time.Hour)
+	validCert, validKey := oldCA.servingCertificate(t, 365*24*time.Hour)
+	nextCert, nextKey := newCA.servingCertificate(t, 365*24*time.Hour)
+	bothCAs := appendPem(oldCA.pem, newCA.pem)
+
+	tests := []struct {
+		name    string
+		secret  *corev1.Secret
+		elapsed time.Duration
+		// check is called with the updated Secret, it is nil when the Secret needs no change
+		check func(t *testing.T, updated *corev1.Secret, now time.Time)
+	}{
+		{
+			name: "first issue",
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				if updated.Name != testServiceName || updated.Namespace != testNamespace || updated.Type != corev1.SecretTypeTLS {
+					t.Errorf("Secret %s/%s of type %s, want a TLS Secret %s/%s", updated.Namespace, updated.Name, updated.Type, testNamespace, testServiceName)
+				}
+				if count := countCertificates(updated.Data[CABundleKey]); count != 1 {
+					t.Errorf("CA bundle contains %d certificates, want 1", count)
+				}
+				assertServes(t, updated.Data[corev1.TLSCertKey], updated.Data[corev1.TLSPrivateKeyKey], updated.Data[CABundleKey])
+				assertPhase(t, updated, "", now)
+			},
+		},
+		{
+			name: "valid certificate",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       validCert,
+				corev1.TLSPrivateKeyKey: validKey,
+				CABundleKey:             oldCA.pem,
+			}, "", started),
+		},
+		{
+			name: "renewal trusts the new CA",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       expiringCert,
+				corev1.TLSPrivateKeyKey: expiringKey,
+				CABundleKey:             oldCA.pem,
+			}, "", started),
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				if !bytes.Equal(updated.Data[corev1.TLSCertKey], expiringCert) {
+					t.Error("serving certificate changed before the API server trusts the new CA")
+				}
+				bundle := updated.Data[CABundleKey]
+				if count := countCertificates(bundle); count != 2 || !bytes.HasPrefix(bundle, oldCA.pem) {
+					t.Errorf("CA bundle contains %d certificates, want the old and the new CA", count)
+				}
+				if !bytes.HasSuffix(bundle, updated.Data[nextCAKey]) {
+					t.Error("CA bundle does not contain the new CA")
+				}
+				assertServes(t, updated.Data[nextCertKey], updated.Data[nextKeyKey], updated.Data[nextCAKey])
+				assertPhase(t, updated, CARotationTrustNewCA, now)
+			},
+		},
+		{
+			name: "phase not elapsed",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       expiringCert,
+				corev1.TLSPrivateKeyKey: expiringKey,
+				CABundleKey:             bothCAs,
+				nextCAKey:               newCA.pem,
+				nextCertKey:             nextCert,
+				nextKeyKey:              nextKey,
+			}, CARotationTrustNewCA, started),
+			elapsed: caRotationPhaseDuration - time.Second,
+		},
+		{
+			name: "new certificate served",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       expiringCert,
+				corev1.TLSPrivateKeyKey: expiringKey,
+				CABundleKey:             bothCAs,
+				nextCAKey:               newCA.pem,
+				nextCertKey:             nextCert,
+				nextKeyKey:              nextKey,
+			}, CARotationTrustNewCA, started),
+			elapsed: caRotationPhaseDuration,
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				if !bytes.Equal(updated.Data[corev1.TLSCertKey], nextCert) || !bytes.Equal(updated.Data[corev1.TLSPrivateKeyKey], nextKey) {
+					t.Error("serving certificate is not the certificate of the new CA")
+				}
+				if !bytes.Equal(updated.Data[CABundleKey], bothCAs) {
+					t.Error("CA bundle changed while the old certificate may still be served")
+				}
+				for _, key := range []string{nextCertKey, nextKeyKey} {
+					if _, ok := updated.Data[key]; ok {
+						t.Errorf("%s was not removed", key)
+					}
+				}
+				assertPhase(t, updated, CARotationRemoveOldCA, now)
+			},
+		},
+		{
+			name: "old CA removed",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       nextCert,
+				corev1.TLSPrivateKeyKey: nextKey,
+				CABundleKey:             bothCAs,
+				nextCAKey:               newCA.pem,
+			}, CARotationRemoveOldCA, started),
+			elapsed: caRotationPhaseDuration,
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				if !bytes.Equal(updated.Data[CABundleKey], newCA.pem) {
+					t.Error("CA bundle is not only the new CA")
+				}
+				if _, ok := updated.Data[nextCAKey]; ok {
+					t.Errorf("%s was not removed", nextCAKey)
+				}
+				assertServes(t, updated.Data[corev1.TLSCertKey], updated.Data[corev1.TLSPrivateKeyKey], updated.Data[CABundleKey])
+				assertPhase(t, updated, "", now)
+			},
+		},
+		{
+			name: "new certificate missing",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       expiringCert,
+				corev1.TLSPrivateKeyKey: expiringKey,
+				CABundleKey:             bothCAs,
+				nextCAKey:               newCA.pem,
+			}, CARotationTrustNewCA, started),
+			elapsed: caRotationPhaseDuration,
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				// the rotation starts again with another new CA
+				if !bytes.Equal(updated.Data[corev1.TLSCertKey], expiringCert) || !bytes.Equal(updated.Data[corev1.TLSPrivateKeyKey], expiringKey) {
+					t.Error("serving certificate changed")
+				}
+				if bytes.Equal(updated.Data[nextCAKey], newCA.pem) {
+					t.Error("rotation kept the new CA without its certificate")
+				}
+				assertServes(t, expiringCert, expiringKey, updated.Data[CABundleKey])
+				assertServes(t, updated.Data[nextCertKey], updated.Data[nextKeyKey], updated.Data[CABundleKey])
+				assertPhase(t, updated, CARotationTrustNewCA, now)
+			},
+		},
+		{
+			name: "new CA missing",
+			secret: newTLSSecret(map[string][]byte{
+				corev1.TLSCertKey:       nextCert,
+				corev1.TLSPrivateKeyKey: nextKey,
+				CABundleKey:             bothCAs,
+			}, CARotationRemoveOldCA, started),
+			elapsed: caRotationPhaseDuration,
+			check: func(t *testing.T, updated *corev1.Secret, now time.Time) {
+				if !bytes.Equal(updated.Data[CABundleKey], bothCAs) {
+					t.Error("CA bundle changed without the new CA")
+				}
+				assertServes(t, updated.Data[corev1.TLSCertKey], updated.Data[corev1.TLSPrivateKeyKey], updated.Data[CABundleKey])
+				assertPhase(t, updated, "", now)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := started.Add(test.elapsed)
+			var original *corev1.Secret
+			if test.secret != nil {
+				original = test.secret.DeepCopy()
+			}
+			updated, err := RotateCertificate(test.secret, testServiceName, testNamespace, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.secret != nil && !equalSecrets(test.secret, original) {
+				t.Error("RotateCertificate modified its tlsSecret")
+			}
+			if test.check == nil {
+				if updated != nil {
+					t.Errorf("Secret was updated with annotations %v", updated.Annotations)
+				}
+				return
+			}
+			if updated == nil {
+				t.Fatal("Secret was not updated")
+			}
+			test.check(t, updated, now)
+		})
+	}
+}
+
+func equalSecrets(a *corev1.Secret, b *corev1.Secret) bool {
+	if len(a.Data) != len(b.Data) || len(a.Annotations) != len(b.Annotations) {
+		return false
+	}
+	for k, v := range a.Data {
+		if !bytes.Equal(v, b.Data[k]) {
+			return false
+		}
+	}
+	for k, v := range a.Annotations {
+		if b.Annotations[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -26,9 +26,15 @@ import (
 	"strings"
 	"time"
 
-	dockhandv2 "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1alpha2"
+	dockhand "github.com/boxboat/dockhand-secrets-operator/pkg/apis/dhs.dockhand.dev/v1beta1"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/common"
 	"github.com/boxboat/dockhand-secrets-operator/pkg/k8s"
+	"github.com/boxboat/dockhand-secrets-operator/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -37,6 +43,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 )
 
+var webhookLog = common.ComponentLogger("webhook")
+
 type Server struct {
 	Server        *http.Server
 	runtimeScheme *runtime.Scheme
@@ -59,35 +67,35 @@ func (server *Server) Init() {
 // Check labels for whether the target resource needs to be mutated
 func mutationRequired(labels map[string]string) bool {
 	inject := false
-	if _, ok := labels[dockhandv2.AutoUpdateLabelKey]; ok {
+	if _, ok := labels[dockhand.AutoUpdateLabelKey]; ok {
 		inject = true
 	}
 	return inject
 }
 
 // main mutation process
-func (server *Server) mutate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func (server *Server) mutate(ctx context.Context, log *common.Logger, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
 
 	if req.Kind.Kind == "DaemonSet" {
-		return server.mutateDaemonSet(ar)
+		return server.mutateDaemonSet(ctx, log, ar)
 	} else if req.Kind.Kind == "Deployment" {
-		return server.mutateDeployment(ar)
+		return server.mutateDeployment(ctx, log, ar)
 	} else if req.Kind.Kind == "StatefulSet" {
-		return server.mutateStatefulSet(ar)
+		return server.mutateStatefulSet(ctx, log, ar)
 	}
-	common.Log.Debugf("Unhandled kind presented for mutation for %v", req)
+	log.Debugf("Unhandled kind presented for mutation for %v", req)
 	return &admissionv1.AdmissionResponse{
 		Allowed: true,
 	}
 }
 
-func (server *Server) mutateDaemonSet(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func (server *Server) mutateDaemonSet(ctx context.Context, log *common.Logger, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
 	ds := &appsv1.DaemonSet{}
 
 	if err := json.Unmarshal(req.Object.Raw, &ds); err != nil {
-		common.Log.Errorf("Could not unmarshal raw object: %v", err)
+		log.Errorf("Could not unmarshal raw object: %v", err)
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
@@ -95,7 +103,7 @@ func (server *Server) mutateDaemonSet(ar *admissionv1.AdmissionReview) *admissio
 		}
 	}
 
-	common.Log.Debugf(
+	log.Debugf(
 		"AdmissionReview for Kind=%s, Namespace=%s Name=%s (%s) UID=%s patchOperation=%s UserInfo=%s",
 		req.Kind,
 		req.Namespace,
@@ -105,17 +113,19 @@ func (server *Server) mutateDaemonSet(ar *admissionv1.AdmissionReview) *admissio
 		req.Operation,
 		req.UserInfo)
 
-	common.Log.Debugf("ds.Labels[%v]", ds.Labels)
+	log.Debugf("ds.Labels[%v]", ds.Labels)
 
 	// determine whether to perform mutation
 	if !mutationRequired(ds.Labels) {
-		common.Log.Debugf("Skipping mutation for %s/%s due to policy check", ds.Namespace, ds.Name)
+		log.Debugf("Skipping mutation for %s/%s due to policy check", ds.Namespace, ds.Name)
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
 
 	labels, annotations := processDockhandSecretAnnotations(
+		ctx,
+		log,
 		ds.Labels,
 		ds.Spec.Template.Annotations,
 		ds.Namespace,
@@ -130,7 +140,7 @@ func (server *Server) mutateDaemonSet(ar *admissionv1.AdmissionReview) *admissio
 		}
 	}
 
-	common.Log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
+	log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
 	return &admissionv1.AdmissionResponse{
 		UID:     ar.Request.UID,
 		Allowed: true,
@@ -142,12 +152,12 @@ func (server *Server) mutateDaemonSet(ar *admissionv1.AdmissionReview) *admissio
 	}
 }
 
-func (server *Server) mutateDeployment(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func (server *Server) mutateDeployment(ctx context.Context, log *common.Logger, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
 	deployment := &appsv1.Deployment{}
 
 	if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
-		common.Log.Errorf("Could not unmarshal raw object: %v", err)
+		log.Errorf("Could not unmarshal raw object: %v", err)
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
@@ -155,7 +165,7 @@ func (server *Server) mutateDeployment(ar *admissionv1.AdmissionReview) *admissi
 		}
 	}
 
-	common.Log.Debugf(
+	log.Debugf(
 		"AdmissionReview for Kind=%s, Namespace=%s Name=%s (%s) UID=%s patchOperation=%s UserInfo=%s",
 		req.Kind,
 		req.Namespace,
@@ -165,17 +175,19 @@ func (server *Server) mutateDeployment(ar *admissionv1.AdmissionReview) *admissi
 		req.Operation,
 		req.UserInfo)
 
-	common.Log.Debugf("deployment.Labels[%v]", deployment.Labels)
+	log.Debugf("deployment.Labels[%v]", deployment.Labels)
 
 	// determine whether to perform mutation
 	if !mutationRequired(deployment.Labels) {
-		common.Log.Debugf("Skipping mutation for %s/%s due to policy check", deployment.Namespace, deployment.Name)
+		log.Debugf("Skipping mutation for %s/%s due to policy check", deployment.Namespace, deployment.Name)
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
 
 	labels, annotations := processDockhandSecretAnnotations(
+		ctx,
+		log,
 		deployment.Labels,
 		deployment.Spec.Template.Annotations,
 		deployment.Namespace,
@@ -190,7 +202,7 @@ func (server *Server) mutateDeployment(ar *admissionv1.AdmissionReview) *admissi
 		}
 	}
 
-	common.Log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
+	log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
 	return &admissionv1.AdmissionResponse{
 		UID:     ar.Request.UID,
 		Allowed: true,
@@ -202,12 +214,12 @@ func (server *Server) mutateDeployment(ar *admissionv1.AdmissionReview) *admissi
 	}
 }
 
-func (server *Server) mutateStatefulSet(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+func (server *Server) mutateStatefulSet(ctx context.Context, log *common.Logger, ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
 	statefulset := &appsv1.StatefulSet{}
 
 	if err := json.Unmarshal(req.Object.Raw, &statefulset); err != nil {
-		common.Log.Errorf("Could not unmarshal raw object: %v", err)
+		log.Errorf("Could not unmarshal raw object: %v", err)
 		return &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
@@ -215,7 +227,7 @@ func (server *Server) mutateStatefulSet(ar *admissionv1.AdmissionReview) *admiss
 		}
 	}
 
-	common.Log.Debugf(
+	log.Debugf(
 		"AdmissionReview for Kind=%s, Namespace=%s Name=%s (%s) UID=%s patchOperation=%s UserInfo=%s",
 		req.Kind,
 		req.Namespace,
@@ -225,16 +237,18 @@ func (server *Server) mutateStatefulSet(ar *admissionv1.AdmissionReview) *admiss
 		req.Operation,
 		req.UserInfo)
 
-	common.Log.Debugf("statefulset.Labels[%v]", statefulset.Labels)
+	log.Debugf("statefulset.Labels[%v]", statefulset.Labels)
 
 	// determine whether to perform mutation
 	if !mutationRequired(statefulset.Labels) {
-		common.Log.Debugf("Skipping mutation for %s/%s due to policy check", statefulset.Namespace, statefulset.Name)
+		log.Debugf("Skipping mutation for %s/%s due to policy check", statefulset.Namespace, statefulset.Name)
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
 	labels, annotations := processDockhandSecretAnnotations(
+		ctx,
+		log,
 		statefulset.Labels,
 		statefulset.Spec.Template.Annotations,
 		statefulset.Namespace,
@@ -249,7 +263,7 @@ func (server *Server) mutateStatefulSet(ar *admissionv1.AdmissionReview) *admiss
 		}
 	}
 
-	common.Log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
+	log.Debugf("AdmissionResponse: patch=[%s]", string(patchBytes))
 	return &admissionv1.AdmissionResponse{
 		UID:     ar.Request.UID,
 		Allowed: true,
@@ -263,6 +277,7 @@ func (server *Server) mutateStatefulSet(ar *admissionv1.AdmissionReview) *admiss
 
 // Serve method for webhook server
 func (server *Server) Serve(w http.ResponseWriter, r *http.Request) {
+	log := webhookLog.With(common.Fields{common.FieldCorrelationID: common.NewCorrelationID()})
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -270,7 +285,7 @@ func (server *Server) Serve(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if len(body) == 0 {
-		common.Log.Error("empty body")
+		log.Error("empty body")
 		http.Error(w, "empty body", http.StatusBadRequest)
 		return
 	}
@@ -278,7 +293,7 @@ func (server *Server) Serve(w http.ResponseWriter, r *http.Request) {
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
-		common.Log.Errorf("Content-Type=%s, expect application/json", contentType)
+		log.Errorf("Content-Type=%s, expect application/json", contentType)
 		http.Error(w, "invalid Content-Type, expect `application/json`", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -286,14 +301,26 @@ func (server *Server) Serve(w http.ResponseWriter, r *http.Request) {
 	var admissionResponse *admissionv1.AdmissionResponse
 	ar := &admissionv1.AdmissionReview{}
 	if err := json.Unmarshal(body, ar); err != nil {
-		common.Log.Errorf("Can't decode body: %v", err)
+		log.Errorf("Can't decode body: %v", err)
 		admissionResponse = &admissionv1.AdmissionResponse{
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
 		}
 	} else {
-		admissionResponse = server.mutate(ar)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		var span trace.Span
+		if ar.Request != nil {
+			log = requestLog(ar.Request)
+			ctx, span = tracing.Start(ctx, "mutate", requestAttributes(ar.Request)...)
+		} else {
+			ctx, span = tracing.Start(ctx, "mutate")
+		}
+		admissionResponse = server.mutate(ctx, log, ar)
+		if admissionResponse.Result != nil && admissionResponse.Result.Message != "" {
+			span.SetStatus(codes.Error, admissionResponse.Result.Message)
+		}
+		span.End()
 	}
 
 	admissionReview := admissionv1.AdmissionReview{
@@ -311,16 +338,34 @@ func (server *Server) Serve(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := json.Marshal(admissionReview)
 	if err != nil {
-		common.Log.Errorf("Can't encode response: %v", err)
+		log.Errorf("Can't encode response: %v", err)
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
 	}
-	common.Log.Debugf("Ready to write response ...")
+	log.Debugf("Ready to write response ...")
 	if _, err := w.Write(resp); err != nil {
-		common.Log.Errorf("Can't write response: %v", err)
+		log.Errorf("Can't write response: %v", err)
 		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
 
+// requestLog returns the Logger of an admission request, the UID of the request is the correlation ID of its entries
+func requestLog(req *admissionv1.AdmissionRequest) *common.Logger {
+	return webhookLog.With(common.Fields{
+		common.FieldCorrelationID: string(req.UID),
+		common.FieldKind:          req.Kind.Kind,
+		common.FieldNamespace:     req.Namespace,
+		common.FieldName:          req.Name,
+	})
+}
+
+// requestAttributes returns the span attributes of an admission request
+func requestAttributes(req *admissionv1.AdmissionRequest) []attribute.KeyValue {
+	return append(
+		tracing.ObjectAttributes(req.Kind.Kind, req.Namespace, req.Name),
+		attribute.String("k8s.admission.uid", string(req.UID)),
+		attribute.String("k8s.admission.operation", string(req.Operation)))
+}
+
 // create mutation patch for resources
 func createDaemonSetPatch(daemonSet *appsv1.DaemonSet, labels map[string]string, annotations map[string]string) ([]byte, error) {
 	var patch []k8s.PatchOperation
@@ -346,6 +391,8 @@ func createStatefulSetPatch(statefulSet *appsv1.StatefulSet, labels map[string]s
 
 // Similar behavior to pkg.controller.getUpdatedLabelsAndAnnotations
 func processDockhandSecretAnnotations(
+	ctx context.Context,
+	log *common.Logger,
 	labels map[string]string,
 	annotations map[string]string,
 	namespace string,
@@ -359,17 +406,21 @@ func processDockhandSecretAnnotations(
 
 	if len(secrets) > 0 {
 		// block for no more than 15 seconds
+		checksumCtx, span := tracing.Start(ctx, "checksum secrets", tracing.AttributeNamespace.String(namespace))
 		attempt := 0
 		for {
-			if checksum, err := k8s.GetSecretsChecksum(context.Background(), secrets, namespace); err == nil {
-				updatedAnnotations[dockhandv2.SecretChecksumAnnotationKey] = checksum
+			if checksum, err := k8s.GetSecretsChecksum(checksumCtx, secrets, namespace); err == nil {
+				updatedAnnotations[dockhand.SecretChecksumAnnotationKey] = checksum
+				span.End()
 				break
 			} else {
+				span.AddEvent("checksum attempt failed", trace.WithAttributes(attribute.Int("attempt", attempt+1)))
 				if attempt < 5 {
-					common.Log.Warnf("unable to calculate checksum - retrying:[%v]", err)
+					log.Warnf("unable to calculate checksum - retrying:[%v]", err)
 				} else {
-					common.Log.Warnf("unable to calculate checksum after 5th attempt:[%v]", err)
-					updatedAnnotations[dockhandv2.SecretChecksumAnnotationKey] = ""
+					log.Warnf("unable to calculate checksum after 5th attempt:[%v]", err)
+					updatedAnnotations[dockhand.SecretChecksumAnnotationKey] = ""
+					tracing.End(span, err)
 					break
 				}
 			}
@@ -377,21 +428,21 @@ func processDockhandSecretAnnotations(
 			time.Sleep(3 * time.Second)
 		}
 
-		dhSecrets, err := k8s.GetDockhandSecretsListFromK8sSecrets(context.Background(), secrets, namespace)
+		dhSecrets, err := k8s.GetDockhandSecretsListFromK8sSecrets(ctx, secrets, namespace)
 		if err != nil {
-			common.Log.Warnf("%v", err)
+			log.Warnf("%v", err)
 		}
 
 		for key, label := range updatedLabels {
-			if strings.HasPrefix(label, dockhandv2.DockhandSecretNamesLabelPrefixKey) {
+			if strings.HasPrefix(label, dockhand.DockhandSecretNamesLabelPrefixKey) {
 				delete(updatedLabels, key)
 			}
 		}
 		for _, dhSecret := range dhSecrets {
-			updatedLabels[dockhandv2.DockhandSecretNamesLabelPrefixKey+dhSecret] = "true"
+			updatedLabels[dockhand.DockhandSecretNamesLabelPrefixKey+dhSecret] = "true"
 		}
 
-		updatedAnnotations[dockhandv2.SecretNamesAnnotationKey] = strings.Join(secrets, ",")
+		updatedAnnotations[dockhand.SecretNamesAnnotationKey] = strings.Join(secrets, ",")
 	}
 
 	return updatedLabels, updatedAnnotations